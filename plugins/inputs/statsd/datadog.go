@@ -136,6 +136,79 @@ func (s *Statsd) parseEventMessage(now time.Time, message, defaultHostname strin
 	return nil
 }
 
+func (s *Statsd) parseServiceCheck(now time.Time, message, defaultHostname string) error {
+	// _sc|name|status
+	//  [
+	//   |d:timestamp
+	//   |h:hostname
+	//   |#tag1,tag2
+	//   |m:message
+	//  ]
+	//
+	// status is 0 (ok), 1 (warning), 2 (critical) or 3 (unknown), the message
+	// is the last field and may contain pipes
+	rawFields := strings.SplitN(message, "|", 4)
+	if len(rawFields) < 3 || rawFields[0] != "_sc" {
+		return errors.New("invalid service check format")
+	}
+	name := rawFields[1]
+	if name == "" {
+		return errors.New("invalid service check format: empty name")
+	}
+	status, err := strconv.ParseInt(rawFields[2], 10, 64)
+	if err != nil || status < 0 || status > 3 {
+		return fmt.Errorf("invalid service check status: %q", rawFields[2])
+	}
+
+	tags := make(map[string]string, 2)
+	tags["check"] = name
+	if defaultHostname != "" {
+		tags["source"] = defaultHostname
+	}
+	fields := map[string]interface{}{"status": status}
+	if len(rawFields) < 4 {
+		s.acc.AddFields("statsd_service_check", fields, tags, now)
+		return nil
+	}
+
+	metadata := rawFields[3]
+	for metadata != "" {
+		var field string
+		if strings.HasPrefix(metadata, "m:") {
+			field, metadata = metadata, ""
+		} else {
+			field, metadata, _ = strings.Cut(metadata, "|")
+		}
+		if len(field) < 2 {
+			return errors.New("too short metadata field")
+		}
+		switch field[:2] {
+		case "d:":
+			ts, err := strconv.ParseInt(field[2:], 10, 64)
+			if err != nil {
+				continue
+			}
+			fields["ts"] = ts
+		case "h:":
+			tags["source"] = field[2:]
+		case "m:":
+			fields["message"] = uncommenter.Replace(field[2:])
+		default:
+			if field[0] != '#' {
+				return fmt.Errorf("unknown metadata type: %q", field)
+			}
+			parseDataDogTags(tags, field[1:])
+		}
+	}
+	// Use source tag because host is reserved tag key in Telegraf.
+	if host, ok := tags["host"]; ok {
+		delete(tags, "host")
+		tags["source"] = host
+	}
+	s.acc.AddFields("statsd_service_check", fields, tags, now)
+	return nil
+}
+
 func parseDataDogTags(tags map[string]string, message string) {
 	if len(message) == 0 {
 		return
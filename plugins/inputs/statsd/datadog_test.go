@@ -1,6 +1,7 @@
 package statsd
 
 import (
+	"bytes"
 	"testing"
 	"time"
 
@@ -483,3 +484,109 @@ func TestEventError(t *testing.T) {
 	err = s.parseEventMessage(now, "_e{5,4}:title|text|x:1234", "default-hostname")
 	require.Error(t, err)
 }
+
+func TestServiceCheck(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name           string
+		message        string
+		expectedTags   map[string]string
+		expectedFields map[string]interface{}
+	}{
+		{
+			name:           "ok",
+			message:        "_sc|agent.up|0",
+			expectedTags:   map[string]string{"check": "agent.up", "source": "default-hostname"},
+			expectedFields: map[string]interface{}{"status": int64(0)},
+		},
+		{
+			name:           "warning with tags",
+			message:        "_sc|agent.up|1|#env:prod,canary",
+			expectedTags:   map[string]string{"check": "agent.up", "source": "default-hostname", "env": "prod", "canary": "true"},
+			expectedFields: map[string]interface{}{"status": int64(1)},
+		},
+		{
+			name:           "critical with timestamp and hostname",
+			message:        "_sc|agent.up|2|d:21|h:localhost",
+			expectedTags:   map[string]string{"check": "agent.up", "source": "localhost"},
+			expectedFields: map[string]interface{}{"status": int64(2), "ts": int64(21)},
+		},
+		{
+			name:         "unknown with message",
+			message:      "_sc|agent.up|3|d:21|#host:foo|m:no data|really\\nnone",
+			expectedTags: map[string]string{"check": "agent.up", "source": "foo"},
+			expectedFields: map[string]interface{}{
+				"status":  int64(3),
+				"ts":      int64(21),
+				"message": "no data|really\nnone",
+			},
+		},
+		{
+			name:           "invalid timestamp",
+			message:        "_sc|agent.up|0|d:abc",
+			expectedTags:   map[string]string{"check": "agent.up", "source": "default-hostname"},
+			expectedFields: map[string]interface{}{"status": int64(0)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acc := &testutil.Accumulator{}
+			s := newTestStatsd()
+			s.acc = acc
+
+			require.NoError(t, s.parseServiceCheck(now, tt.message, "default-hostname"))
+			require.Len(t, acc.Metrics, 1)
+			m := acc.Metrics[0]
+			require.Equal(t, "statsd_service_check", m.Measurement)
+			require.Equal(t, tt.expectedTags, m.Tags)
+			require.Equal(t, tt.expectedFields, m.Fields)
+			require.Equal(t, now, m.Time)
+		})
+	}
+}
+
+func TestServiceCheckError(t *testing.T) {
+	now := time.Now()
+	s := newTestStatsd()
+	s.acc = &testutil.Accumulator{}
+
+	for _, message := range []string{
+		"_sc",
+		"_sc|agent.up",
+		"_sc||0",
+		"_sc|agent.up|4",
+		"_sc|agent.up|-1",
+		"_sc|agent.up|ok",
+		"_sc|agent.up|0|x",
+		"_sc|agent.up|0|x:foo",
+	} {
+		require.Error(t, s.parseServiceCheck(now, message, "default-hostname"), message)
+	}
+}
+
+func TestServiceCheckGather(t *testing.T) {
+	plugin := &Statsd{
+		Log:                 testutil.Logger{},
+		Protocol:            "udp",
+		ServiceAddress:      "localhost:0",
+		DataDogExtensions:   true,
+		NumberWorkerThreads: 1,
+	}
+	acc := &testutil.Accumulator{}
+	require.NoError(t, plugin.Start(acc))
+	defer plugin.Stop()
+
+	// Malformed service checks are skipped without affecting other lines
+	lines := "_sc|agent.up|9\n_sc|agent.up|0|#env:prod\ncpu.load:1|g"
+	plugin.in <- input{Buffer: bytes.NewBufferString(lines), Time: time.Now()}
+	require.Eventually(t, func() bool {
+		return acc.NMetrics() == 1 && len(plugin.in) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, plugin.Gather(acc))
+	require.True(t, acc.HasMeasurement("cpu_load"))
+	acc.AssertContainsTaggedFields(t, "statsd_service_check",
+		map[string]interface{}{"status": int64(0)},
+		map[string]string{"check": "agent.up", "env": "prod"},
+	)
+}
@@ -0,0 +1,106 @@
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// maxGeoIPCacheSize bounds the number of cached lookups, the cache is
+// cleared when the limit is reached
+const maxGeoIPCacheSize = 10000
+
+// geoipAttributes are the supported database attributes to tag metrics with
+var geoipAttributes = map[string]bool{
+	"country_code": true,
+	"country_name": true,
+	"city_name":    true,
+}
+
+// geoipRecord is the subset of a GeoIP2 or GeoLite2 city or country record
+// used for tagging
+type geoipRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+}
+
+// geoipResolver looks up the location of source addresses in a MaxMind
+// database and caches the resulting tags per address
+type geoipResolver struct {
+	db   *maxminddb.Reader
+	tags map[string]string
+
+	sync.Mutex
+	cache map[string]map[string]string
+}
+
+func newGeoIPResolver(path string, tags map[string]string) (*geoipResolver, error) {
+	if len(tags) == 0 {
+		tags = map[string]string{"country_code": "country"}
+	}
+	for attribute := range tags {
+		if !geoipAttributes[attribute] {
+			return nil, fmt.Errorf("invalid geoip_tags attribute %q", attribute)
+		}
+	}
+
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening GeoIP database failed: %w", err)
+	}
+
+	return &geoipResolver{
+		db:    db,
+		tags:  tags,
+		cache: make(map[string]map[string]string),
+	}, nil
+}
+
+// lookup returns the tags for the given address, addresses not found in the
+// database result in no tags
+func (r *geoipResolver) lookup(addr string) map[string]string {
+	r.Lock()
+	defer r.Unlock()
+
+	if tags, found := r.cache[addr]; found {
+		return tags
+	}
+
+	var tags map[string]string
+	var record geoipRecord
+	if ip := net.ParseIP(addr); ip != nil && r.db.Lookup(ip, &record) == nil {
+		tags = make(map[string]string, len(r.tags))
+		for attribute, key := range r.tags {
+			var value string
+			switch attribute {
+			case "country_code":
+				value = record.Country.ISOCode
+			case "country_name":
+				value = record.Country.Names["en"]
+			case "city_name":
+				value = record.City.Names["en"]
+			}
+			if value != "" {
+				tags[key] = value
+			}
+		}
+	}
+
+	if len(r.cache) >= maxGeoIPCacheSize {
+		r.cache = make(map[string]map[string]string)
+	}
+	r.cache[addr] = tags
+
+	return tags
+}
+
+func (r *geoipResolver) close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,31 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoIPResolver(t *testing.T) {
+	r, err := newGeoIPResolver("testdata/geoip-city-test.mmdb", map[string]string{
+		"country_code": "country",
+		"city_name":    "city",
+	})
+	require.NoError(t, err)
+	defer r.close()
+
+	require.Equal(t, map[string]string{"country": "GB", "city": "London"}, r.lookup("81.2.69.142"))
+	require.Equal(t, map[string]string{"country": "DE", "city": "Berlin"}, r.lookup("2.125.160.216"))
+	require.Empty(t, r.lookup("10.0.0.1"))
+	require.Empty(t, r.lookup("::1"))
+	require.Empty(t, r.lookup("invalid"))
+	require.Len(t, r.cache, 5)
+}
+
+func TestGeoIPResolverInvalid(t *testing.T) {
+	_, err := newGeoIPResolver("testdata/geoip-city-test.mmdb", map[string]string{"asn": "asn"})
+	require.ErrorContains(t, err, `invalid geoip_tags attribute "asn"`)
+
+	_, err = newGeoIPResolver("testdata/nonexistent.mmdb", nil)
+	require.ErrorContains(t, err, "opening GeoIP database failed")
+}
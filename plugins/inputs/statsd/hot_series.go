@@ -0,0 +1,73 @@
+package statsd
+
+import (
+	"sort"
+	"strings"
+)
+
+// hotSeries counts the updates per series within an interval to find the
+// most frequently updated series. The zero value is ready to use.
+type hotSeries struct {
+	counts map[string]*seriesUpdates
+}
+
+// seriesUpdates holds the number of updates of a series
+type seriesUpdates struct {
+	series  string
+	updates int64
+}
+
+// add counts the given number of updates of the series with the given hash
+func (h *hotSeries) add(hash, name string, tags map[string]string, updates int64) {
+	if h.counts == nil {
+		h.counts = make(map[string]*seriesUpdates)
+	}
+
+	entry, found := h.counts[hash]
+	if !found {
+		entry = &seriesUpdates{series: formatSeries(name, tags)}
+		h.counts[hash] = entry
+	}
+	entry.updates += updates
+}
+
+// top returns the given number of series with the most updates in descending
+// order, series with equal counts are ordered by name
+func (h *hotSeries) top(n int) []seriesUpdates {
+	entries := make([]seriesUpdates, 0, len(h.counts))
+	for _, entry := range h.counts {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].updates != entries[j].updates {
+			return entries[i].updates > entries[j].updates
+		}
+		return entries[i].series < entries[j].series
+	})
+	return entries[:min(n, len(entries))]
+}
+
+// reset clears the counts of the interval
+func (h *hotSeries) reset() {
+	clear(h.counts)
+}
+
+// formatSeries formats the series as name followed by the tags sorted by key,
+// e.g. "requests,host=a,metric_type=counter"
+func formatSeries(name string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(name)
+	for _, k := range keys {
+		sb.WriteString(",")
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(tags[k])
+	}
+	return sb.String()
+}
@@ -0,0 +1,32 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotSeries(t *testing.T) {
+	var h hotSeries
+	require.Empty(t, h.top(3))
+
+	for range 5 {
+		h.add("b", "requests", map[string]string{"host": "b"}, 1)
+	}
+	h.add("a", "requests", map[string]string{"host": "a"}, 1)
+	h.add("c", "errors", nil, 1)
+	for range 3 {
+		h.add("d", "latency", map[string]string{"metric_type": "timing", "host": "a"}, 1)
+	}
+
+	expected := []seriesUpdates{
+		{series: "requests,host=b", updates: 5},
+		{series: "latency,host=a,metric_type=timing", updates: 3},
+		{series: "errors", updates: 1},
+	}
+	require.Equal(t, expected, h.top(3))
+	require.Len(t, h.top(10), 4)
+
+	h.reset()
+	require.Empty(t, h.top(3))
+}
@@ -0,0 +1,84 @@
+package statsd
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultInternTTL is the duration a string stays in the intern pool without
+// being used if no TTL is configured
+const defaultInternTTL = time.Hour
+
+// internPool deduplicates the names and the tag keys and values of the parsed
+// metrics so that series sharing a tag value also share its memory. The strings are
+// copied when added to the pool to not retain the received message they are
+// sliced from, also if the pool is full. The pool holds at most limit strings,
+// strings unused for the TTL are removed when pruning.
+type internPool struct {
+	sync.Mutex
+	strings map[string]internEntry
+	limit   int
+	ttl     time.Duration
+}
+
+type internEntry struct {
+	value    string
+	lastUsed time.Time
+}
+
+func newInternPool(limit int, ttl time.Duration) *internPool {
+	if ttl <= 0 {
+		ttl = defaultInternTTL
+	}
+	return &internPool{
+		strings: make(map[string]internEntry),
+		limit:   limit,
+		ttl:     ttl,
+	}
+}
+
+// internSeries returns the name and the tags with the keys and values
+// replaced by the strings of the pool, adding unknown strings as long as the
+// pool is not full and copying them otherwise
+func (p *internPool) internSeries(name string, tags map[string]string, now time.Time) (string, map[string]string) {
+	p.Lock()
+	defer p.Unlock()
+
+	interned := make(map[string]string, len(tags))
+	for k, v := range tags {
+		interned[p.intern(k, now)] = p.intern(v, now)
+	}
+	return p.intern(name, now), interned
+}
+
+// intern returns the pooled string equal to s. Must be called with the lock
+// held.
+func (p *internPool) intern(s string, now time.Time) string {
+	if entry, found := p.strings[s]; found {
+		entry.lastUsed = now
+		p.strings[s] = entry
+		return entry.value
+	}
+	if len(p.strings) >= p.limit {
+		// Still detach the string from the received message
+		return strings.Clone(s)
+	}
+
+	value := strings.Clone(s)
+	p.strings[value] = internEntry{value: value, lastUsed: now}
+	return value
+}
+
+// prune removes the strings not used within the TTL, the strings stay valid
+// for the series already using them
+func (p *internPool) prune(now time.Time) {
+	p.Lock()
+	defer p.Unlock()
+
+	for s, entry := range p.strings {
+		if now.Sub(entry.lastUsed) >= p.ttl {
+			delete(p.strings, s)
+		}
+	}
+}
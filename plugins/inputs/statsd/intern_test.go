@@ -0,0 +1,96 @@
+package statsd
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInternPool(t *testing.T) {
+	p := newInternPool(3, time.Minute)
+	now := time.Now()
+
+	// Equal strings share the same memory
+	_, a := p.internSeries("requests", map[string]string{"region": strings.Clone("eu-west-1")}, now)
+	_, b := p.internSeries("requests", map[string]string{"region": strings.Clone("eu-west-1")}, now)
+	require.Equal(t, a, b)
+	require.Equal(t, unsafe.StringData(a["region"]), unsafe.StringData(b["region"]))
+	require.Len(t, p.strings, 3)
+
+	// Strings exceeding the limit are not pooled
+	name, c := p.internSeries("latency", map[string]string{"region": "us-east-1"}, now)
+	require.Equal(t, "latency", name)
+	require.Equal(t, map[string]string{"region": "us-east-1"}, c)
+	require.Len(t, p.strings, 3)
+
+	// Unused strings are removed after the TTL
+	p.internSeries("requests", map[string]string{"region": "eu-west-1"}, now.Add(30*time.Second))
+	p.prune(now.Add(time.Minute))
+	require.Len(t, p.strings, 3)
+	p.prune(now.Add(90 * time.Second))
+	require.Empty(t, p.strings)
+}
+
+func TestInternTags(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			s := newTestStatsd()
+			if enabled {
+				s.interns = newInternPool(100, time.Hour)
+			}
+			require.NoError(t, s.parseStatsdLine("requests,host=a,region=eu-west-1:1|c"))
+			require.NoError(t, s.parseStatsdLine("requests,host=b,region=eu-west-1:1|c"))
+			require.Len(t, s.counters, 2)
+
+			var regions []string
+			for _, m := range s.counters {
+				regions = append(regions, m.tags["region"])
+			}
+			require.Equal(t, regions[0], regions[1])
+			shared := unsafe.StringData(regions[0]) == unsafe.StringData(regions[1])
+			require.Equal(t, enabled, shared)
+		})
+	}
+}
+
+func BenchmarkInternTags(b *testing.B) {
+	const series = 10000
+	regions := []string{"eu-west-1", "eu-central-1", "us-east-1", "us-west-2", "ap-southeast-1"}
+	lines := make([]string, 0, series)
+	for i := range series {
+		region := strings.Repeat(regions[i%len(regions)], 8)
+		lines = append(lines, fmt.Sprintf("requests,host=host%d,region=%s,environment=production:1|c", i, region))
+	}
+
+	for _, poolSize := range []int{0, 1000} {
+		b.Run(fmt.Sprintf("intern_pool_size=%d", poolSize), func(b *testing.B) {
+			var heap float64
+			for range b.N {
+				s := newTestStatsd()
+				if poolSize > 0 {
+					s.interns = newInternPool(poolSize, time.Hour)
+				}
+
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				for _, line := range lines {
+					// Copy the line as received messages are not shared
+					if err := s.parseStatsdLine(strings.Clone(line)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				heap += float64(after.HeapAlloc) - float64(before.HeapAlloc)
+				runtime.KeepAlive(s)
+			}
+			b.ReportMetric(heap/float64(b.N*series), "heap-B/series")
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package statsd
+
+import "sync"
+
+// lineRing keeps the last lines added up to its size, overwriting the oldest
+// line once full.
+type lineRing struct {
+	sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLineRing(size int) *lineRing {
+	return &lineRing{lines: make([]string, size)}
+}
+
+// add stores the line replacing the oldest one if the ring is full
+func (r *lineRing) add(line string) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.lines[r.next] = line
+	r.next++
+	if r.next == len(r.lines) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// snapshot returns a copy of the stored lines from the oldest to the newest
+func (r *lineRing) snapshot() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	lines := make([]string, 0, len(r.lines))
+	lines = append(lines, r.lines[r.next:]...)
+	return append(lines, r.lines[:r.next]...)
+}
@@ -0,0 +1,22 @@
+package statsd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineRing(t *testing.T) {
+	r := newLineRing(3)
+	require.Empty(t, r.snapshot())
+
+	r.add("a:1|c")
+	r.add("b:1|c")
+	require.Equal(t, []string{"a:1|c", "b:1|c"}, r.snapshot())
+
+	for i := range 5 {
+		r.add(fmt.Sprintf("line%d:1|c", i))
+	}
+	require.Equal(t, []string{"line2:1|c", "line3:1|c", "line4:1|c"}, r.snapshot())
+}
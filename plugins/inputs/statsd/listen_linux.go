@@ -0,0 +1,9 @@
+package statsd
+
+import "golang.org/x/sys/unix"
+
+// setReusePort enables port reuse on the given socket, letting the kernel
+// distribute the packets across all sockets bound to the same address
+func setReusePort(fd uintptr) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+}
@@ -0,0 +1,104 @@
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestUDPListeners(t *testing.T) {
+	statsd := &Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "udp",
+		ServiceAddress:         "127.0.0.1:0",
+		AllowedPendingMessages: 1000,
+		NumberWorkerThreads:    2,
+		UDPListeners:           4,
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, statsd.Start(&acc))
+	defer statsd.Stop()
+
+	// All sockets share the same port and have port reuse enabled
+	addr := statsd.UDPlistener.LocalAddr().String()
+	require.Len(t, statsd.udpListeners, 3)
+	for _, conn := range append(statsd.udpListeners, statsd.UDPlistener) {
+		require.Equal(t, addr, conn.LocalAddr().String())
+		rc, err := conn.SyscallConn()
+		require.NoError(t, err)
+		var reuse int
+		var serr error
+		require.NoError(t, rc.Control(func(fd uintptr) {
+			reuse, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT)
+		}))
+		require.NoError(t, serr)
+		require.Equal(t, 1, reuse)
+	}
+
+	// Send from multiple client sockets to spread the packets across the
+	// listeners, the metrics are still aggregated into one series
+	const clients = 16
+	for range clients {
+		conn, err := net.Dial("udp", addr)
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("requests:1|c\n"))
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+	}
+
+	require.Eventually(t, func() bool {
+		acc.ClearMetrics()
+		require.NoError(t, statsd.Gather(&acc))
+		m, found := acc.Get("requests")
+		return found && m.Fields["value"] == int64(clients)
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, acc.Metrics, 1)
+}
+
+func BenchmarkUDPListeners(b *testing.B) {
+	for _, listeners := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("listeners=%d", listeners), func(b *testing.B) {
+			statsd := &Statsd{
+				Log:                    testutil.Logger{},
+				Protocol:               "udp",
+				ServiceAddress:         "127.0.0.1:0",
+				AllowedPendingMessages: 250000,
+				NumberWorkerThreads:    8,
+				UDPListeners:           listeners,
+			}
+			acc := &testutil.Accumulator{Discard: true}
+			require.NoError(b, statsd.Start(acc))
+			defer statsd.Stop()
+			addr := statsd.UDPlistener.LocalAddr().String()
+
+			// Use a socket per producer as the kernel assigns the packets of
+			// a client socket to the same listener
+			conns := make([]net.Conn, 0, producerThreads)
+			for range producerThreads {
+				conn, err := net.Dial("udp", addr)
+				require.NoError(b, err)
+				defer conn.Close()
+				conns = append(conns, conn)
+			}
+
+			b.ResetTimer()
+			for range b.N {
+				var wg sync.WaitGroup
+				for _, conn := range conns {
+					wg.Add(1)
+					go sendRequests(conn, &wg)
+				}
+				wg.Wait()
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(statsd.Stats.UDPPacketsRecv.Get())/float64(b.N), "packets_recv/op")
+		})
+	}
+}
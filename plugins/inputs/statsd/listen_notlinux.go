@@ -0,0 +1,11 @@
+//go:build !linux
+
+package statsd
+
+import "errors"
+
+// setReusePort is only supported on Linux as other platforms either lack the
+// option or do not distribute the packets across the sockets
+func setReusePort(uintptr) error {
+	return errors.New("multiple UDP listeners are only supported on Linux")
+}
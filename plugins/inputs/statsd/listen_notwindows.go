@@ -0,0 +1,27 @@
+//go:build !windows
+
+package statsd
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// setListenBacklog updates the accept backlog of the listening socket by
+// calling listen again, the kernel caps the value at its maximum backlog,
+// e.g. net.core.somaxconn on Linux
+func setListenBacklog(listener *net.TCPListener, backlog int) error {
+	rc, err := listener.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.Listen(int(fd), backlog)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestTCPListenBacklog(t *testing.T) {
+	statsd := Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "tcp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 10000,
+		MaxTCPConnections:      2,
+		NumberWorkerThreads:    5,
+		TCPListenBacklog:       16,
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, statsd.Start(&acc))
+	defer statsd.Stop()
+
+	// The listener must still accept connections after changing the backlog
+	conn, err := net.Dial("tcp", statsd.TCPlistener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("cpu.time_idle:42|c\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool {
+		require.NoError(t, statsd.Gather(&acc))
+		return acc.NMetrics() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package statsd
+
+import (
+	"errors"
+	"net"
+)
+
+// setListenBacklog is not supported on Windows as the backlog of a listening
+// socket cannot be changed
+func setListenBacklog(*net.TCPListener, int) error {
+	return errors.New("setting the listen backlog is not supported on Windows")
+}
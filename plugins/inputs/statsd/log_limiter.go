@@ -0,0 +1,47 @@
+package statsd
+
+import (
+	"sync"
+	"time"
+)
+
+// logLimiter limits the number of messages logged per one-second window and
+// keeps track of the messages suppressed due to the limit.
+type logLimiter struct {
+	sync.Mutex
+	window     time.Time
+	logged     int
+	suppressed int
+}
+
+// allow checks if a message may be logged at the given time with the given
+// rate. If a new window starts, the number of messages suppressed in the
+// previous window is returned to allow the caller to report them.
+func (l *logLimiter) allow(now time.Time, rate int) (ok bool, suppressed int) {
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.window) >= time.Second {
+		suppressed = l.suppressed
+		l.window = now
+		l.logged = 0
+		l.suppressed = 0
+	}
+
+	if l.logged < rate {
+		l.logged++
+		return true, suppressed
+	}
+	l.suppressed++
+	return false, suppressed
+}
+
+// flush returns the number of currently suppressed messages and resets it
+func (l *logLimiter) flush() int {
+	l.Lock()
+	defer l.Unlock()
+
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return suppressed
+}
@@ -0,0 +1,27 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogLimiter(t *testing.T) {
+	var l logLimiter
+	now := time.Now()
+
+	ok, suppressed := l.allow(now, 1)
+	require.True(t, ok)
+	require.Zero(t, suppressed)
+	ok, _ = l.allow(now.Add(100*time.Millisecond), 1)
+	require.False(t, ok)
+	ok, _ = l.allow(now.Add(200*time.Millisecond), 1)
+	require.False(t, ok)
+
+	// A new window reports the messages suppressed in the previous one
+	ok, suppressed = l.allow(now.Add(time.Second), 1)
+	require.True(t, ok)
+	require.Equal(t, 2, suppressed)
+	require.Zero(t, l.flush())
+}
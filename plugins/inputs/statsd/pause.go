@@ -0,0 +1,74 @@
+package statsd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pauseWindow is a daily time window in local time given as offsets since
+// midnight, windows with the start after the end span midnight
+type pauseWindow struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// parsePauseWindow parses a window in the form "HH:MM-HH:MM"
+func parsePauseWindow(window string) (pauseWindow, error) {
+	from, to, found := strings.Cut(window, "-")
+	if !found {
+		return pauseWindow{}, fmt.Errorf("window %q is not in the form HH:MM-HH:MM", window)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return pauseWindow{}, fmt.Errorf("invalid start of window %q: %w", window, err)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return pauseWindow{}, fmt.Errorf("invalid end of window %q: %w", window, err)
+	}
+	if start.Equal(end) {
+		return pauseWindow{}, fmt.Errorf("empty window %q", window)
+	}
+
+	midnight := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)
+	return pauseWindow{start: start.Sub(midnight), end: end.Sub(midnight)}, nil
+}
+
+// contains checks if the given time falls into the window
+func (w pauseWindow) contains(t time.Time) bool {
+	t = t.Local()
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+	return offset >= w.start || offset < w.end
+}
+
+// Pause suppresses the output of Gather until Resume is called. Metrics
+// received in the meantime are accumulated or discarded depending on the
+// pause mode.
+func (s *Statsd) Pause() {
+	s.paused.Store(true)
+}
+
+// Resume restarts emitting metrics on Gather after Pause was called, pause
+// windows still apply
+func (s *Statsd) Resume() {
+	s.paused.Store(false)
+}
+
+// isPaused checks if the plugin is paused at the given time, either by Pause
+// or by a pause window
+func (s *Statsd) isPaused(now time.Time) bool {
+	if s.paused.Load() {
+		return true
+	}
+	for _, w := range s.pauseWindows {
+		if w.contains(now) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package statsd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyHeaderTimeout limits the time for receiving the PROXY protocol header
+// after accepting a connection
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV1MaxLength is the maximum length of a v1 header including the CRLF
+const proxyV1MaxLength = 107
+
+// proxyV2Signature is the signature starting a v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyConn is a connection with the PROXY protocol header consumed, reading
+// the remaining data buffered while parsing the header first
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	source netip.AddrPort
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// acceptProxyHeader reads the PROXY protocol header of the given connection
+// within proxyHeaderTimeout
+func acceptProxyHeader(conn net.Conn) (*proxyConn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout)); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	source, err := readProxyHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, reader: reader, source: source}, nil
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header as specified in
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt and returns the
+// source address of the proxied connection. The returned address is invalid
+// for headers not carrying an address such as health checks of the proxy
+// ("LOCAL" and "UNKNOWN") in which case the address of the connection applies.
+func readProxyHeader(r *bufio.Reader) (netip.AddrPort, error) {
+	prefix, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("reading header failed: %w", err)
+	}
+	switch {
+	case bytes.HasPrefix(prefix, []byte("PROXY ")):
+		return readProxyHeaderV1(r)
+	case bytes.Equal(prefix, proxyV2Signature):
+		return readProxyHeaderV2(r)
+	}
+	return netip.AddrPort{}, errors.New("missing header")
+}
+
+// readProxyHeaderV1 reads a human-readable header such as
+// "PROXY TCP4 192.0.2.1 192.0.2.2 56324 8125\r\n"
+func readProxyHeaderV1(r *bufio.Reader) (netip.AddrPort, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return netip.AddrPort{}, fmt.Errorf("v1 header exceeds %d bytes", proxyV1MaxLength)
+		}
+		c, err := r.ReadByte()
+		if err != nil {
+			return netip.AddrPort{}, fmt.Errorf("reading v1 header failed: %w", err)
+		}
+		line = append(line, c)
+	}
+
+	parts := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return netip.AddrPort{}, nil
+	}
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return netip.AddrPort{}, fmt.Errorf("malformed v1 header %q", strings.TrimSpace(string(line)))
+	}
+	addr, err := netip.ParseAddr(parts[2])
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid v1 source address: %w", err)
+	}
+	if addr.Is4() != (parts[1] == "TCP4") {
+		return netip.AddrPort{}, fmt.Errorf("v1 source address %s does not match protocol %s", addr, parts[1])
+	}
+	port, err := strconv.ParseUint(parts[4], 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid v1 source port: %w", err)
+	}
+	return netip.AddrPortFrom(addr, uint16(port)), nil
+}
+
+// readProxyHeaderV2 reads a binary header consisting of the signature, the
+// version and command, the address family and protocol, the length of the
+// remaining header and the addresses followed by optional TLVs
+func readProxyHeaderV2(r *bufio.Reader) (netip.AddrPort, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return netip.AddrPort{}, fmt.Errorf("reading v2 header failed: %w", err)
+	}
+	if version := header[12] >> 4; version != 2 {
+		return netip.AddrPort{}, fmt.Errorf("unsupported v2 header version %d", version)
+	}
+	command := header[12] & 0x0f
+	if command > 1 {
+		return netip.AddrPort{}, fmt.Errorf("unsupported v2 command %d", command)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return netip.AddrPort{}, fmt.Errorf("reading v2 addresses failed: %w", err)
+	}
+
+	// The LOCAL command is used for connections of the proxy itself, e.g.
+	// health checks, and unspecified or Unix families carry no IP address
+	if command == 0 {
+		return netip.AddrPort{}, nil
+	}
+	var size int
+	switch header[13] >> 4 {
+	case 1: // AF_INET
+		size = 4
+	case 2: // AF_INET6
+		size = 16
+	default:
+		return netip.AddrPort{}, nil
+	}
+	if len(payload) < 2*size+4 {
+		return netip.AddrPort{}, fmt.Errorf("v2 addresses truncated to %d bytes", len(payload))
+	}
+	addr, _ := netip.AddrFromSlice(payload[:size])
+	port := binary.BigEndian.Uint16(payload[2*size : 2*size+2])
+	return netip.AddrPortFrom(addr, port), nil
+}
@@ -0,0 +1,123 @@
+package statsd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// proxyV2Header builds a v2 header with the given command, family and
+// protocol byte and the given addresses
+func proxyV2Header(command, family byte, src, dst netip.AddrPort) []byte {
+	var addresses []byte
+	if src.IsValid() {
+		addresses = append(addresses, src.Addr().AsSlice()...)
+		addresses = append(addresses, dst.Addr().AsSlice()...)
+		addresses = binary.BigEndian.AppendUint16(addresses, src.Port())
+		addresses = binary.BigEndian.AppendUint16(addresses, dst.Port())
+	}
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	src4 := netip.MustParseAddrPort("192.0.2.1:56324")
+	dst4 := netip.MustParseAddrPort("192.0.2.2:8125")
+	src6 := netip.MustParseAddrPort("[2001:db8::1]:56324")
+	dst6 := netip.MustParseAddrPort("[2001:db8::2]:8125")
+
+	tests := []struct {
+		name     string
+		header   []byte
+		expected netip.AddrPort
+		err      string
+	}{
+		{
+			name:     "v1 tcp4",
+			header:   []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 8125\r\n"),
+			expected: src4,
+		},
+		{
+			name:     "v1 tcp6",
+			header:   []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 8125\r\n"),
+			expected: src6,
+		},
+		{
+			name:   "v1 unknown",
+			header: []byte("PROXY UNKNOWN\r\n"),
+		},
+		{
+			name:   "v1 address mismatch",
+			header: []byte("PROXY TCP4 2001:db8::1 2001:db8::2 56324 8125\r\n"),
+			err:    "does not match protocol TCP4",
+		},
+		{
+			name:   "v1 missing port",
+			header: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324\r\n"),
+			err:    "malformed v1 header",
+		},
+		{
+			name:   "v1 invalid port",
+			header: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 65536 8125\r\n"),
+			err:    "invalid v1 source port",
+		},
+		{
+			name:   "v1 without CRLF",
+			header: bytes.Repeat([]byte("PROXY "), 20),
+			err:    "v1 header exceeds 107 bytes",
+		},
+		{
+			name:     "v2 tcp4",
+			header:   proxyV2Header(1, 0x11, src4, dst4),
+			expected: src4,
+		},
+		{
+			name:     "v2 tcp6",
+			header:   proxyV2Header(1, 0x21, src6, dst6),
+			expected: src6,
+		},
+		{
+			name:   "v2 local",
+			header: proxyV2Header(0, 0x00, netip.AddrPort{}, netip.AddrPort{}),
+		},
+		{
+			name:   "v2 truncated addresses",
+			header: proxyV2Header(1, 0x21, src4, dst4),
+			err:    "v2 addresses truncated to 12 bytes",
+		},
+		{
+			name:   "v2 invalid command",
+			header: proxyV2Header(2, 0x11, src4, dst4),
+			err:    "unsupported v2 command 2",
+		},
+		{
+			name:   "missing header",
+			header: []byte("requests:1|c\n"),
+			err:    "missing header",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append(tt.header, []byte("requests:1|c\n")...)
+			reader := bufio.NewReader(bytes.NewReader(data))
+			source, err := readProxyHeader(reader)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, source)
+
+			// The data following the header is left untouched
+			remaining, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			require.Equal(t, "requests:1|c\n", remaining)
+		})
+	}
+}
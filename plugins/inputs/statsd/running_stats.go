@@ -4,10 +4,14 @@ import (
 	"math"
 	"math/rand"
 	"sort"
+	"time"
+
+	"github.com/caio/go-tdigest"
 )
 
 const defaultPercentileLimit = 1000
 const defaultMedianLimit = 1000
+const defaultTDigestCompression = 100
 
 // runningStats calculates a running mean, variance, standard deviation,
 // lower bound, upper bound, count, and can calculate estimated percentiles.
@@ -20,6 +24,10 @@ type runningStats struct {
 	ex  float64
 	ex2 float64
 
+	// Number of values added since the percentile and median samples were
+	// reset, equal to n unless the samples are reset separately
+	sampled int64
+
 	// Array used to calculate estimated percentiles
 	// We will store a maximum of percLimit values, at which point we will start
 	// randomly replacing old values, hence it is an estimated percentile.
@@ -41,6 +49,31 @@ type runningStats struct {
 	med            []float64
 	medLimit       int
 	medInsertIndex int
+
+	// Timestamped samples used to calculate the percentiles over a sliding
+	// window instead of the values added since the last reset. We will store
+	// a maximum of percLimit samples, at which point we will start dropping
+	// the oldest samples.
+	window        time.Duration
+	samples       []timedSample
+	windowSorted  []float64
+	sortedSamples bool
+
+	// T-digest sketch used to estimate the percentiles and the median with
+	// constant memory instead of storing the values if enabled
+	useDigest bool
+	digest    *tdigest.TDigest
+
+	// Upper bounds of the histogram buckets in ascending order and the number
+	// of values falling into each bucket, values above the largest bound are
+	// only contained in the total count
+	bounds  []float64
+	buckets []int64
+}
+
+type timedSample struct {
+	value float64
+	time  time.Time
 }
 
 func (rs *runningStats) addValue(v float64) {
@@ -51,6 +84,12 @@ func (rs *runningStats) addValue(v float64) {
 		rs.k = v
 		rs.upperBound = v
 		rs.lowerBound = v
+		if rs.useDigest {
+			digest, err := tdigest.New(tdigest.Compression(defaultTDigestCompression))
+			if err == nil {
+				rs.digest = digest
+			}
+		}
 		if rs.percLimit == 0 {
 			rs.percLimit = defaultPercentileLimit
 		}
@@ -58,18 +97,30 @@ func (rs *runningStats) addValue(v float64) {
 			rs.medLimit = defaultMedianLimit
 			rs.medInsertIndex = 0
 		}
-		rs.perc = make([]float64, 0, rs.percLimit)
-		rs.med = make([]float64, 0, rs.medLimit)
+		if rs.digest == nil {
+			rs.perc = make([]float64, 0, rs.percLimit)
+			rs.med = make([]float64, 0, rs.medLimit)
+		}
 	}
 
 	// These are used for the running mean and variance
 	rs.n++
+	rs.sampled++
 	rs.ex += v - rs.k
 	rs.ex2 += (v - rs.k) * (v - rs.k)
 
 	// add to running sum
 	rs.totalSum += v
 
+	if len(rs.bounds) > 0 {
+		if rs.buckets == nil {
+			rs.buckets = make([]int64, len(rs.bounds))
+		}
+		if i := sort.SearchFloat64s(rs.bounds, v); i < len(rs.bounds) {
+			rs.buckets[i]++
+		}
+	}
+
 	// track upper and lower bounds
 	if v > rs.upperBound {
 		rs.upperBound = v
@@ -77,6 +128,13 @@ func (rs *runningStats) addValue(v float64) {
 		rs.lowerBound = v
 	}
 
+	if rs.digest != nil {
+		// The sketch replaces storing the values for percentiles and median,
+		// values it cannot represent like NaN are ignored
+		_ = rs.digest.Add(v)
+		return
+	}
+
 	if len(rs.perc) < rs.percLimit {
 		rs.perc = append(rs.perc, v)
 	} else {
@@ -93,11 +151,83 @@ func (rs *runningStats) addValue(v float64) {
 	rs.medInsertIndex = (rs.medInsertIndex + 1) % rs.medLimit
 }
 
+// resetSamples discards the values used for the percentiles and the median
+// while keeping the running aggregates
+func (rs *runningStats) resetSamples() {
+	rs.sampled = 0
+	rs.perc = rs.perc[:0]
+	rs.sortedPerc = false
+	rs.med = rs.med[:0]
+	rs.medInsertIndex = 0
+	if rs.digest != nil {
+		digest, err := tdigest.New(tdigest.Compression(defaultTDigestCompression))
+		if err == nil {
+			rs.digest = digest
+		}
+	}
+}
+
+// addTimedValue adds the value observed at the given time, keeping it for the
+// percentile calculation over the window if one is configured
+func (rs *runningStats) addTimedValue(v float64, t time.Time) {
+	if rs.window > 0 {
+		limit := rs.percLimit
+		if limit == 0 {
+			limit = defaultPercentileLimit
+		}
+		if len(rs.samples) >= limit {
+			rs.samples = rs.samples[len(rs.samples)-limit+1:]
+		}
+		rs.samples = append(rs.samples, timedSample{value: v, time: t})
+		rs.sortedSamples = false
+	}
+	rs.addValue(v)
+}
+
+// expire removes the samples older than the window before the given time
+func (rs *runningStats) expire(now time.Time) {
+	cutoff := now.Add(-rs.window)
+	i := sort.Search(len(rs.samples), func(i int) bool {
+		return rs.samples[i].time.After(cutoff)
+	})
+	if i > 0 {
+		rs.samples = rs.samples[i:]
+		rs.sortedSamples = false
+	}
+}
+
+// cumulativeBuckets returns the number of values less than or equal to each
+// of the bucket bounds
+func (rs *runningStats) cumulativeBuckets() []int64 {
+	counts := make([]int64, len(rs.bounds))
+	var total int64
+	for i := range rs.bounds {
+		if i < len(rs.buckets) {
+			total += rs.buckets[i]
+		}
+		counts[i] = total
+	}
+	return counts
+}
+
+// percentileCount returns the number of values the percentiles are
+// calculated from
+func (rs *runningStats) percentileCount() int64 {
+	if rs.window > 0 {
+		return int64(len(rs.samples))
+	}
+	return rs.sampled
+}
+
 func (rs *runningStats) mean() float64 {
 	return rs.k + rs.ex/float64(rs.n)
 }
 
 func (rs *runningStats) median() float64 {
+	if rs.digest != nil {
+		return rs.digest.Quantile(0.5)
+	}
+
 	// Need to sort for median, but keep temporal order
 	var values []float64
 	values = append(values, rs.med...)
@@ -140,11 +270,26 @@ func (rs *runningStats) percentile(n float64) float64 {
 		n = 100
 	}
 
-	if !rs.sortedPerc {
+	if rs.digest != nil {
+		return rs.digest.Quantile(n / 100)
+	}
+
+	values := rs.perc
+	if rs.window > 0 {
+		if !rs.sortedSamples {
+			rs.windowSorted = rs.windowSorted[:0]
+			for _, sample := range rs.samples {
+				rs.windowSorted = append(rs.windowSorted, sample.value)
+			}
+			sort.Float64s(rs.windowSorted)
+			rs.sortedSamples = true
+		}
+		values = rs.windowSorted
+	} else if !rs.sortedPerc {
 		sort.Float64s(rs.perc)
 		rs.sortedPerc = true
 	}
 
-	i := float64(len(rs.perc)) * n / float64(100)
-	return rs.perc[max(0, min(int(i), len(rs.perc)-1))]
+	i := float64(len(values)) * n / float64(100)
+	return values[max(0, min(int(i), len(values)-1))]
 }
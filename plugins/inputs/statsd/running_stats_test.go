@@ -1,8 +1,12 @@
 package statsd
 
 import (
+	"fmt"
 	"math"
+	"math/rand"
+	"sort"
 	"testing"
+	"time"
 )
 
 // Test that a single metric is handled correctly
@@ -194,3 +198,150 @@ func TestRunningStats_MedianLimitIndex(t *testing.T) {
 		t.Errorf("Expected %v, got %v", 0, rs.medInsertIndex)
 	}
 }
+
+// Test that percentiles are calculated over the samples within the window.
+func TestRunningStats_Window(t *testing.T) {
+	rs := runningStats{window: time.Minute}
+	start := time.Unix(0, 0)
+
+	rs.addTimedValue(100, start)
+	for i := range 10 {
+		rs.addTimedValue(float64(i+1), start.Add(30*time.Second))
+	}
+	if rs.percentileCount() != 11 {
+		t.Errorf("Expected %v, got %v", 11, rs.percentileCount())
+	}
+	if rs.percentile(100) != 100 {
+		t.Errorf("Expected %v, got %v", 100, rs.percentile(100))
+	}
+
+	rs.expire(start.Add(70 * time.Second))
+	if rs.percentileCount() != 10 {
+		t.Errorf("Expected %v, got %v", 10, rs.percentileCount())
+	}
+	if rs.percentile(100) != 10 {
+		t.Errorf("Expected %v, got %v", 10, rs.percentile(100))
+	}
+	if rs.percentile(50) != 6 {
+		t.Errorf("Expected %v, got %v", 6, rs.percentile(50))
+	}
+
+	rs.expire(start.Add(100 * time.Second))
+	if rs.percentileCount() != 0 {
+		t.Errorf("Expected %v, got %v", 0, rs.percentileCount())
+	}
+}
+
+// Test that the percentile limit is respected by dropping the oldest samples.
+func TestRunningStats_WindowLimit(t *testing.T) {
+	rs := runningStats{window: time.Minute, percLimit: 5}
+	for i := range 10 {
+		rs.addTimedValue(float64(i), time.Unix(int64(i), 0))
+	}
+
+	if len(rs.samples) != 5 {
+		t.Errorf("Expected %v, got %v", 5, len(rs.samples))
+	}
+	if rs.count() != 10 {
+		t.Errorf("Expected %v, got %v", 10, rs.count())
+	}
+	if rs.percentile(0) != 5 {
+		t.Errorf("Expected %v, got %v", 5, rs.percentile(0))
+	}
+}
+
+// Test that the t-digest estimates are close to the exact percentiles for
+// known distributions.
+func TestRunningStats_TDigest(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	distributions := map[string]func() float64{
+		"uniform":     func() float64 { return r.Float64() * 1000 },
+		"normal":      func() float64 { return r.NormFloat64()*50 + 500 },
+		"exponential": func() float64 { return r.ExpFloat64() * 100 },
+	}
+
+	for name, generate := range distributions {
+		t.Run(name, func(t *testing.T) {
+			rs := runningStats{useDigest: true}
+			values := make([]float64, 0, 10000)
+			for range 10000 {
+				v := generate()
+				values = append(values, v)
+				rs.addValue(v)
+			}
+			sort.Float64s(values)
+
+			if len(rs.perc) != 0 || len(rs.med) != 0 {
+				t.Errorf("Expected no stored values, got %v and %v", len(rs.perc), len(rs.med))
+			}
+			if rs.count() != 10000 {
+				t.Errorf("Expected %v, got %v", 10000, rs.count())
+			}
+
+			// The estimate must fall between the exact values one percentile
+			// below and above the requested one
+			for _, p := range []float64{10, 50, 90, 98} {
+				lower := values[int(float64(len(values))*(p-1)/100)]
+				upper := values[int(float64(len(values))*(p+1)/100)]
+				if v := rs.percentile(p); v < lower || v > upper {
+					t.Errorf("Expected percentile %v within [%v, %v], got %v", p, lower, upper, v)
+				}
+			}
+			lower := values[int(float64(len(values))*0.49)]
+			upper := values[int(float64(len(values))*0.51)]
+			if v := rs.median(); v < lower || v > upper {
+				t.Errorf("Expected median within [%v, %v], got %v", lower, upper, v)
+			}
+		})
+	}
+}
+
+func TestRunningStats_ResetSamples(t *testing.T) {
+	for _, useDigest := range []bool{false, true} {
+		t.Run(fmt.Sprintf("tdigest=%v", useDigest), func(t *testing.T) {
+			rs := runningStats{useDigest: useDigest}
+			for _, v := range []float64{10, 50, 30} {
+				rs.addValue(v)
+			}
+			rs.resetSamples()
+			if rs.percentileCount() != 0 {
+				t.Errorf("Expected %v, got %v", 0, rs.percentileCount())
+			}
+
+			// The aggregates are kept while the percentiles only cover the
+			// values added after the reset
+			rs.addValue(20)
+			if rs.count() != 4 {
+				t.Errorf("Expected %v, got %v", 4, rs.count())
+			}
+			if rs.lower() != 10 || rs.upper() != 50 {
+				t.Errorf("Expected bounds [10, 50], got [%v, %v]", rs.lower(), rs.upper())
+			}
+			if rs.median() != 20 {
+				t.Errorf("Expected %v, got %v", 20, rs.median())
+			}
+			if rs.percentile(90) != 20 {
+				t.Errorf("Expected %v, got %v", 20, rs.percentile(90))
+			}
+		})
+	}
+}
+
+func BenchmarkRunningStatsPercentileMethod(b *testing.B) {
+	for _, useDigest := range []bool{false, true} {
+		b.Run(fmt.Sprintf("tdigest=%v", useDigest), func(b *testing.B) {
+			r := rand.New(rand.NewSource(42))
+			b.ReportAllocs()
+			for range b.N {
+				rs := runningStats{percLimit: 100000, medLimit: 100000, useDigest: useDigest}
+				for range 100000 {
+					rs.addValue(r.Float64())
+				}
+				for _, p := range []float64{50, 90, 99} {
+					rs.percentile(p)
+				}
+				rs.median()
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package statsd
+
+import "container/list"
+
+// seriesKey identifies a cached series by its metric type and hash as the
+// same hash might be used in the caches of different types
+type seriesKey struct {
+	mtype string
+	hash  string
+}
+
+// seriesLRU tracks the order in which the cached series were last updated.
+// The zero value is ready to use.
+type seriesLRU struct {
+	order   *list.List
+	entries map[seriesKey]*list.Element
+}
+
+// touch marks the series as the most recently updated one
+func (l *seriesLRU) touch(key seriesKey) {
+	if l.entries == nil {
+		l.order = list.New()
+		l.entries = make(map[seriesKey]*list.Element)
+	}
+
+	if e, found := l.entries[key]; found {
+		l.order.MoveToFront(e)
+		return
+	}
+	l.entries[key] = l.order.PushFront(key)
+}
+
+// evict removes and returns the least recently updated series
+func (l *seriesLRU) evict() (seriesKey, bool) {
+	if l.order == nil || l.order.Len() == 0 {
+		return seriesKey{}, false
+	}
+
+	key := l.order.Remove(l.order.Back()).(seriesKey)
+	delete(l.entries, key)
+	return key, true
+}
+
+// prune removes all series not existing anymore according to the given function
+func (l *seriesLRU) prune(exists func(seriesKey) bool) {
+	if l.order == nil {
+		return
+	}
+
+	for e := l.order.Front(); e != nil; {
+		next := e.Next()
+		if key := e.Value.(seriesKey); !exists(key) {
+			l.order.Remove(e)
+			delete(l.entries, key)
+		}
+		e = next
+	}
+}
+
+func (l *seriesLRU) len() int {
+	return len(l.entries)
+}
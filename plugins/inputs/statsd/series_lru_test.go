@@ -0,0 +1,33 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesLRU(t *testing.T) {
+	var l seriesLRU
+	_, ok := l.evict()
+	require.False(t, ok)
+
+	a := seriesKey{mtype: "c", hash: "a"}
+	b := seriesKey{mtype: "g", hash: "a"}
+	c := seriesKey{mtype: "c", hash: "c"}
+	l.touch(a)
+	l.touch(b)
+	l.touch(c)
+	l.touch(a)
+	require.Equal(t, 3, l.len())
+
+	key, ok := l.evict()
+	require.True(t, ok)
+	require.Equal(t, b, key)
+
+	l.prune(func(key seriesKey) bool { return key != a })
+	require.Equal(t, 1, l.len())
+	key, ok = l.evict()
+	require.True(t, ok)
+	require.Equal(t, c, key)
+	require.Zero(t, l.len())
+}
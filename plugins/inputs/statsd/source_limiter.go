@@ -0,0 +1,65 @@
+package statsd
+
+import (
+	"sync"
+	"time"
+)
+
+// sourceLimiterPruneInterval is the interval for removing the buckets of
+// sources not seen recently
+const sourceLimiterPruneInterval = time.Minute
+
+// sourceLimiter limits the number of lines accepted per second and source
+// using a token bucket per source address with a burst size of one second.
+type sourceLimiter struct {
+	sync.Mutex
+	buckets   map[string]tokenBucket
+	lastPrune time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow checks if a line of the given source may be accepted at the given
+// time with the given rate
+func (l *sourceLimiter) allow(source string, now time.Time, rate int) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]tokenBucket)
+		l.lastPrune = now
+	}
+	if now.Sub(l.lastPrune) >= sourceLimiterPruneInterval {
+		l.prune(now)
+	}
+
+	bucket, found := l.buckets[source]
+	if !found {
+		bucket = tokenBucket{tokens: float64(rate), last: now}
+	}
+	if elapsed := now.Sub(bucket.last).Seconds(); elapsed > 0 {
+		bucket.tokens = min(float64(rate), bucket.tokens+elapsed*float64(rate))
+		bucket.last = now
+	}
+
+	ok := bucket.tokens >= 1
+	if ok {
+		bucket.tokens--
+	}
+	l.buckets[source] = bucket
+	return ok
+}
+
+// prune removes the buckets idle for at least a second as those are refilled
+// completely and equivalent to a new bucket
+func (l *sourceLimiter) prune(now time.Time) {
+	for source, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= time.Second {
+			delete(l.buckets, source)
+		}
+	}
+	l.lastPrune = now
+}
@@ -0,0 +1,38 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceLimiter(t *testing.T) {
+	var l sourceLimiter
+	now := time.Now()
+
+	// Each source may send a burst of one second worth of lines
+	for range 3 {
+		require.True(t, l.allow("10.0.0.1", now, 3))
+	}
+	require.False(t, l.allow("10.0.0.1", now, 3))
+	require.True(t, l.allow("10.0.0.2", now, 3))
+
+	// The bucket refills with the configured rate
+	require.True(t, l.allow("10.0.0.1", now.Add(400*time.Millisecond), 3))
+	require.False(t, l.allow("10.0.0.1", now.Add(400*time.Millisecond), 3))
+}
+
+func TestSourceLimiterPrune(t *testing.T) {
+	var l sourceLimiter
+	now := time.Now()
+
+	require.True(t, l.allow("10.0.0.1", now, 1))
+	require.True(t, l.allow("10.0.0.2", now.Add(sourceLimiterPruneInterval-time.Millisecond), 1))
+	require.Len(t, l.buckets, 2)
+
+	// Idle sources are removed
+	require.True(t, l.allow("10.0.0.3", now.Add(sourceLimiterPruneInterval), 1))
+	require.Len(t, l.buckets, 2)
+	require.NotContains(t, l.buckets, "10.0.0.1")
+}
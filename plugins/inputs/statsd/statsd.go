@@ -4,20 +4,35 @@ package statsd
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	_ "embed"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math"
 	"net"
+	"net/netip"
+	"os"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/cespare/xxhash/v2"
+
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/filter"
 	"github.com/influxdata/telegraf/internal"
+	common_tls "github.com/influxdata/telegraf/plugins/common/tls"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/influxdata/telegraf/plugins/parsers/graphite"
 	"github.com/influxdata/telegraf/selfstat"
@@ -28,29 +43,83 @@ var sampleConfig string
 
 var errParsing = errors.New("error parsing statsd line")
 
+var (
+	sanitizeWhitespace   = regexp.MustCompile(`\s+`)
+	sanitizeAllowedChars = regexp.MustCompile(`[^a-zA-Z_\-0-9\.;=]`)
+
+	// metricTypeSegment matches the type of a statsd line including an
+	// optional sample rate, marking the end of a metric in concatenated lines
+	metricTypeSegment = regexp.MustCompile(`\|(?:ms|[cdghs])(?:\|@[0-9.]+)?`)
+
+	// tagUnescaper removes the line-protocol escaping of commas, equal signs
+	// and spaces in bucket names and tags
+	tagUnescaper = strings.NewReplacer(`\,`, ",", `\=`, "=", `\ `, " ")
+)
+
 const (
 	// udpMaxPacketSize is the UDP packet limit, see
 	// https://en.wikipedia.org/wiki/User_Datagram_Protocol#Packet_structure
 	udpMaxPacketSize int = 64 * 1024
+	// udpMaxPayloadSize is the maximum payload of an IPv4 UDP datagram
+	udpMaxPayloadSize int = 65507
 
 	defaultFieldName           = "value"
 	defaultProtocol            = "udp"
+	defaultServiceAddress      = ":8125"
 	defaultSeparator           = "_"
 	defaultAllowPendingMessage = 10000
+	defaultDrainTimeout        = 5 * time.Second
+	defaultHotSeriesCount      = 10
+
+	// defaultPercentileFieldFormat names the percentile fields e.g.
+	// "90_percentile"
+	defaultPercentileFieldFormat = "%v_percentile"
+
+	// defaultMaxDecompressionSize limits the size of a decompressed datagram
+	defaultMaxDecompressionSize = 10 * 1024 * 1024
+
+	// maxFloatPrecision is the maximum number of decimal places to round to,
+	// float64 values do not carry more significant digits and the scaling
+	// overflows for large precisions
+	maxFloatPrecision = 15
+
+	// staleStateIntervals is the number of gather intervals after which the
+	// state kept per counter is removed if the counter was not emitted in
+	// between
+	staleStateIntervals = 10
+
+	// maxActiveClients bounds the number of distinct clients tracked per
+	// interval, the reported count saturates at this value.
+	maxActiveClients = 100000
+
+	// maxTrackedNames bounds the number of converted names tracked for
+	// detecting name collisions and the number of names tracked for
+	// detecting type conflicts
+	maxTrackedNames = 100000
 )
 
 type Statsd struct {
-	// Protocol used on listener - udp or tcp
+	// Protocol used on listener - udp, tcp, unixgram or unix
 	Protocol string `toml:"protocol"`
 
-	// Address & Port to serve from
+	// Address & Port to serve from, or the socket path for unix protocols
 	ServiceAddress string `toml:"service_address"`
 
+	// UnixSocketMode is the octal file mode applied to the created socket if
+	// listening on a Unix domain socket.
+	UnixSocketMode string `toml:"unix_socket_mode"`
+
 	// Number of messages allowed to queue up in between calls to Gather. If this
 	// fills up, packets will get dropped until the next Gather interval is ran.
 	AllowedPendingMessages int `toml:"allowed_pending_messages"`
 	NumberWorkerThreads    int `toml:"number_workers_threads"`
 
+	// The queue is considered congested once the number of pending messages
+	// reaches the high watermark and recovered when falling back to the low
+	// watermark again.
+	PendingMessagesHighWatermark int `toml:"pending_messages_high_watermark"`
+	PendingMessagesLowWatermark  int `toml:"pending_messages_low_watermark"`
+
 	// Percentiles specifies the percentiles that will be calculated for timing
 	// and histogram stats.
 	Percentiles     []number `toml:"percentiles"`
@@ -59,16 +128,143 @@ type Statsd struct {
 	DeleteCounters  bool     `toml:"delete_counters"`
 	DeleteSets      bool     `toml:"delete_sets"`
 	DeleteTimings   bool     `toml:"delete_timings"`
-	ConvertNames    bool     `toml:"convert_names"`
-	FloatCounters   bool     `toml:"float_counters"`
-	FloatTimings    bool     `toml:"float_timings"`
-	FloatSets       bool     `toml:"float_sets"`
+
+	// PercentileOverrides replaces the percentiles for the timings with
+	// names matching the given patterns, the first matching entry applies.
+	PercentileOverrides []percentileOverride `toml:"percentile_overrides"`
+
+	// PercentileFieldFormat is the format of the percentile field names with
+	// a single verb for the percentile, e.g. "p%v".
+	PercentileFieldFormat string `toml:"percentile_field_format"`
+
+	// CumulativeTimings keeps the aggregates of the timings across intervals
+	// while the percentiles and the median are computed per interval.
+	CumulativeTimings bool `toml:"cumulative_timings"`
+
+	// PercentileMethod selects the percentile computation, either "exact"
+	// using the stored values or "tdigest" estimating them with a sketch.
+	PercentileMethod string `toml:"percentile_method"`
+
+	// AllowNegativeTimings accepts negative values for timings & histograms,
+	// which are rejected by default.
+	AllowNegativeTimings bool `toml:"allow_negative_timings"`
+
+	// CommentPrefix marks lines starting with it as comments to be skipped.
+	CommentPrefix string `toml:"comment_prefix"`
+
+	// StrictSignSemantics rejects signed zero gauge values like "+0" or "-0"
+	// which would otherwise be additive no-ops, unlike the absolute "0".
+	StrictSignSemantics bool `toml:"strict_sign_semantics"`
+
+	// ResetAdditiveGauges scopes additive gauge updates to the interval, i.e.
+	// gauges fall back to the last absolute value after each gather.
+	ResetAdditiveGauges bool `toml:"reset_additive_gauges"`
+
+	// GaugeSampleRate defines the handling of sample rates of additive
+	// gauges, either "ignore" to apply the delta as is, "scale" to divide the
+	// delta by the rate like counters or "reject" to drop the line.
+	GaugeSampleRate string `toml:"gauge_sample_rate"`
+
+	// GaugeExtraStats adds the minimum, maximum and last value of each gauge
+	// field within the interval.
+	GaugeExtraStats bool `toml:"gauge_extra_stats"`
+
+	ConvertNames  bool `toml:"convert_names"`
+	FloatCounters bool `toml:"float_counters"`
+	FloatTimings  bool `toml:"float_timings"`
+	FloatSets     bool `toml:"float_sets"`
 
 	EnableAggregationTemporality bool `toml:"enable_aggregation_temporality"`
 
+	// AggregationTemporalityTypes lists the metric types tagged with their
+	// temporality if EnableAggregationTemporality is set. Defaults to counters
+	// only as gauges have no temporality.
+	AggregationTemporalityTypes []string `toml:"aggregation_temporality_types"`
+
+	// CounterAcceleration adds a "<field>_accel" field to counters holding the
+	// change of the per-second rate compared to the previous interval.
+	CounterAcceleration bool `toml:"counter_acceleration"`
+
+	// CounterRate adds a "<field>_per_second" field to counters holding the
+	// per-second rate within the interval.
+	CounterRate bool `toml:"counter_rate"`
+
+	// MirrorTemporality emits each counter twice, once as delta and once as
+	// cumulative value, distinguished by the temporality tag.
+	MirrorTemporality bool `toml:"mirror_temporality"`
+
+	// DetectNameCollisions reports distinct names converted to the same name
+	// if ConvertNames is enabled.
+	DetectNameCollisions bool `toml:"detect_name_collisions"`
+
+	// TrimSeparators removes leading and trailing dots from the bucket names
+	// before applying the templates, e.g. for buckets like ".api.calls.".
+	TrimSeparators bool `toml:"trim_separators"`
+
+	// NameMap replaces metric names matching a key exactly with the value
+	// after applying the templates and name conversion.
+	NameMap map[string]string `toml:"name_map"`
+
+	// MetricNamePrefix and MetricNameSuffix are joined to the metric names
+	// with the MetricSeparator after the name mapping.
+	MetricNamePrefix string `toml:"metric_name_prefix"`
+	MetricNameSuffix string `toml:"metric_name_suffix"`
+
+	// MetricNamePass and MetricNameDrop are glob patterns of the final
+	// measurement names to accept or to discard when parsing the lines.
+	MetricNamePass []string `toml:"metric_name_pass"`
+	MetricNameDrop []string `toml:"metric_name_drop"`
+
+	// SeriesKeyHash selects the key identifying a series, either "string" for
+	// the concatenated name and tags or "xxhash" for a compact hash of them.
+	SeriesKeyHash string `toml:"series_key_hash"`
+
+	// TypeConflictPolicy controls the handling of metrics using a name already
+	// seen with a different type, either "allow", "first_type_wins" or "error".
+	TypeConflictPolicy string `toml:"type_conflict_policy"`
+
+	// FloatPrecision rounds the emitted float fields to the given number of
+	// decimal places, unset keeps the full precision.
+	FloatPrecision *int `toml:"float_precision"`
+
+	// TypeEmissionMode selects the accumulator methods used for emitting the
+	// metrics, either "typed" for counters and gauges or "untyped" for all.
+	TypeEmissionMode string `toml:"type_emission_mode"`
+
+	// SingleFieldNaming controls the name of the default field if it is the
+	// only field of a metric, either "value" or the metric "name".
+	SingleFieldNaming string `toml:"single_field_naming"`
+
+	// EmitSeriesID adds a "series_id" tag holding the hex encoded xxhash
+	// digest of the name and sorted tags of the series.
+	EmitSeriesID bool `toml:"emit_series_id"`
+
+	// DefaultFieldName is the name of the field of metrics without a field
+	// given by the templates, defaults to "value".
+	DefaultFieldName string `toml:"default_field_name"`
+
 	// MetricSeparator is the separator between parts of the metric name.
 	MetricSeparator string `toml:"metric_separator"`
 
+	// TemplateSeparator is the separator used by the templates to join the
+	// matched parts of a bucket. Defaults to MetricSeparator.
+	TemplateSeparator string `toml:"template_separator"`
+
+	// FirstSegmentAsTag is the tag key to store the first dot-separated
+	// segment of the bucket in, removing it from the name.
+	FirstSegmentAsTag string `toml:"first_segment_as_tag"`
+
+	// TagPatterns are regular expressions applied in order to the bucket name
+	// before the templates, storing the named capture groups as tags.
+	// TagPatternsStrip removes the matched part from the name.
+	TagPatterns      []string `toml:"tag_patterns"`
+	TagPatternsStrip bool     `toml:"tag_patterns_strip"`
+
+	// MeasurementPerType prefixes the emitted measurement names with the
+	// metric type and the optional MeasurementTypePrefix.
+	MeasurementPerType    bool   `toml:"measurement_per_type"`
+	MeasurementTypePrefix string `toml:"measurement_type_prefix"`
+
 	// Parses extensions to statsd in the datadog statsd format
 	// currently supports metrics and datadog tags.
 	// http://docs.datadoghq.com/guides/dogstatsd/
@@ -79,22 +275,233 @@ type Statsd struct {
 	// https://docs.datadoghq.com/developers/metrics/types/?tab=distribution#definition
 	DataDogDistributions bool `toml:"datadog_distributions"`
 
+	// PercentileWindow calculates the timing percentiles over the samples of
+	// the given sliding window spanning multiple intervals.
+	PercentileWindow config.Duration `toml:"percentile_window"`
+
+	// SetWindow counts the unique members of a set seen within the given
+	// sliding window spanning multiple intervals.
+	SetWindow config.Duration `toml:"set_window"`
+
+	// AdaptivePercentiles only emits the percentiles of a timing series with
+	// enough samples in the interval. AdaptivePercentileMinSamples holds the
+	// minimum sample count for each entry of Percentiles and defaults to the
+	// number of samples required to resolve the percentile.
+	AdaptivePercentiles          bool  `toml:"adaptive_percentiles"`
+	AdaptivePercentileMinSamples []int `toml:"adaptive_percentile_min_samples"`
+
+	// PercentileWarmup suppresses the percentiles of a timing series for the
+	// given duration after the series was first seen.
+	PercentileWarmup config.Duration `toml:"percentile_warmup"`
+
+	// DistributionPercentiles aggregates distribution samples per series within
+	// an interval and emits the given percentiles instead of the raw values.
+	// Requires the DataDogDistributions flag to be enabled.
+	DistributionPercentiles []number `toml:"distribution_percentiles"`
+
+	// ExemplarTag is the key of the tag holding the trace identifier of timing
+	// samples. If set, the tag is removed from the series and the latest
+	// sample with its trace identifier is emitted as exemplar per interval.
+	ExemplarTag string `toml:"exemplar_tag"`
+
+	// HistogramBuckets holds the upper bounds of cumulative histogram buckets
+	// emitted for timings, histograms and distributions.
+	HistogramBuckets []float64 `toml:"histogram_buckets"`
+
+	// ApplySampleRateToDistributions replays each distribution sample by the
+	// inverse of its sample rate.
+	ApplySampleRateToDistributions bool `toml:"apply_samplerate_to_distributions"`
+
+	// ScaleSampledSets counts each set member of a sampled line by the inverse
+	// of its sample rate when computing the number of unique values.
+	ScaleSampledSets bool `toml:"scale_sampled_sets"`
+
+	// MaxSetCardinality limits the number of unique members kept per set
+	// field, zero means no limit.
+	MaxSetCardinality int `toml:"max_set_cardinality"`
+
 	// Either to keep or drop the container id as tag.
 	// Requires the DataDogExtension flag to be enabled.
 	// https://docs.datadoghq.com/developers/dogstatsd/datagram_shell/?tab=metrics#dogstatsd-protocol-v12
 	DataDogKeepContainerTag bool `toml:"datadog_keep_container_tag"`
 
-	ReadBufferSize      int              `toml:"read_buffer_size"`
-	SanitizeNamesMethod string           `toml:"sanitize_name_method"`
-	Templates           []string         `toml:"templates"` // bucket -> influx templates
-	MaxTCPConnections   int              `toml:"max_tcp_connections"`
-	TCPKeepAlive        bool             `toml:"tcp_keep_alive"`
-	TCPKeepAlivePeriod  *config.Duration `toml:"tcp_keep_alive_period"`
+	// DataDogTimestampWindow is the maximum difference of client timestamps
+	// of DogStatsD v1.3 to the current time, zero accepts any timestamp.
+	DataDogTimestampWindow config.Duration `toml:"datadog_timestamp_window"`
+
+	// GeoIPDB is the path to a MaxMind city or country database used to tag
+	// metrics based on the sender's address. GeoIPTags maps the database
+	// attributes to the tag keys to add.
+	GeoIPDB   string            `toml:"geoip_db"`
+	GeoIPTags map[string]string `toml:"geoip_tags"`
+
+	// SourceIPTag and SourcePortTag are the tag keys for the sender's address
+	// and port, empty disables the tag.
+	SourceIPTag   string `toml:"source_ip_tag"`
+	SourcePortTag string `toml:"source_port_tag"`
+
+	// SanitizeNamesMethodPerType overrides the name sanitization method for
+	// the given metric types, e.g. "gauge" or "timing".
+	SanitizeNamesMethodPerType map[string]string `toml:"sanitize_name_method_per_type"`
+
+	// SanitizeTags applies the "upstream" sanitization to the keys and
+	// values of the tags given in the bucket or as DogStatsD tags.
+	SanitizeTags bool `toml:"sanitize_tags"`
+
+	// SignalFxDimensions extracts the dimensions given in brackets within the
+	// bucket name, e.g. "name[k1=v1,k2=v2]", as tags.
+	SignalFxDimensions bool `toml:"signalfx_dimensions"`
+
+	// ContentEncoding is the compression of the received datagrams, e.g.
+	// "gzip", limited to MaxDecompressionSize bytes after decompression.
+	ContentEncoding      string      `toml:"content_encoding"`
+	MaxDecompressionSize config.Size `toml:"max_decompression_size"`
+
+	ReadBufferSize        int              `toml:"read_buffer_size"`
+	UDPMaxPacketSize      int              `toml:"udp_max_packet_size"`
+	SanitizeNamesMethod   string           `toml:"sanitize_name_method"`
+	SanitizeTagKeysMethod string           `toml:"sanitize_tag_keys_method"`
+	Templates             []string         `toml:"templates"` // bucket -> influx templates
+	MaxTCPConnections     int              `toml:"max_tcp_connections"`
+	TCPKeepAlive          bool             `toml:"tcp_keep_alive"`
+	TCPKeepAlivePeriod    *config.Duration `toml:"tcp_keep_alive_period"`
+
+	// TCPListenBacklog sets the size of the queue of pending TCP connections,
+	// zero uses the OS default.
+	TCPListenBacklog int `toml:"tcp_listen_backlog"`
+
+	// TCPFallbackSplit splits TCP lines containing multiple metrics
+	// concatenated without newlines, as sent by clients using UDP framing.
+	TCPFallbackSplit bool `toml:"tcp_fallback_split"`
+
+	// CoalesceCounters sums up the increments of the same counter within a
+	// message before aggregating them to reduce the lock contention.
+	CoalesceCounters bool `toml:"coalesce_counters"`
+
+	// UDPListeners is the number of UDP sockets bound to the service address
+	// using SO_REUSEPORT, letting the kernel distribute the packets across
+	// the sockets. Only supported on Linux.
+	UDPListeners int `toml:"udp_listeners"`
+
+	// TCPIdleTimeout closes TCP connections not sending any data within the
+	// given duration to free the connection slot, zero disables the timeout.
+	TCPIdleTimeout config.Duration `toml:"tcp_idle_timeout"`
+
+	// TCPMaxLineSize is the maximum length of a line received via TCP, zero
+	// uses the default token size of bufio.Scanner.
+	TCPMaxLineSize int `toml:"tcp_max_line_size"`
+
+	// TCPChunkSize reads TCP connections in chunks of the given number of
+	// bytes, queueing the complete lines of each chunk as one message so the
+	// lines of a single connection are parsed by multiple workers. Zero
+	// queues each line separately.
+	TCPChunkSize int `toml:"tcp_chunk_size"`
+
+	// TCPProxyProtocol expects a PROXY protocol v1 or v2 header at the start
+	// of each TCP connection and uses its source address for the client.
+	TCPProxyProtocol bool `toml:"tcp_proxy_protocol"`
+
+	// EmptyValueDefault maps a metric type to the value used for lines without
+	// a value, e.g. "metric:|c". Lines with empty values are rejected for
+	// types not listed here.
+	EmptyValueDefault map[string]string `toml:"empty_value_default"`
+
+	// DefaultMetricType is the metric type used for lines with an empty type,
+	// e.g. "metric:5|". Lines with empty types are rejected if not set.
+	DefaultMetricType string `toml:"default_metric_type"`
+
+	// DefaultTags are added to all metrics not having the tag already, e.g.
+	// from the bucket, the templates or the DataDog tags of the line.
+	DefaultTags map[string]string `toml:"default_tags"`
+
+	// MaxTagsetBytes limits the size of the tag set serialized as comma
+	// separated key=value pairs, zero means no limit. MaxTagsetAction is
+	// either "drop" to discard metrics exceeding the limit or "truncate" to
+	// remove the tags with the largest values until the limit is met.
+	MaxTagsetBytes  int    `toml:"max_tagset_bytes"`
+	MaxTagsetAction string `toml:"max_tagset_action"`
+
+	// DrainOnStop parses the messages still pending on stop instead of
+	// discarding them, for at most DrainTimeout, and emits the aggregated
+	// metrics.
+	DrainOnStop  bool            `toml:"drain_on_stop"`
+	DrainTimeout config.Duration `toml:"drain_timeout"`
+
+	// MaxLinesPerSecondPerSource limits the number of lines accepted per
+	// second from each source address, zero means no limit.
+	MaxLinesPerSecondPerSource int `toml:"max_lines_per_second_per_source"`
+
+	// ParseErrorLogRate limits the number of parse errors logged per second,
+	// zero means no limit.
+	ParseErrorLogRate int `toml:"parse_error_log_rate"`
+
+	// EmitSequence adds a field with the number of emissions of the series
+	// persisting across intervals until the series expires.
+	EmitSequence bool `toml:"emit_sequence"`
+
+	// EmitSampleCount adds a field with the number of lines received for the
+	// series in the current interval.
+	EmitSampleCount bool `toml:"emit_sample_count"`
+
+	// ReportInternalStats emits the total number of messages dropped due to a
+	// full queue and the number of pending messages with the gathered metrics.
+	ReportInternalStats bool `toml:"report_internal_stats"`
+
+	// DebugRingSize keeps the given number of the last raw lines received for
+	// debugging, available via RecentLines. DebugRingBuckets restricts the
+	// captured lines to buckets matching the given glob patterns.
+	DebugRingSize    int      `toml:"debug_ring_size"`
+	DebugRingBuckets []string `toml:"debug_ring_buckets"`
+
+	// TrackActiveClients emits the number of distinct clients which sent at
+	// least one valid metric during the interval.
+	TrackActiveClients bool `toml:"track_active_clients"`
+
+	// LogHotSeriesInterval logs the HotSeriesCount series with the most
+	// updates within the last interval at most once per given duration.
+	// EmitHotSeries emits these series as metrics on each gather.
+	LogHotSeriesInterval config.Duration `toml:"log_hot_series_interval"`
+	HotSeriesCount       int             `toml:"hot_series_count"`
+	EmitHotSeries        bool            `toml:"emit_hot_series"`
+
+	// LogDropSummaryInterval logs the number of lines dropped per reason
+	// since the last summary at most once per given duration.
+	LogDropSummaryInterval config.Duration `toml:"log_drop_summary_interval"`
+
+	// ReportFieldCounts emits the number of distinct fields cached per
+	// measurement to surface field explosions.
+	ReportFieldCounts bool `toml:"report_field_counts"`
+
+	// ReportSeriesCounts emits the number of series currently cached per
+	// metric type to surface tag explosions.
+	ReportSeriesCounts bool `toml:"report_series_counts"`
+
+	// PauseWindows are daily time windows in local time, e.g.
+	// "02:00-03:00", during which Gather does not emit any metrics as when
+	// calling Pause. PauseMode is either "accumulate" to keep the metrics
+	// received while paused for the next gather or "discard" to drop them.
+	PauseWindows []string `toml:"pause_windows"`
+	PauseMode    string   `toml:"pause_mode"`
 
 	// Max duration for each metric to stay cached without being updated.
 	MaxTTL config.Duration `toml:"max_ttl"`
 	Log    telegraf.Logger `toml:"-"`
 
+	// MaxCachedMetrics limits the number of cached gauge, counter, set and
+	// timing series, evicting the least recently updated ones. Zero means no
+	// limit.
+	MaxCachedMetrics int `toml:"max_cached_metrics"`
+
+	// InternPoolSize is the maximum number of distinct names, tag keys and
+	// values shared between the series to reduce the memory usage, zero disables
+	// interning. Strings unused for InternTTL are removed from the pool.
+	InternPoolSize int             `toml:"intern_pool_size"`
+	InternTTL      config.Duration `toml:"intern_ttl"`
+
+	// TLS settings of the TCP listener, client certificates are required if
+	// allowed CAs are configured
+	common_tls.ServerConfig
+
 	sync.Mutex
 	// Lock for preventing a data race during resource cleanup
 	cleanup sync.Mutex
@@ -104,29 +511,129 @@ type Statsd struct {
 	// accept the connection
 	accept chan bool
 	// drops tracks the number of dropped metrics.
-	drops int
+	drops atomic.Int64
+	// congested is set while the pending messages exceed the high watermark
+	congested atomic.Bool
+	// gaugeSampleRateWarned is set once the ignored sample rate of an
+	// additive gauge was reported
+	gaugeSampleRateWarned atomic.Bool
+	// paused is set between calls of Pause and Resume
+	paused atomic.Bool
+	// Parsed pause windows
+	pauseWindows []pauseWindow
 
 	// Channel for all incoming statsd packets
 	in   chan input
 	done chan struct{}
+	// ready is closed once Start completed, the listeners do not read any
+	// messages before to keep them in the socket buffers
+	ready chan struct{}
 
 	// Cache gauges, counters & sets so they can be aggregated as they arrive
 	// gauges and counters map measurement/tags hash -> field name -> metrics
 	// sets and timings map measurement/tags hash -> metrics
 	// distributions aggregate measurement/tags and are published directly
-	gauges        map[string]cachedgauge
-	counters      map[string]cachedcounter
-	sets          map[string]cachedset
-	timings       map[string]cachedtimings
-	distributions []cacheddistributions
+	// unless distribution percentiles are configured, in which case they are
+	// aggregated per measurement/tags hash like timings
+	gauges            map[string]cachedgauge
+	counters          map[string]cachedcounter
+	sets              map[string]cachedset
+	timings           map[string]cachedtimings
+	distributions     []cacheddistributions
+	distributionStats map[string]cachedtimings
+
+	// Original names per converted name and the original names already
+	// reported as colliding
+	convertedNames map[string]string
+	nameCollisions map[string]bool
+
+	// Last cumulative counter values per measurement/tags hash used to mirror
+	// counters as delta and cumulative values
+	mirroredCounters map[string]mirroredCounter
+
+	// Last values and per-second rates per measurement/tags hash used to
+	// compute the counter acceleration
+	counterRates map[string]counterRate
+
+	// First-seen times per timing measurement/tags hash used to suppress the
+	// percentiles during the warm-up
+	percentileWarmups map[string]percentileWarmup
+
+	// Metric type first seen per metric name
+	metricTypes map[string]string
+
+	// Emission sequence numbers per measurement/tags hash
+	sequences map[string]sequence
+
+	// Number of gathers used to detect stale state kept per series
+	gathers uint64
+
+	// Update counts per series in the current interval and the time the hot
+	// series were logged last
+	hotSeries       hotSeries
+	hotSeriesLogged time.Time
+
+	// Number of dropped lines per reason and of evicted series at the last
+	// drop summary and the time the summary was logged
+	dropsLogged     map[string]int64
+	evictionsLogged int64
+	dropSummaryTime time.Time
+
+	// Distinct addresses of the clients seen in the current interval
+	activeClients map[string]struct{}
+
+	// Resolver for the GeoIP tags of the message source
+	geoip *geoipResolver
+
+	// Rate limiter for parse error messages
+	parseErrors logLimiter
+
+	// Rate limiter for the lines received per source
+	sourceLimits sourceLimiter
+
+	// Pool of the tag keys and values shared between the series, nil if
+	// interning is disabled
+	interns *internPool
+
+	// Update order of the cached series if their number is limited
+	series seriesLRU
+
+	// Compiled tag patterns
+	tagPatterns []*regexp.Regexp
+
+	// Filter for the metric names to accept, nil if all are accepted
+	nameFilter filter.Filter
+
+	// Last raw lines received for debugging and the filter for their buckets
+	debugRing    *lineRing
+	debugBuckets filter.Filter
+
+	// Time accounting of the parser workers and the total busy time at the
+	// last gather used to compute the utilization
+	workers        []workerStats
+	lastWorkerBusy int64
+
+	// All registered internal statistics in registration order
+	registered []selfstat.Stat
 
 	// Protocol listeners
 	UDPlistener *net.UDPConn
 	TCPlistener *net.TCPListener
 
+	// Additional UDP sockets sharing the port of UDPlistener
+	udpListeners []*net.UDPConn
+
+	// Unix domain socket listeners and the path of the socket to remove
+	unixgramListener *net.UnixConn
+	unixListener     *net.UnixListener
+	socketPath       string
+
+	// TLS configuration of the TCP connections, nil for plain TCP
+	tlsConfig *tls.Config
+
 	// track current connections so we can close them in Stop()
-	conns          map[string]*net.TCPConn
-	graphiteParser *graphite.Parser
+	conns          map[string]net.Conn
+	graphiteParser atomic.Pointer[templateParser]
 	acc            telegraf.Accumulator
 	bufPool        sync.Pool // pool of byte slices to handle parsing
 
@@ -148,11 +655,66 @@ type internalStats struct {
 	ParseTimeNS        selfstat.Stat
 	PendingMessages    selfstat.Stat
 	MaxPendingMessages selfstat.Stat
+	ParserUtilization  selfstat.Stat
+	QueueRecoveries    selfstat.Stat
+	ClockRegressions   selfstat.Stat
+
+	NegativeTimingsDropped selfstat.Stat
+	NameCollisions         selfstat.Stat
+	RateLimitedLines       selfstat.Stat
+	EmptyMetricTypes       selfstat.Stat
+	CacheEvictions         selfstat.Stat
+	FilteredLines          selfstat.Stat
+	OversizedTagsets       selfstat.Stat
+	IdleConnectionsClosed  selfstat.Stat
+	SetCardinalityCapped   selfstat.Stat
+
+	// Number of series currently cached per metric type
+	SeriesGauges   selfstat.Stat
+	SeriesCounters selfstat.Stat
+	SeriesSets     selfstat.Stat
+	SeriesTimings  selfstat.Stat
+
+	// Number of lines dropped per reason, see dropReasons
+	Dropped map[string]selfstat.Stat
+}
+
+// dropReasons are the reasons for dropping received data reported as "reason"
+// tag of the "dropped" internal statistic
+var dropReasons = []string{
+	"queue_full",
+	"paused",
+	"rate_limited",
+	"empty_type",
+	"negative_timing",
+	"gauge_sample_rate",
+	"name_filter",
+	"oversized_tagset",
+	"type_conflict",
+	"timestamp_window",
+	"set_cardinality",
+	"decoding_failed",
+}
+
+// workerStats tracks the time a parser worker spent on processing messages
+// and on waiting for new messages
+type workerStats struct {
+	busy selfstat.Stat
+	idle selfstat.Stat
 }
 
 // number will get parsed as an int or float depending on what is passed
 type number float64
 
+// percentileOverride holds the percentiles calculated for the timings with
+// names matching one of the patterns instead of the global percentiles
+type percentileOverride struct {
+	Names       []string `toml:"names"`
+	Percentiles []number `toml:"percentiles"`
+
+	filter filter.Filter
+}
+
 // UnmarshalTOML is a custom TOML unmarshalling function for the number type.
 func (n *number) UnmarshalTOML(b []byte) error {
 	value, err := strconv.ParseFloat(string(b), 64)
@@ -168,6 +730,7 @@ type input struct {
 	*bytes.Buffer
 	time.Time
 	Addr string
+	Port int
 }
 
 // One statsd metric, form is <bucket>:<value>|<mtype>|@<samplerate>
@@ -183,251 +746,824 @@ type metric struct {
 	additive   bool
 	samplerate float64
 	tags       map[string]string
+	timestamp  time.Time
+	exemplar   string
+	// Number of lines summed up into the metric, zero for a single line
+	samples int64
 }
 
 type cachedset struct {
 	name      string
+	samples   int64
 	fields    map[string]map[string]bool
 	tags      map[string]string
 	expiresAt time.Time
+	timestamp time.Time
+
+	// last time each member was seen if a set window is configured
+	seen map[string]map[string]time.Time
+
+	// inverse sample rate of the members last received with a sample rate if
+	// sampled sets are scaled
+	scales map[string]map[string]float64
 }
 
 type cachedgauge struct {
 	name      string
+	samples   int64
 	fields    map[string]interface{}
 	tags      map[string]string
 	expiresAt time.Time
+	timestamp time.Time
+
+	// last absolute values used to reset the fields if additive updates are
+	// scoped to the interval
+	base map[string]float64
+
+	// range of the field values within the interval
+	extrema map[string]gaugeExtrema
+}
+
+type gaugeExtrema struct {
+	min float64
+	max float64
 }
 
 type cachedcounter struct {
 	name      string
+	samples   int64
 	fields    map[string]interface{}
 	tags      map[string]string
 	expiresAt time.Time
+	timestamp time.Time
 }
 
 type cachedtimings struct {
 	name      string
+	samples   int64
 	fields    map[string]runningStats
 	tags      map[string]string
 	expiresAt time.Time
+	timestamp time.Time
+	exemplars map[string]exemplar
 }
 
-type cacheddistributions struct {
-	name  string
+// exemplar is a sample of a timing field together with the trace identifier
+// sent along with the sample
+type exemplar struct {
 	value float64
-	tags  map[string]string
+	id    string
 }
 
-func (*Statsd) SampleConfig() string {
-	return sampleConfig
+type cacheddistributions struct {
+	name      string
+	hash      string
+	value     float64
+	tags      map[string]string
+	timestamp time.Time
 }
 
-func (s *Statsd) Start(ac telegraf.Accumulator) error {
-	s.acc = ac
+type mirroredCounter struct {
+	totals    map[string]int64
+	expiresAt time.Time
+	gather    uint64
+}
 
-	// Make data structures
-	s.lastGatherTime = time.Now()
-	s.gauges = make(map[string]cachedgauge)
-	s.counters = make(map[string]cachedcounter)
-	s.sets = make(map[string]cachedset)
-	s.timings = make(map[string]cachedtimings)
-	s.distributions = make([]cacheddistributions, 0)
+type counterRate struct {
+	values    map[string]int64
+	rates     map[string]float64
+	expiresAt time.Time
+	gather    uint64
+}
 
-	s.Lock()
-	defer s.Unlock()
+type percentileWarmup struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	expiresAt time.Time
+}
+
+type sequence struct {
+	value   int64
+	emitted time.Time
+}
 
-	//
-	tags := map[string]string{
-		"address": s.ServiceAddress,
+// registerStats registers the internal statistics with the given tags
+func (s *Statsd) registerStats(tags map[string]string) {
+	s.registered = nil
+	register := func(field string) selfstat.Stat {
+		stat := selfstat.Register("statsd", field, tags)
+		s.registered = append(s.registered, stat)
+		return stat
 	}
-	s.Stats.MaxConnections = selfstat.Register("statsd", "tcp_max_connections", tags)
+
+	s.Stats.MaxConnections = register("tcp_max_connections")
 	s.Stats.MaxConnections.Set(int64(s.MaxTCPConnections))
-	s.Stats.CurrentConnections = selfstat.Register("statsd", "tcp_current_connections", tags)
-	s.Stats.TotalConnections = selfstat.Register("statsd", "tcp_total_connections", tags)
-	s.Stats.TCPPacketsRecv = selfstat.Register("statsd", "tcp_packets_received", tags)
-	s.Stats.TCPBytesRecv = selfstat.Register("statsd", "tcp_bytes_received", tags)
-	s.Stats.UDPPacketsRecv = selfstat.Register("statsd", "udp_packets_received", tags)
-	s.Stats.UDPPacketsDrop = selfstat.Register("statsd", "udp_packets_dropped", tags)
-	s.Stats.UDPBytesRecv = selfstat.Register("statsd", "udp_bytes_received", tags)
-	s.Stats.ParseTimeNS = selfstat.Register("statsd", "parse_time_ns", tags)
-	s.Stats.PendingMessages = selfstat.Register("statsd", "pending_messages", tags)
-	s.Stats.MaxPendingMessages = selfstat.Register("statsd", "max_pending_messages", tags)
+	s.Stats.CurrentConnections = register("tcp_current_connections")
+	s.Stats.TotalConnections = register("tcp_total_connections")
+	s.Stats.TCPPacketsRecv = register("tcp_packets_received")
+	s.Stats.TCPBytesRecv = register("tcp_bytes_received")
+	s.Stats.UDPPacketsRecv = register("udp_packets_received")
+	s.Stats.UDPPacketsDrop = register("udp_packets_dropped")
+	s.Stats.UDPBytesRecv = register("udp_bytes_received")
+	s.Stats.ParseTimeNS = register("parse_time_ns")
+	s.Stats.PendingMessages = register("pending_messages")
+	s.Stats.MaxPendingMessages = register("max_pending_messages")
 	s.Stats.MaxPendingMessages.Set(int64(s.AllowedPendingMessages))
+	s.Stats.ParserUtilization = register("parser_utilization_percent")
+	s.Stats.QueueRecoveries = register("pending_messages_recovered")
+	s.Stats.ClockRegressions = register("clock_regressions")
+	s.Stats.NegativeTimingsDropped = register("negative_timings_dropped")
+	s.Stats.NameCollisions = register("name_collisions")
+	s.Stats.RateLimitedLines = register("rate_limited_lines")
+	s.Stats.EmptyMetricTypes = register("parse_errors_empty_type")
+	s.Stats.CacheEvictions = register("cache_evictions")
+	s.Stats.FilteredLines = register("filtered_lines")
+	s.Stats.OversizedTagsets = register("oversized_tagsets")
+	s.Stats.IdleConnectionsClosed = register("tcp_idle_connections_closed")
+	s.Stats.SetCardinalityCapped = register("set_cardinality_capped")
+	s.Stats.SeriesGauges = register("series_gauges")
+	s.Stats.SeriesCounters = register("series_counters")
+	s.Stats.SeriesSets = register("series_sets")
+	s.Stats.SeriesTimings = register("series_timings")
 
-	s.in = make(chan input, s.AllowedPendingMessages)
-	s.done = make(chan struct{})
-	s.accept = make(chan bool, s.MaxTCPConnections)
-	s.conns = make(map[string]*net.TCPConn)
-	s.bufPool = sync.Pool{
-		New: func() interface{} {
-			return new(bytes.Buffer)
-		},
-	}
-	for i := 0; i < s.MaxTCPConnections; i++ {
-		s.accept <- true
-	}
-
-	if s.MetricSeparator == "" {
-		s.MetricSeparator = defaultSeparator
+	// The statistics share the field name, so they are not part of the
+	// registered statistics keyed by field name
+	s.Stats.Dropped = make(map[string]selfstat.Stat, len(dropReasons))
+	for _, reason := range dropReasons {
+		reasonTags := maps.Clone(tags)
+		reasonTags["reason"] = reason
+		s.Stats.Dropped[reason] = selfstat.Register("statsd", "dropped", reasonTags)
 	}
+}
 
-	if s.isUDP() {
-		address, err := net.ResolveUDPAddr(s.Protocol, s.ServiceAddress)
-		if err != nil {
-			return err
-		}
-
-		conn, err := net.ListenUDP(s.Protocol, address)
-		if err != nil {
-			return err
-		}
+// countDrop increments the number of lines dropped for the given reason
+func (s *Statsd) countDrop(reason string, n int64) {
+	s.Stats.Dropped[reason].Incr(n)
+}
 
-		s.Log.Infof("UDP listening on %q", conn.LocalAddr().String())
-		s.UDPlistener = conn
+// StatsSnapshot is a copy of the internal statistics and derived counts
+type StatsSnapshot struct {
+	// Stats holds the internal statistics keyed by their field name
+	Stats map[string]int64
+	// Dropped holds the number of dropped lines per reason
+	Dropped map[string]int64
+	// MessagesDropped is the total number of messages dropped due to a full
+	// queue
+	MessagesDropped int64
 
-		s.wg.Add(1)
-		go func() {
-			defer s.wg.Done()
-			if err := s.udpListen(conn); err != nil {
-				ac.AddError(err)
-			}
-		}()
-	} else {
-		address, err := net.ResolveTCPAddr("tcp", s.ServiceAddress)
-		if err != nil {
-			return err
-		}
-		listener, err := net.ListenTCP("tcp", address)
-		if err != nil {
-			return err
-		}
+	// Number of series currently cached per metric type
+	Gauges        int
+	Counters      int
+	Sets          int
+	Timings       int
+	Distributions int
+}
 
-		s.Log.Infof("TCP listening on %q", listener.Addr().String())
-		s.TCPlistener = listener
+// Snapshot returns a copy of the current internal statistics together with
+// derived counts, i.e. the total number of messages dropped due to a full
+// queue and the number of series currently cached per metric type. It is safe
+// to call concurrently with the running plugin.
+func (s *Statsd) Snapshot() StatsSnapshot {
+	s.Lock()
+	defer s.Unlock()
 
-		s.wg.Add(1)
-		go func() {
-			defer s.wg.Done()
-			if err := s.tcpListen(listener); err != nil {
-				ac.AddError(err)
-			}
-		}()
+	snapshot := StatsSnapshot{
+		Stats:           make(map[string]int64, len(s.registered)),
+		Dropped:         make(map[string]int64, len(s.Stats.Dropped)),
+		MessagesDropped: s.drops.Load(),
+		Gauges:          len(s.gauges),
+		Counters:        len(s.counters),
+		Sets:            len(s.sets),
+		Timings:         len(s.timings),
+		Distributions:   len(s.distributions) + len(s.distributionStats),
+	}
+	for _, stat := range s.registered {
+		snapshot.Stats[stat.FieldName()] = stat.Get()
+	}
+	for reason, stat := range s.Stats.Dropped {
+		snapshot.Dropped[reason] = stat.Get()
 	}
+	return snapshot
+}
 
-	for i := 1; i <= s.NumberWorkerThreads; i++ {
-		// Start the line parser
-		s.wg.Add(1)
-		go func() {
-			defer s.wg.Done()
-			if err := s.parser(); err != nil {
-				ac.AddError(err)
-			}
-		}()
+// RecentLines returns the last raw lines received from the oldest to the
+// newest if a debug ring is configured. It is safe to call concurrently with
+// the running plugin.
+func (s *Statsd) RecentLines() []string {
+	if s.debugRing == nil {
+		return nil
 	}
-	s.Log.Infof("Started the statsd service on %q", s.ServiceAddress)
-	return nil
+	return s.debugRing.snapshot()
 }
 
-func (s *Statsd) Gather(acc telegraf.Accumulator) error {
-	s.Lock()
-	defer s.Unlock()
-	now := time.Now()
+func (*Statsd) SampleConfig() string {
+	return sampleConfig
+}
 
-	for _, m := range s.distributions {
-		fields := map[string]interface{}{
-			defaultFieldName: m.value,
-		}
-		if s.EnableAggregationTemporality {
-			fields["start_time"] = s.lastGatherTime.Format(time.RFC3339)
-		}
-		acc.AddFields(m.name, fields, m.tags, now)
+func (s *Statsd) Start(ac telegraf.Accumulator) error {
+	if s.FloatPrecision != nil && (*s.FloatPrecision < 0 || *s.FloatPrecision > maxFloatPrecision) {
+		return fmt.Errorf("invalid float_precision %d, must be between 0 and %d", *s.FloatPrecision, maxFloatPrecision)
 	}
-	s.distributions = make([]cacheddistributions, 0)
-
-	for _, m := range s.timings {
-		// Defining a template to parse field names for timers allows us to split
-		// out multiple fields per timer. In this case we prefix each stat with the
-		// field name and store these all in a single measurement.
-		fields := make(map[string]interface{})
-		for fieldName, stats := range m.fields {
-			var prefix string
-			if fieldName != defaultFieldName {
-				prefix = fieldName + "_"
-			}
-			fields[prefix+"mean"] = stats.mean()
-			fields[prefix+"median"] = stats.median()
-			fields[prefix+"stddev"] = stats.stddev()
-			fields[prefix+"sum"] = stats.sum()
-			fields[prefix+"upper"] = stats.upper()
-			fields[prefix+"lower"] = stats.lower()
-			if s.FloatTimings {
-				fields[prefix+"count"] = float64(stats.count())
-			} else {
-				fields[prefix+"count"] = stats.count()
+	if s.TCPListenBacklog < 0 {
+		return fmt.Errorf("invalid tcp_listen_backlog %d", s.TCPListenBacklog)
+	}
+	if len(s.HistogramBuckets) > 0 {
+		// Sort and deduplicate the bounds, the "+Inf" bucket is always emitted
+		bounds := make([]float64, 0, len(s.HistogramBuckets))
+		for _, bound := range s.HistogramBuckets {
+			if math.IsNaN(bound) || math.IsInf(bound, -1) {
+				return fmt.Errorf("invalid histogram_buckets bound %v", bound)
 			}
-			for _, percentile := range s.Percentiles {
-				name := fmt.Sprintf("%s%v_percentile", prefix, percentile)
-				fields[name] = stats.percentile(float64(percentile))
+			if !math.IsInf(bound, 1) {
+				bounds = append(bounds, bound)
 			}
 		}
-		if s.EnableAggregationTemporality {
-			fields["start_time"] = s.lastGatherTime.Format(time.RFC3339)
-		}
-
-		acc.AddFields(m.name, fields, m.tags, now)
+		slices.Sort(bounds)
+		s.HistogramBuckets = slices.Compact(bounds)
 	}
-	if s.DeleteTimings {
-		s.timings = make(map[string]cachedtimings)
+	if s.SourcePortTag != "" && s.MaxTTL == 0 && s.MaxCachedMetrics == 0 {
+		// Clients usually use ephemeral ports, so each socket creates new
+		// series that are never released without expiration
+		s.Log.Warn("The source_port_tag option creates a series per client socket, " +
+			"set max_ttl or max_cached_metrics to bound the number of cached series")
 	}
-
-	for _, m := range s.gauges {
-		if s.EnableAggregationTemporality && m.fields != nil {
-			m.fields["start_time"] = s.lastGatherTime.Format(time.RFC3339)
-		}
-
-		acc.AddGauge(m.name, m.fields, m.tags, now)
+	if s.UDPListeners < 0 {
+		return fmt.Errorf("invalid udp_listeners %d", s.UDPListeners)
 	}
-	if s.DeleteGauges {
-		s.gauges = make(map[string]cachedgauge)
+	if s.TCPMaxLineSize < 0 {
+		return fmt.Errorf("invalid tcp_max_line_size %d", s.TCPMaxLineSize)
+	}
+	if s.TCPChunkSize < 0 {
+		return fmt.Errorf("invalid tcp_chunk_size %d", s.TCPChunkSize)
+	}
+	if s.InternPoolSize < 0 {
+		return fmt.Errorf("invalid intern_pool_size %d", s.InternPoolSize)
+	}
+	if s.InternPoolSize > 0 {
+		s.interns = newInternPool(s.InternPoolSize, time.Duration(s.InternTTL))
+	}
+	if s.HotSeriesCount < 0 {
+		return fmt.Errorf("invalid hot_series_count %d", s.HotSeriesCount)
+	}
+	if s.MaxTagsetBytes < 0 {
+		return fmt.Errorf("invalid max_tagset_bytes %d", s.MaxTagsetBytes)
+	}
+	switch s.MaxTagsetAction {
+	case "":
+		s.MaxTagsetAction = "drop"
+	case "drop", "truncate":
+	default:
+		return fmt.Errorf("invalid max_tagset_action %q", s.MaxTagsetAction)
+	}
+	s.tagPatterns = make([]*regexp.Regexp, 0, len(s.TagPatterns))
+	for _, pattern := range s.TagPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid tag_patterns: %w", err)
+		}
+		if !slices.ContainsFunc(re.SubexpNames(), func(n string) bool { return n != "" }) {
+			return fmt.Errorf("invalid tag_patterns %q: no named capture group", pattern)
+		}
+		s.tagPatterns = append(s.tagPatterns, re)
+	}
+	switch s.GaugeSampleRate {
+	case "":
+		s.GaugeSampleRate = "ignore"
+	case "ignore", "scale", "reject":
+	default:
+		return fmt.Errorf("invalid gauge_sample_rate %q", s.GaugeSampleRate)
+	}
+	switch s.PauseMode {
+	case "":
+		s.PauseMode = "accumulate"
+	case "accumulate", "discard":
+	default:
+		return fmt.Errorf("invalid pause_mode %q", s.PauseMode)
+	}
+	s.pauseWindows = make([]pauseWindow, 0, len(s.PauseWindows))
+	for _, window := range s.PauseWindows {
+		w, err := parsePauseWindow(window)
+		if err != nil {
+			return fmt.Errorf("invalid pause_windows: %w", err)
+		}
+		s.pauseWindows = append(s.pauseWindows, w)
+	}
+	if s.MaxCachedMetrics < 0 {
+		return fmt.Errorf("invalid max_cached_metrics %d", s.MaxCachedMetrics)
+	}
+	if len(s.MetricNamePass) > 0 || len(s.MetricNameDrop) > 0 {
+		f, err := filter.NewIncludeExcludeFilter(s.MetricNamePass, s.MetricNameDrop)
+		if err != nil {
+			return fmt.Errorf("invalid metric_name_pass or metric_name_drop: %w", err)
+		}
+		s.nameFilter = f
+	}
+	for i := range s.PercentileOverrides {
+		override := &s.PercentileOverrides[i]
+		if len(override.Names) == 0 {
+			return fmt.Errorf("percentile_overrides entry %d requires names", i+1)
+		}
+		f, err := filter.Compile(override.Names)
+		if err != nil {
+			return fmt.Errorf("invalid names of percentile_overrides entry %d: %w", i+1, err)
+		}
+		override.filter = f
+	}
+	if s.MaxSetCardinality < 0 {
+		return fmt.Errorf("invalid max_set_cardinality %d", s.MaxSetCardinality)
+	}
+	if s.DebugRingSize < 0 {
+		return fmt.Errorf("invalid debug_ring_size %d", s.DebugRingSize)
+	}
+	if s.DebugRingSize > 0 {
+		f, err := filter.Compile(s.DebugRingBuckets)
+		if err != nil {
+			return fmt.Errorf("invalid debug_ring_buckets: %w", err)
+		}
+		s.debugBuckets = f
+		s.debugRing = newLineRing(s.DebugRingSize)
+	}
+	if s.PendingMessagesHighWatermark == 0 {
+		s.PendingMessagesHighWatermark = s.AllowedPendingMessages
+	}
+	if s.PendingMessagesLowWatermark == 0 {
+		s.PendingMessagesLowWatermark = s.PendingMessagesHighWatermark / 2
+	}
+	if s.PendingMessagesLowWatermark < 0 || s.PendingMessagesLowWatermark >= max(s.PendingMessagesHighWatermark, 1) {
+		return fmt.Errorf("invalid pending_messages_low_watermark %d, must be below the high watermark %d",
+			s.PendingMessagesLowWatermark, s.PendingMessagesHighWatermark)
+	}
+	if s.UDPMaxPacketSize < 0 || s.UDPMaxPacketSize > udpMaxPayloadSize {
+		return fmt.Errorf("invalid udp_max_packet_size %d, must not exceed %d bytes", s.UDPMaxPacketSize, udpMaxPayloadSize)
+	}
+	if (s.isUDP() || s.isUnix()) && s.hasTLSConfig() {
+		return fmt.Errorf("invalid TLS configuration, TLS is only supported by the tcp protocol but protocol is %q", s.Protocol)
+	}
+	switch s.ContentEncoding {
+	case "":
+		s.ContentEncoding = "identity"
+	case "identity", "gzip", "zlib":
+	default:
+		return fmt.Errorf("invalid content_encoding %q", s.ContentEncoding)
+	}
+	if s.PercentileFieldFormat != "" {
+		if err := validatePercentileFieldFormat(s.PercentileFieldFormat); err != nil {
+			return fmt.Errorf("invalid percentile_field_format %q: %w", s.PercentileFieldFormat, err)
+		}
+	}
+	if s.MaxDecompressionSize == 0 {
+		s.MaxDecompressionSize = defaultMaxDecompressionSize
+	}
+	if len(s.AdaptivePercentileMinSamples) > 0 && len(s.AdaptivePercentileMinSamples) != len(s.Percentiles) {
+		return fmt.Errorf("adaptive_percentile_min_samples has %d entries but %d percentiles are configured",
+			len(s.AdaptivePercentileMinSamples), len(s.Percentiles))
 	}
 
-	for _, m := range s.counters {
-		if s.EnableAggregationTemporality && m.fields != nil {
-			m.fields["start_time"] = s.lastGatherTime.Format(time.RFC3339)
+	switch s.PercentileMethod {
+	case "", "exact":
+	case "tdigest":
+		if s.PercentileWindow > 0 {
+			return errors.New("percentile_method \"tdigest\" cannot be used with percentile_window")
+		}
+	default:
+		return fmt.Errorf("invalid percentile_method %q", s.PercentileMethod)
+	}
+
+	switch s.SeriesKeyHash {
+	case "", "string", "xxhash":
+	default:
+		return fmt.Errorf("invalid series_key_hash %q", s.SeriesKeyHash)
+	}
+	switch s.TypeConflictPolicy {
+	case "", "allow", "first_type_wins", "error":
+	default:
+		return fmt.Errorf("invalid type_conflict_policy %q", s.TypeConflictPolicy)
+	}
+	switch s.TypeEmissionMode {
+	case "", "typed", "untyped":
+	default:
+		return fmt.Errorf("invalid type_emission_mode %q", s.TypeEmissionMode)
+	}
+	switch s.SingleFieldNaming {
+	case "", "value", "name":
+	default:
+		return fmt.Errorf("invalid single_field_naming %q", s.SingleFieldNaming)
+	}
+	for _, mtype := range s.AggregationTemporalityTypes {
+		switch mtype {
+		case "counter", "set", "timing", "histogram", "distribution":
+		default:
+			return fmt.Errorf("invalid aggregation_temporality_types entry %q", mtype)
+		}
+	}
+
+	switch s.DefaultMetricType {
+	case "", "g", "c", "s", "ms", "h", "d":
+	default:
+		return fmt.Errorf("invalid default_metric_type %q", s.DefaultMetricType)
+	}
+
+	for mtype, method := range s.SanitizeNamesMethodPerType {
+		switch mtype {
+		case "counter", "gauge", "set", "timing", "histogram", "distribution":
+		default:
+			return fmt.Errorf("invalid sanitize_name_method_per_type type %q", mtype)
+		}
+		switch method {
+		case "", "upstream":
+		default:
+			return fmt.Errorf("invalid sanitize_name_method_per_type method %q", method)
+		}
+	}
+
+	if s.GeoIPDB != "" {
+		geoip, err := newGeoIPResolver(s.GeoIPDB, s.GeoIPTags)
+		if err != nil {
+			return err
+		}
+		s.geoip = geoip
+	}
+	// Stop is not called if starting fails, so close the database here
+	var started bool
+	defer func() {
+		if started || s.geoip == nil {
+			return
+		}
+		if err := s.geoip.close(); err != nil {
+			s.Log.Errorf("Closing GeoIP database failed: %v", err)
+		}
+		s.geoip = nil
+	}()
+
+	s.acc = ac
+
+	// Make data structures
+	s.lastGatherTime = time.Now()
+	s.gauges = make(map[string]cachedgauge)
+	s.counters = make(map[string]cachedcounter)
+	s.sets = make(map[string]cachedset)
+	s.timings = make(map[string]cachedtimings)
+	s.distributions = make([]cacheddistributions, 0)
+	s.distributionStats = make(map[string]cachedtimings)
+	s.activeClients = make(map[string]struct{})
+	s.sequences = make(map[string]sequence)
+	s.metricTypes = make(map[string]string)
+	s.mirroredCounters = make(map[string]mirroredCounter)
+	s.counterRates = make(map[string]counterRate)
+	s.percentileWarmups = make(map[string]percentileWarmup)
+	s.convertedNames = make(map[string]string)
+	s.nameCollisions = make(map[string]bool)
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.registerStats(map[string]string{"address": s.ServiceAddress})
+
+	s.workers = make([]workerStats, 0, s.NumberWorkerThreads)
+	s.lastWorkerBusy = 0
+	for i := 1; i <= s.NumberWorkerThreads; i++ {
+		workerTags := map[string]string{
+			"address": s.ServiceAddress,
+			"worker":  strconv.Itoa(i),
+		}
+		w := workerStats{
+			busy: selfstat.Register("statsd", "parser_busy_ns", workerTags),
+			idle: selfstat.Register("statsd", "parser_idle_ns", workerTags),
+		}
+		s.workers = append(s.workers, w)
+		s.lastWorkerBusy += w.busy.Get()
+	}
+
+	s.in = make(chan input, s.AllowedPendingMessages)
+	s.done = make(chan struct{})
+	s.ready = make(chan struct{})
+	s.accept = make(chan bool, s.MaxTCPConnections)
+	s.conns = make(map[string]net.Conn)
+	s.bufPool = sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+	for i := 0; i < s.MaxTCPConnections; i++ {
+		s.accept <- true
+	}
+
+	if s.MetricSeparator == "" {
+		s.MetricSeparator = defaultSeparator
+	}
+	// Create the template parser before starting the workers sharing it
+	s.templateParser(s.templateSeparator())
+
+	switch {
+	case s.isUnix():
+		if err := s.listenUnix(ac); err != nil {
+			return err
+		}
+	case s.isUDP():
+		conns, err := s.listenUDP()
+		if err != nil {
+			return err
+		}
+
+		s.Log.Infof("UDP listening on %q", conns[0].LocalAddr().String())
+		s.UDPlistener = conns[0]
+		s.udpListeners = conns[1:]
+
+		for _, conn := range conns {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				if !s.waitReady() {
+					return
+				}
+				if err := s.udpListen(conn); err != nil {
+					ac.AddError(err)
+				}
+			}()
+		}
+	default:
+		tlsConfig, err := s.ServerConfig.TLSConfig()
+		if err != nil {
+			return err
+		}
+		s.tlsConfig = tlsConfig
+
+		address, err := net.ResolveTCPAddr("tcp", s.ServiceAddress)
+		if err != nil {
+			return err
+		}
+		listener, err := net.ListenTCP("tcp", address)
+		if err != nil {
+			return err
+		}
+		if s.TCPListenBacklog > 0 {
+			if err := setListenBacklog(listener, s.TCPListenBacklog); err != nil {
+				listener.Close()
+				return fmt.Errorf("setting tcp_listen_backlog failed: %w", err)
+			}
+		}
+
+		s.Log.Infof("TCP listening on %q", listener.Addr().String())
+		s.TCPlistener = listener
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if !s.waitReady() {
+				return
+			}
+			if err := s.tcpListen(listener); err != nil {
+				ac.AddError(err)
+			}
+		}()
+	}
+
+	for _, w := range s.workers {
+		// Start the line parser
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := s.parser(w); err != nil {
+				ac.AddError(err)
+			}
+		}()
+	}
+	close(s.ready)
+	started = true
+	s.Log.Infof("Started the statsd service on %q", s.ServiceAddress)
+	return nil
+}
+
+// waitReady blocks until Start completed and returns false if the plugin is
+// stopped before
+func (s *Statsd) waitReady() bool {
+	select {
+	case <-s.ready:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *Statsd) Gather(acc telegraf.Accumulator) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	if s.isPaused(now) {
+		return nil
+	}
+	interval := s.gatherInterval(now)
+
+	// Count the fields before the caches are reset
+	if s.ReportFieldCounts {
+		s.emitFieldCounts(acc, now)
+	}
+	s.sampleSeriesCounts(acc, now)
+
+	for _, m := range s.distributions {
+		fields := map[string]interface{}{
+			s.defaultField(): m.value,
+		}
+		s.emit(acc, m.hash, m.name, fields, m.tags, telegraf.Untyped, 1, now, m.timestamp)
+	}
+	// Reuse the backing array of the distributions to reduce allocations
+	// under steady load, clearing the entries to release the tag maps
+	clear(s.distributions)
+	s.distributions = s.distributions[:0]
+
+	for hash, m := range s.distributionStats {
+		fields := make(map[string]interface{})
+		for fieldName, stats := range m.fields {
+			var prefix string
+			if fieldName != s.defaultField() {
+				prefix = fieldName + "_"
+			}
+			for _, percentile := range s.DistributionPercentiles {
+				name := prefix + s.percentileField(percentile)
+				fields[name] = stats.percentile(float64(percentile))
+			}
+		}
+		if len(fields) > 0 {
+			s.emit(acc, hash, m.name, fields, m.tags, telegraf.Untyped, m.samples, now, m.timestamp)
+		}
+		s.emitHistogramBuckets(acc, hash, m, now)
+	}
+	s.distributionStats = make(map[string]cachedtimings)
+
+	for hash, m := range s.timings {
+		percentiles, overridden := s.percentilesFor(m.name)
+		// Defining a template to parse field names for timers allows us to split
+		// out multiple fields per timer. In this case we prefix each stat with the
+		// field name and store these all in a single measurement.
+		fields := make(map[string]interface{})
+		for fieldName, stats := range m.fields {
+			var prefix string
+			if fieldName != s.defaultField() {
+				prefix = fieldName + "_"
+			}
+			// Only the windowed percentiles are available for fields without
+			// values in the current interval
+			if stats.count() > 0 {
+				fields[prefix+"mean"] = stats.mean()
+				if stats.sampled > 0 {
+					fields[prefix+"median"] = stats.median()
+				}
+				fields[prefix+"stddev"] = stats.stddev()
+				fields[prefix+"sum"] = stats.sum()
+				fields[prefix+"upper"] = stats.upper()
+				fields[prefix+"lower"] = stats.lower()
+				if s.FloatTimings {
+					fields[prefix+"count"] = float64(stats.count())
+				} else {
+					fields[prefix+"count"] = stats.count()
+				}
+			}
+			if ex, found := m.exemplars[fieldName]; found {
+				fields[prefix+"exemplar_value"] = ex.value
+				fields[prefix+"exemplar_"+s.ExemplarTag] = ex.id
+			}
+			if stats.window > 0 {
+				stats.expire(now)
+				m.fields[fieldName] = stats
+			}
+			if stats.percentileCount() == 0 || s.inPercentileWarmup(hash, now) {
+				continue
+			}
+			for i, percentile := range percentiles {
+				if s.AdaptivePercentiles && stats.percentileCount() < s.percentileMinSamples(percentiles, i, overridden) {
+					continue
+				}
+				name := prefix + s.percentileField(percentile)
+				fields[name] = stats.percentile(float64(percentile))
+			}
+		}
+		s.emitHistogramBuckets(acc, hash, m, now)
+		if len(fields) == 0 {
+			continue
+		}
+		s.emit(acc, hash, m.name, fields, m.tags, telegraf.Untyped, m.samples, now, m.timestamp)
+		m.samples = 0
+		s.timings[hash] = m
+	}
+	if s.CumulativeTimings {
+		s.resetTimingSamples()
+	} else if s.DeleteTimings {
+		s.resetTimings()
+	}
+
+	for hash, m := range s.gauges {
+		fields := make(map[string]interface{}, len(m.fields))
+		for field, v := range m.fields {
+			fields[field] = v
 		}
+		if s.GaugeExtraStats {
+			s.addGaugeExtraStats(m, fields)
+		}
+		s.emit(acc, hash, m.name, fields, m.tags, telegraf.Gauge, m.samples, now, m.timestamp)
+		m.samples = 0
+		s.gauges[hash] = m
 
-		if s.FloatCounters {
-			for key := range m.fields {
-				m.fields[key] = float64(m.fields[key].(int64))
+		if s.ResetAdditiveGauges {
+			for field := range m.fields {
+				m.fields[field] = m.base[field]
 			}
 		}
-		acc.AddCounter(m.name, m.fields, m.tags, now)
+	}
+	if s.DeleteGauges {
+		s.gauges = make(map[string]cachedgauge)
+	}
+
+	for hash, m := range s.counters {
+		if s.MirrorTemporality {
+			s.emitMirroredCounter(acc, hash, m, interval, now)
+			m.samples = 0
+			s.counters[hash] = m
+			continue
+		}
+
+		// Copy the fields as the cached values must stay integers to allow
+		// further aggregation of each field
+		fields := make(map[string]interface{}, len(m.fields))
+		for field, v := range m.fields {
+			fields[field] = s.counterValue(v.(int64))
+		}
+		if s.CounterRate || s.CounterAcceleration {
+			s.addCounterRates(hash, m, fields, interval, now)
+		}
+		s.emit(acc, hash, m.name, fields, m.tags, telegraf.Counter, m.samples, now, m.timestamp)
+		m.samples = 0
+		s.counters[hash] = m
 	}
 	if s.DeleteCounters {
 		s.counters = make(map[string]cachedcounter)
 	}
 
-	for _, m := range s.sets {
+	for hash, m := range s.sets {
+		if s.SetWindow > 0 {
+			s.expireSetMembers(m, now)
+			if len(m.fields) == 0 {
+				continue
+			}
+		}
+
 		fields := make(map[string]interface{})
 		for field, set := range m.fields {
+			count := float64(len(set))
+			for _, scale := range m.scales[field] {
+				count += scale - 1
+			}
 			if s.FloatSets {
-				fields[field] = float64(len(set))
+				fields[field] = count
 			} else {
-				fields[field] = int64(len(set))
+				fields[field] = int64(math.Round(count))
 			}
 		}
-		if s.EnableAggregationTemporality {
-			fields["start_time"] = s.lastGatherTime.Format(time.RFC3339)
+		s.emit(acc, hash, m.name, fields, m.tags, telegraf.Untyped, m.samples, now, m.timestamp)
+		m.samples = 0
+		s.sets[hash] = m
+	}
+	if s.DeleteSets {
+		s.resetSets()
+	}
+
+	if s.TrackActiveClients {
+		fields := map[string]interface{}{
+			"clients_active": int64(len(s.activeClients)),
 		}
+		acc.AddGauge("statsd", fields, nil, now)
+		s.activeClients = make(map[string]struct{})
+	}
 
-		acc.AddFields(m.name, fields, m.tags, now)
+	if s.LogHotSeriesInterval > 0 || s.EmitHotSeries {
+		s.reportHotSeries(acc, now)
 	}
-	if s.DeleteSets {
-		s.sets = make(map[string]cachedset)
+	if s.LogDropSummaryInterval > 0 && now.Sub(s.dropSummaryTime) >= time.Duration(s.LogDropSummaryInterval) {
+		s.logDropSummary(now)
+	}
+
+	if s.ReportInternalStats {
+		fields := map[string]interface{}{
+			"dropped": s.drops.Load(),
+			"pending": int64(len(s.in)),
+		}
+		tags := map[string]string{"address": s.ServiceAddress}
+		acc.AddFields("internal_statsd", fields, tags, now)
+	}
+
+	s.updateUtilization(interval)
+
+	if suppressed := s.parseErrors.flush(); suppressed > 0 {
+		s.Log.Warnf("Suppressed %d parse errors in the last interval", suppressed)
 	}
 
 	s.expireCachedMetrics()
+	s.pruneSeriesState(now)
+	if s.MaxCachedMetrics > 0 {
+		s.series.prune(s.seriesExists)
+	}
+	if s.interns != nil {
+		s.interns.prune(now)
+	}
 
 	s.lastGatherTime = now
 	return nil
@@ -441,16 +1577,25 @@ func (s *Statsd) Stop() {
 		if s.UDPlistener != nil {
 			s.UDPlistener.Close()
 		}
+		for _, conn := range s.udpListeners {
+			conn.Close()
+		}
+		if s.unixgramListener != nil {
+			s.unixgramListener.Close()
+		}
 	} else {
 		if s.TCPlistener != nil {
 			s.TCPlistener.Close()
 		}
+		if s.unixListener != nil {
+			s.unixListener.Close()
+		}
 
 		// Close all open TCP connections
 		//  - get all conns from the s.conns map and put into slice
 		//  - this is so the forget() function doesnt conflict with looping
 		//    over the s.conns map
-		var conns []*net.TCPConn
+		var conns []net.Conn
 		s.cleanup.Lock()
 		for _, conn := range s.conns {
 			conns = append(conns, conn)
@@ -464,12 +1609,85 @@ func (s *Statsd) Stop() {
 
 	s.wg.Wait()
 
+	// All writers to the queue exited, so remaining messages can be parsed
+	// safely before closing it. The agent does not gather after stopping the
+	// plugin, so the aggregated metrics are emitted here.
+	if s.DrainOnStop {
+		s.drain()
+		if s.acc != nil {
+			if err := s.Gather(s.acc); err != nil {
+				s.Log.Errorf("Gathering drained metrics failed: %v", err)
+			}
+		}
+	}
+
+	if s.socketPath != "" {
+		// Ignore file-not-exists errors when removing the socket
+		if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.Log.Errorf("Removing socket failed: %v", err)
+		}
+	}
+
+	if s.geoip != nil {
+		if err := s.geoip.close(); err != nil {
+			s.Log.Errorf("Closing GeoIP database failed: %v", err)
+		}
+	}
+
 	s.Lock()
 	close(s.in)
 	s.Log.Infof("Stopped listener service on %q", s.ServiceAddress)
 	s.Unlock()
 }
 
+// listenUDP opens the configured number of UDP sockets, multiple sockets are
+// bound to the same address using SO_REUSEPORT
+func (s *Statsd) listenUDP() ([]*net.UDPConn, error) {
+	address, err := net.ResolveUDPAddr(s.Protocol, s.ServiceAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.UDPListeners <= 1 {
+		conn, err := net.ListenUDP(s.Protocol, address)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{conn}, nil
+	}
+
+	lc := net.ListenConfig{Control: reusePortControl}
+	conns := make([]*net.UDPConn, 0, s.UDPListeners)
+	addr := address.String()
+	for range s.UDPListeners {
+		pc, err := lc.ListenPacket(context.Background(), s.Protocol, addr)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, fmt.Errorf("opening UDP listener %d of %d failed: %w", len(conns)+1, s.UDPListeners, err)
+		}
+		conn := pc.(*net.UDPConn)
+		conns = append(conns, conn)
+
+		// Bind the further sockets to the port chosen for the first one in
+		// case the OS picks the port
+		addr = conn.LocalAddr().String()
+	}
+	return conns, nil
+}
+
+// reusePortControl enables SO_REUSEPORT on UDP sockets before binding them
+func reusePortControl(_, _ string, rc syscall.RawConn) error {
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = setReusePort(fd)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
 // tcpListen() starts listening for TCP packets on the configured port.
 func (s *Statsd) tcpListen(listener *net.TCPListener) error {
 	for {
@@ -495,540 +1713,2442 @@ func (s *Statsd) tcpListen(listener *net.TCPListener) error {
 				}
 			}
 
-			select {
-			case <-s.accept:
-				// not over connection limit, handle the connection properly.
-				s.wg.Add(1)
-				// generate a random id for this TCPConn
-				id, err := internal.RandomString(6)
+			if err := s.serve(conn); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// unixListen starts accepting connections on the Unix stream socket.
+func (s *Statsd) unixListen(listener *net.UnixListener) error {
+	for {
+		select {
+		case <-s.done:
+			return nil
+		default:
+			conn, err := listener.AcceptUnix()
+			if err != nil {
+				return err
+			}
+			if err := s.serve(conn); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// serve handles the accepted stream connection if below the connection limit
+// and refuses it otherwise
+func (s *Statsd) serve(conn net.Conn) error {
+	select {
+	case <-s.accept:
+		// not over connection limit, handle the connection properly.
+		s.wg.Add(1)
+		// generate a random id for this connection
+		id, err := internal.RandomString(6)
+		if err != nil {
+			return err
+		}
+
+		s.remember(id, conn)
+		go s.handler(conn, id)
+	default:
+		// We are over the connection limit, refuse & close.
+		s.refuser(conn)
+	}
+	return nil
+}
+
+// listenUnix creates the Unix domain socket listener for the configured
+// stream or datagram protocol at the service address path
+func (s *Statsd) listenUnix(ac telegraf.Accumulator) error {
+	path := s.ServiceAddress
+	if path == defaultServiceAddress {
+		return fmt.Errorf("service_address must be set to a socket path for protocol %q", s.Protocol)
+	}
+
+	// Only remove stale sockets left over e.g. by a crash, never other files
+	// accidentally configured as address
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%q exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("removing socket failed: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("checking socket failed: %w", err)
+	}
+
+	var perm os.FileMode
+	if s.UnixSocketMode != "" {
+		// Convert from octal in string to int
+		i, err := strconv.ParseUint(s.UnixSocketMode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("converting unix_socket_mode failed: %w", err)
+		}
+		perm = os.FileMode(uint32(i))
+	}
+
+	var listen func() error
+	var listener io.Closer
+	address := &net.UnixAddr{Name: path, Net: s.Protocol}
+	if s.Protocol == "unixgram" {
+		conn, err := net.ListenUnixgram(s.Protocol, address)
+		if err != nil {
+			return err
+		}
+		s.unixgramListener = conn
+		listener = conn
+		listen = func() error { return s.udpListen(conn) }
+	} else {
+		l, err := net.ListenUnix(s.Protocol, address)
+		if err != nil {
+			return err
+		}
+		s.unixListener = l
+		listener = l
+		listen = func() error { return s.unixListen(l) }
+	}
+	s.socketPath = path
+
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			listener.Close()
+			return fmt.Errorf("changing socket permissions failed: %w", err)
+		}
+	}
+
+	s.Log.Infof("Listening on Unix socket %q", path)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if !s.waitReady() {
+			return
+		}
+		if err := listen(); err != nil {
+			ac.AddError(err)
+		}
+	}()
+	return nil
+}
+
+// packetConn is a datagram connection with a configurable read buffer, i.e.
+// a UDP or unixgram connection
+type packetConn interface {
+	net.PacketConn
+	SetReadBuffer(bytes int) error
+}
+
+// udpListen starts listening for UDP packets on the configured port or for
+// datagrams on the Unix socket.
+func (s *Statsd) udpListen(conn packetConn) error {
+	if s.ReadBufferSize > 0 {
+		if err := conn.SetReadBuffer(s.ReadBufferSize); err != nil {
+			return err
+		}
+	}
+
+	size := udpMaxPacketSize
+	if s.UDPMaxPacketSize > 0 {
+		size = s.UDPMaxPacketSize
+	}
+	buf := make([]byte, size)
+
+	// Compressed datagrams are decoded before queueing, the decoder reuses
+	// its buffer so the payload is copied before decoding the next datagram
+	var decoder internal.ContentDecoder
+	if s.ContentEncoding != "" && s.ContentEncoding != "identity" {
+		var err error
+		decoder, err = internal.NewContentDecoder(s.ContentEncoding, internal.WithMaxDecompressionSize(int64(s.MaxDecompressionSize)))
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-s.done:
+			return nil
+		default:
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				if !strings.Contains(err.Error(), "closed network") {
+					s.Log.Errorf("Error reading: %s", err.Error())
+					continue
+				}
+				return nil
+			}
+			s.Stats.UDPPacketsRecv.Incr(1)
+			s.Stats.UDPBytesRecv.Incr(int64(n))
+			payload := buf[:n]
+			if decoder != nil {
+				payload, err = decoder.Decode(payload)
 				if err != nil {
+					s.countDrop("decoding_failed", 1)
+					s.Log.Errorf("Decoding %s datagram failed: %v", s.ContentEncoding, err)
+					continue
+				}
+			}
+			b, ok := s.bufPool.Get().(*bytes.Buffer)
+			if !ok {
+				return errors.New("bufPool is not a bytes buffer")
+			}
+			b.Reset()
+			b.Write(payload)
+			// Datagrams received on Unix sockets have no source address
+			var source string
+			var port int
+			if udpAddr, ok := addr.(*net.UDPAddr); ok {
+				source = udpAddr.IP.String()
+				port = udpAddr.Port
+			}
+			if !s.limitSource(b, source, time.Now()) {
+				s.bufPool.Put(b)
+				continue
+			}
+			select {
+			case s.in <- input{
+				Buffer: b,
+				Time:   time.Now(),
+				Addr:   source,
+				Port:   port}:
+				s.updatePendingMessages()
+			default:
+				s.updatePendingMessages()
+				s.Stats.UDPPacketsDrop.Incr(1)
+				s.countDrop("queue_full", 1)
+				drops := s.drops.Add(1)
+				if drops == 1 || s.AllowedPendingMessages == 0 || drops%int64(s.AllowedPendingMessages) == 0 {
+					s.Log.Errorf("Statsd message queue full. "+
+						"We have dropped %d messages so far. "+
+						"You may want to increase allowed_pending_messages in the config", drops)
+				}
+			}
+		}
+	}
+}
+
+// updatePendingMessages updates the number of pending messages and tracks the
+// transitions of the queue between congested and recovered using the
+// configured watermarks
+func (s *Statsd) updatePendingMessages() {
+	pending := len(s.in)
+	s.Stats.PendingMessages.Set(int64(pending))
+
+	// Without a high watermark the queue can never recover
+	if s.PendingMessagesHighWatermark <= 0 {
+		return
+	}
+	if pending >= s.PendingMessagesHighWatermark {
+		s.congested.Store(true)
+	} else if pending <= s.PendingMessagesLowWatermark && s.congested.CompareAndSwap(true, false) {
+		s.Stats.QueueRecoveries.Incr(1)
+		s.Log.Infof("Statsd message queue recovered with %d pending messages", pending)
+	}
+}
+
+// parser monitors the s.in channel, if there is a packet ready, it parses the
+// packet into statsd strings and then calls parseStatsdLine, which parses a
+// single statsd metric into a struct.
+func (s *Statsd) parser(stats workerStats) error {
+	for {
+		wait := time.Now()
+		select {
+		case <-s.done:
+			return nil
+		case in := <-s.in:
+			s.updatePendingMessages()
+			start := time.Now()
+			stats.idle.Incr(start.Sub(wait).Nanoseconds())
+			if err := s.parseInput(in); err != nil {
+				return err
+			}
+			elapsed := time.Since(start)
+			s.Stats.ParseTimeNS.Set(elapsed.Nanoseconds())
+			stats.busy.Incr(elapsed.Nanoseconds())
+		}
+	}
+}
+
+// parseInput parses the lines of the given message
+func (s *Statsd) parseInput(in input) error {
+	lines := strings.Split(in.Buffer.String(), "\n")
+	s.bufPool.Put(in.Buffer)
+	if s.PauseMode == "discard" && s.isPaused(in.Time) {
+		var n int64
+		for _, line := range lines {
+			if strings.TrimSpace(line) != "" {
+				n++
+			}
+		}
+		s.countDrop("paused", n)
+		return nil
+	}
+	if in.Addr != "" {
+		in.Addr = normalizeAddr(in.Addr)
+	}
+	sourceTags := s.sourceTags(in.Addr, in.Port)
+	var counters map[string]metric
+	if s.CoalesceCounters {
+		counters = make(map[string]metric)
+	}
+	var parsed bool
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case s.CommentPrefix != "" && strings.HasPrefix(line, s.CommentPrefix):
+		case s.DataDogExtensions && strings.HasPrefix(line, "_e"):
+			if err := s.parseEventMessage(in.Time, line, in.Addr); err != nil {
+				// Log the line causing the parsing error and continue
+				// with the next line to not stop the whole gathering
+				// process.
+				s.parseErrorf("Parsing line failed: %v", err)
+				s.Log.Debugf("  line was: %s", line)
+			}
+		case s.DataDogExtensions && strings.HasPrefix(line, "_sc|"):
+			if err := s.parseServiceCheck(in.Time, line, in.Addr); err != nil {
+				s.parseErrorf("Parsing line failed: %v", err)
+				s.Log.Debugf("  line was: %s", line)
+			}
+		default:
+			if err := s.parseStatsdLineWithTags(line, sourceTags, counters); err != nil {
+				if !errors.Is(err, errParsing) {
+					// Ignore parsing errors but error out on
+					// everything else...
 					return err
 				}
+				continue
+			}
+			parsed = true
+		}
+	}
+	for _, m := range counters {
+		if err := s.aggregate(m); err != nil && !errors.Is(err, errParsing) {
+			return err
+		}
+	}
+	if parsed && s.TrackActiveClients && in.Addr != "" {
+		s.trackClient(in.Addr)
+	}
+	return nil
+}
 
-				s.remember(id, conn)
-				go s.handler(conn, id)
-			default:
-				// We are over the connection limit, refuse & close.
-				s.refuser(conn)
+// drain parses the messages still queued after the listeners and parsers
+// stopped until the queue is empty or the drain timeout is exceeded. Must be
+// called after all writers to the queue exited.
+func (s *Statsd) drain() {
+	timeout := time.Duration(s.DrainTimeout)
+	if timeout == 0 {
+		timeout = defaultDrainTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	var drained int
+	for time.Now().Before(deadline) {
+		select {
+		case in := <-s.in:
+			if err := s.parseInput(in); err != nil {
+				s.Log.Errorf("Draining message failed: %v", err)
+			}
+			drained++
+		default:
+			s.Log.Debugf("Drained %d pending messages", drained)
+			return
+		}
+	}
+	s.Log.Warnf("Draining timed out after %s, discarding %d pending messages", timeout, len(s.in))
+}
+
+// normalizeAddr returns the canonical form of the given IP address without
+// brackets and zone, converting IPv4-mapped IPv6 addresses to IPv4. Addresses
+// which cannot be parsed are returned unchanged.
+func normalizeAddr(addr string) string {
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return addr
+	}
+	return ip.Unmap().String()
+}
+
+// sourceTags returns the tags derived from the given sender's address and port
+func (s *Statsd) sourceTags(addr string, port int) map[string]string {
+	if addr == "" {
+		return nil
+	}
+	var tags map[string]string
+	if s.geoip != nil {
+		tags = s.geoip.lookup(addr)
+	}
+	if s.SourceIPTag == "" && s.SourcePortTag == "" {
+		return tags
+	}
+
+	// Copy the tags as the GeoIP tags are shared by the lookup cache
+	merged := make(map[string]string, len(tags)+2)
+	maps.Copy(merged, tags)
+	if s.SourceIPTag != "" {
+		merged[s.SourceIPTag] = addr
+	}
+	if s.SourcePortTag != "" {
+		merged[s.SourcePortTag] = strconv.Itoa(port)
+	}
+	return merged
+}
+
+// parseStatsdLine will parse the given statsd line, validating it as it goes.
+// If the line is valid, it will be cached for the next call to Gather()
+func (s *Statsd) parseStatsdLine(line string) error {
+	return s.parseStatsdLineWithTags(line, nil, nil)
+}
+
+// parseStatsdLineWithTags parses the line adding the given tags of the
+// message source to the metrics unless the metric already has the tag. If
+// counters is given, counter increments are summed up per series and field
+// in the map instead of being aggregated.
+func (s *Statsd) parseStatsdLineWithTags(line string, sourceTags map[string]string, counters map[string]metric) error {
+	if s.debugRing != nil {
+		s.captureLine(line)
+	}
+
+	lineTags := make(map[string]string)
+	var timestamp time.Time
+	if s.DataDogExtensions {
+		recombinedSegments := make([]string, 0)
+		// datadog tags look like this:
+		// users.online:1|c|@0.5|#country:china,environment:production
+		// users.online:1|c|#sometagwithnovalue
+		// we will split on the pipe and remove any elements that are datadog
+		// tags, parse them, and rebuild the line sans the datadog tags
+		pipesplit := strings.Split(line, "|")
+		for i, segment := range pipesplit {
+			if i > 0 && len(segment) > 1 && segment[0] == 'T' {
+				// This is the optional client timestamp of protocol v1.3
+				ts, err := s.parseClientTimestamp(segment[1:])
+				if err != nil {
+					s.parseErrorf("%v, unable to parse metric: %s", err, line)
+					return errParsing
+				}
+				timestamp = ts
+			} else if len(segment) > 0 && segment[0] == '#' {
+				// we have ourselves a tag; they are comma separated
+				parseDataDogTags(lineTags, segment[1:])
+			} else if len(segment) > 0 && strings.HasPrefix(segment, "c:") {
+				// This is optional container ID field
+				if s.DataDogKeepContainerTag {
+					lineTags["container"] = segment[2:]
+				}
+			} else {
+				recombinedSegments = append(recombinedSegments, segment)
 			}
 		}
+		line = strings.Join(recombinedSegments, "|")
+		if s.SanitizeTags {
+			lineTags = s.sanitizeTags(lineTags)
+		}
+	}
+
+	// Validate splitting the line on ":"
+	bits := strings.Split(line, ":")
+	if len(bits) < 2 {
+		s.parseErrorf("Splitting ':', unable to parse metric: %s", line)
+		return errParsing
+	}
+
+	// Extract bucket name from individual metric bits
+	bucketName, bits := bits[0], bits[1:]
+
+	// Add a metric for each bit available
+	for _, bit := range bits {
+		m := metric{}
+
+		m.bucket = bucketName
+		m.timestamp = timestamp
+
+		// Validate splitting the bit on "|"
+		pipesplit := strings.Split(bit, "|")
+		if len(pipesplit) < 2 {
+			s.parseErrorf("Splitting '|', unable to parse metric: %s", line)
+			return errParsing
+		} else if len(pipesplit) > 2 {
+			sr := pipesplit[2]
+
+			if strings.Contains(sr, "@") && len(sr) > 1 {
+				samplerate, err := strconv.ParseFloat(sr[1:], 64)
+				if err != nil {
+					s.parseErrorf("Parsing sample rate: %s", err.Error())
+				} else {
+					// sample rate successfully parsed
+					m.samplerate = samplerate
+				}
+			} else {
+				s.Log.Debugf("Sample rate must be in format like: "+
+					"@0.1, @0.5, etc. Ignoring sample rate for line: %s", line)
+			}
+		}
+
+		// Handle lines with an empty type, e.g. due to a trailing pipe
+		if pipesplit[1] == "" {
+			if s.DefaultMetricType == "" {
+				s.Stats.EmptyMetricTypes.Incr(1)
+				s.countDrop("empty_type", 1)
+				s.parseErrorf("Empty metric type, unable to parse metric: %s", line)
+				return errParsing
+			}
+			pipesplit[1] = s.DefaultMetricType
+		}
+
+		// Validate metric type
+		switch pipesplit[1] {
+		case "g", "c", "s", "ms", "h", "d":
+			m.mtype = pipesplit[1]
+		default:
+			s.parseErrorf("Metric type %q unsupported", pipesplit[1])
+			return errParsing
+		}
+
+		// Substitute the default for empty values if configured for the type
+		if pipesplit[0] == "" {
+			if v, ok := s.EmptyValueDefault[m.mtype]; ok {
+				pipesplit[0] = v
+			}
+		}
+
+		// Parse the value
+		if strings.HasPrefix(pipesplit[0], "-") || strings.HasPrefix(pipesplit[0], "+") {
+			switch {
+			case m.mtype == "g" || m.mtype == "c":
+				m.additive = true
+			case (m.mtype == "ms" || m.mtype == "h") && strings.HasPrefix(pipesplit[0], "-"):
+				// Negative timings are absolute values if accepted
+				if !s.AllowNegativeTimings {
+					s.Stats.NegativeTimingsDropped.Incr(1)
+					s.countDrop("negative_timing", 1)
+					s.parseErrorf("Negative timing values are not allowed, unable to parse metric: %s", line)
+					return errParsing
+				}
+			default:
+				s.parseErrorf("+- values are only supported for gauges & counters, unable to parse metric: %s", line)
+				return errParsing
+			}
+		}
+
+		switch m.mtype {
+		case "g", "ms", "h", "d":
+			v, err := strconv.ParseFloat(pipesplit[0], 64)
+			if err != nil {
+				s.parseErrorf("Parsing value to float64, unable to parse metric: %s", line)
+				return errParsing
+			}
+			if s.StrictSignSemantics && m.mtype == "g" && m.additive && v == 0 {
+				s.parseErrorf("Signed zero gauge values are ambiguous, use 0 to reset the gauge, unable to parse metric: %s", line)
+				return errParsing
+			}
+			if m.mtype == "g" && m.additive && m.samplerate != 0 && m.samplerate != 1 {
+				switch s.GaugeSampleRate {
+				case "scale":
+					if m.samplerate < 0 || m.samplerate > 1 {
+						s.parseErrorf("Invalid sample rate %v, unable to parse metric: %s", m.samplerate, line)
+						return errParsing
+					}
+					v /= m.samplerate
+				case "reject":
+					s.countDrop("gauge_sample_rate", 1)
+					s.parseErrorf("Sample rates of additive gauges are ambiguous, unable to parse metric: %s", line)
+					return errParsing
+				default:
+					if s.gaugeSampleRateWarned.CompareAndSwap(false, true) {
+						s.Log.Warnf("Ignoring the sample rate of additive gauges like %q, see the gauge_sample_rate setting", line)
+					}
+				}
+			}
+			m.floatvalue = v
+		case "c":
+			var v int64
+			v, err := strconv.ParseInt(pipesplit[0], 10, 64)
+			if err != nil {
+				v2, err2 := strconv.ParseFloat(pipesplit[0], 64)
+				if err2 != nil {
+					s.parseErrorf("Parsing value to int64, unable to parse metric: %s", line)
+					return errParsing
+				}
+				v = int64(v2)
+			}
+			// If a sample rate is given with a counter, divide value by the rate
+			if m.samplerate != 0 && m.mtype == "c" {
+				v = int64(float64(v) / m.samplerate)
+			}
+			m.intvalue = v
+		case "s":
+			m.strvalue = pipesplit[0]
+		}
+
+		// Parse the name & tags from bucket
+		m.name, m.field, m.tags = s.parseName(m.bucket, m.mtype)
+		if metricType := metricTypeName(m.mtype); metricType != "" {
+			m.tags["metric_type"] = metricType
+		}
+		// Match the name the metric is emitted with
+		if s.nameFilter != nil && !s.nameFilter.Match(s.measurement(m.name, m.tags)) {
+			s.Stats.FilteredLines.Incr(1)
+			s.countDrop("name_filter", 1)
+			continue
+		}
+		if s.EnableAggregationTemporality {
+			if temporality := s.temporality(m.tags["metric_type"]); temporality != "" {
+				m.tags["temporality"] = temporality
+			}
+		}
+		if len(lineTags) > 0 {
+			for k, v := range lineTags {
+				m.tags[k] = v
+			}
+		}
+		if s.ExemplarTag != "" && (m.mtype == "ms" || m.mtype == "h") {
+			// Keep the trace tag out of the series to not create a series
+			// per trace
+			if id, found := m.tags[s.ExemplarTag]; found {
+				m.exemplar = id
+				delete(m.tags, s.ExemplarTag)
+			}
+		}
+		for k, v := range sourceTags {
+			if _, found := m.tags[k]; !found {
+				m.tags[k] = v
+			}
+		}
+		for k, v := range s.DefaultTags {
+			if _, found := m.tags[k]; !found {
+				m.tags[k] = v
+			}
+		}
+		if s.SanitizeTagKeysMethod != "" {
+			sanitized := make(map[string]string, len(m.tags))
+			for k, v := range m.tags {
+				if k = s.sanitize(s.SanitizeTagKeysMethod, k); k != "" {
+					sanitized[k] = v
+				}
+			}
+			m.tags = sanitized
+		}
+		if s.MaxTagsetBytes > 0 && tagsetSize(m.tags) > s.MaxTagsetBytes {
+			s.Stats.OversizedTagsets.Incr(1)
+			if s.MaxTagsetAction == "drop" {
+				s.countDrop("oversized_tagset", 1)
+				s.parseErrorf("Tag set exceeds %d bytes, dropping metric: %s", s.MaxTagsetBytes, line)
+				continue
+			}
+			truncateTagset(m.tags, s.MaxTagsetBytes)
+		}
+
+		if s.interns != nil {
+			m.name, m.tags = s.interns.internSeries(m.name, m.tags, time.Now())
+		}
+
+		// Make a unique key for the measurement name/tags
+		m.hash = s.seriesKey(m.name, m.tags)
+
+		if counters != nil && m.mtype == "c" {
+			// Sum up the increments of the same series and field, the newline
+			// cannot be part of the hash or the field
+			key := m.hash + "\n" + m.field
+			if pending, found := counters[key]; found {
+				pending.intvalue += m.intvalue
+				pending.samples++
+				pending.timestamp = m.timestamp
+				m = pending
+			} else {
+				m.samples = 1
+			}
+			counters[key] = m
+			continue
+		}
+
+		if err := s.aggregate(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tagsetSize returns the size of the tags serialized as comma separated
+// key=value pairs
+func tagsetSize(tags map[string]string) int {
+	if len(tags) == 0 {
+		return 0
+	}
+	size := len(tags) - 1
+	for k, v := range tags {
+		size += len(k) + len(v) + 1
+	}
+	return size
+}
+
+// truncateTagset removes the tags with the largest values, ordered by key for
+// equal sizes, until the serialized tag set does not exceed the limit
+func truncateTagset(tags map[string]string, limit int) {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(tags[keys[i]]) != len(tags[keys[j]]) {
+			return len(tags[keys[i]]) > len(tags[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	size := tagsetSize(tags)
+	for _, k := range keys {
+		if size <= limit {
+			return
+		}
+		size -= len(k) + len(tags[k]) + 1
+		if len(tags) > 1 {
+			size--
+		}
+		delete(tags, k)
+	}
+}
+
+// extractPatternTags applies the tag patterns to the name, adding the
+// non-empty named capture groups to the tags unless already present, and
+// returns the name with the matches removed if configured
+func (s *Statsd) extractPatternTags(name string, tags map[string]string) string {
+	for _, re := range s.tagPatterns {
+		match := re.FindStringSubmatchIndex(name)
+		if match == nil {
+			continue
+		}
+		for i, key := range re.SubexpNames() {
+			if key == "" || match[2*i] == match[2*i+1] {
+				continue
+			}
+			if _, found := tags[key]; !found {
+				tags[key] = name[match[2*i]:match[2*i+1]]
+			}
+		}
+		if s.TagPatternsStrip {
+			name = name[:match[0]] + name[match[1]:]
+		}
+	}
+	return name
+}
+
+// parseName parses the given bucket name with the list of bucket maps in the
+// config file. If there is a match, it will parse the name of the metric and
+// map of tags.
+// Return values are (<name>, <field>, <tags>)
+func (s *Statsd) parseName(bucket, mtype string) (name, field string, tags map[string]string) {
+	tags = make(map[string]string)
+
+	// The dimensions are separated by commas as well, so those are extracted
+	// before splitting the tags
+	var dimensions map[string]string
+	if s.SignalFxDimensions {
+		bucket, dimensions = extractSignalFxDimensions(bucket)
+	}
+
+	// Buckets containing backslashes might use line-protocol escaping
+	escaped := strings.IndexByte(bucket, '\\') >= 0
+	var bucketparts []string
+	if escaped {
+		bucketparts = splitUnescaped(bucket, ',')
+	} else {
+		bucketparts = strings.Split(bucket, ",")
+	}
+	// Parse out any tags in the bucket
+	if len(bucketparts) > 1 || len(dimensions) > 0 {
+		for _, btag := range bucketparts[1:] {
+			var k, v string
+			if escaped {
+				k, v = parseEscapedKeyValue(btag)
+			} else {
+				k, v = parseKeyValue(btag)
+			}
+			if k != "" {
+				tags[k] = v
+			}
+		}
+		maps.Copy(tags, dimensions)
+		if s.SanitizeTags {
+			tags = s.sanitizeTags(tags)
+		}
+	}
+
+	method := s.SanitizeNamesMethod
+	if m, found := s.SanitizeNamesMethodPerType[metricTypeName(mtype)]; found {
+		method = m
+	}
+	name = bucketparts[0]
+	if escaped {
+		name = tagUnescaper.Replace(name)
+	}
+	name = s.sanitize(method, name)
+
+	// Split off the namespace, a bucket without any separator has none
+	if s.FirstSegmentAsTag != "" {
+		if namespace, remainder, found := strings.Cut(name, "."); found && namespace != "" && remainder != "" {
+			tags[s.FirstSegmentAsTag] = namespace
+			name = remainder
+		}
+	}
+	name = s.extractPatternTags(name, tags)
+
+	// Only the bucket separators are trimmed, the template joins the
+	// remaining parts so no empty parts are created
+	if s.TrimSeparators {
+		name = strings.Trim(name, ".")
+	}
+
+	separator := s.templateSeparator()
+	if tp := s.templateParser(separator); tp.parser != nil {
+		templated, templateTags, templateField := tp.apply(name)
+		// Tags extracted by the template take precedence over bucket tags
+		for k, v := range tags {
+			if _, found := templateTags[k]; !found {
+				templateTags[k] = v
+			}
+		}
+		name, tags, field = templated, templateTags, templateField
+	}
+
+	if s.ConvertNames {
+		converted := strings.ReplaceAll(name, ".", "_")
+		converted = strings.ReplaceAll(converted, "-", "__")
+		if s.DetectNameCollisions {
+			s.Lock()
+			s.checkNameCollision(name, converted)
+			s.Unlock()
+		}
+		name = converted
+	}
+	if mapped, found := s.NameMap[name]; found {
+		name = mapped
+	}
+	if s.MetricNamePrefix != "" {
+		name = s.MetricNamePrefix + s.MetricSeparator + name
+	}
+	if s.MetricNameSuffix != "" {
+		name = name + s.MetricSeparator + s.MetricNameSuffix
+	}
+	if field == "" {
+		field = s.defaultField()
+	}
+
+	return name, field, tags
+}
+
+// templateSeparator returns the separator used for applying the templates
+func (s *Statsd) templateSeparator() string {
+	if s.TemplateSeparator != "" {
+		return s.TemplateSeparator
+	}
+	return s.MetricSeparator
+}
+
+// templateParser is the graphite parser applying the templates using the
+// separator it was created for. Applying a template updates the template's
+// state, so the parser is locked separately from the plugin while applying.
+type templateParser struct {
+	sync.Mutex
+	separator string
+	parser    *graphite.Parser
+}
+
+// apply applies the matching template to the name
+func (tp *templateParser) apply(name string) (measurement string, tags map[string]string, field string) {
+	tp.Lock()
+	defer tp.Unlock()
+	//nolint:errcheck // unable to propagate
+	measurement, tags, field, _ = tp.parser.ApplyTemplate(name)
+	return measurement, tags, field
+}
+
+// templateParser returns the template parser for the given separator with a
+// nil graphite parser if the templates are invalid. The parser is created once
+// and only replaced if the separator changes.
+func (s *Statsd) templateParser(separator string) *templateParser {
+	if tp := s.graphiteParser.Load(); tp != nil && tp.separator == separator {
+		return tp
+	}
+	tp := &templateParser{separator: separator}
+	p := &graphite.Parser{Separator: separator, Templates: s.Templates}
+	if err := p.Init(); err != nil {
+		s.Log.Errorf("Initializing templates failed, ignoring them: %v", err)
+	} else {
+		tp.parser = p
+	}
+	s.graphiteParser.Store(tp)
+	return tp
+}
+
+// parseClientTimestamp parses the given unix timestamp in seconds and checks
+// it against the configured window around the current time
+func (s *Statsd) parseClientTimestamp(value string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+	}
+	ts := time.Unix(seconds, 0)
+
+	if window := time.Duration(s.DataDogTimestampWindow); window > 0 {
+		if diff := time.Since(ts); diff > window || diff < -window {
+			s.countDrop("timestamp_window", 1)
+			return time.Time{}, fmt.Errorf("timestamp %q outside of the window of %s", value, window)
+		}
+	}
+	return ts, nil
+}
+
+// captureLine adds the raw line to the debug ring if its bucket matches the
+// configured filter
+func (s *Statsd) captureLine(line string) {
+	if s.debugBuckets != nil {
+		bucket := line
+		if i := strings.IndexAny(line, ",:"); i >= 0 {
+			bucket = line[:i]
+		}
+		if !s.debugBuckets.Match(bucket) {
+			return
+		}
+	}
+	s.debugRing.add(line)
+}
+
+// parseErrorf logs a parsing error honoring the configured rate limit
+func (s *Statsd) parseErrorf(format string, args ...interface{}) {
+	if s.ParseErrorLogRate <= 0 {
+		s.Log.Errorf(format, args...)
+		return
+	}
+
+	ok, suppressed := s.parseErrors.allow(time.Now(), s.ParseErrorLogRate)
+	if suppressed > 0 {
+		s.Log.Warnf("Suppressed %d parse errors in the last interval", suppressed)
+	}
+	if ok {
+		s.Log.Errorf(format, args...)
+	}
+}
+
+// metricTypeName returns the name of the given statsd metric type as used in
+// the "metric_type" tag
+func metricTypeName(mtype string) string {
+	switch mtype {
+	case "c":
+		return "counter"
+	case "g":
+		return "gauge"
+	case "s":
+		return "set"
+	case "ms":
+		return "timing"
+	case "h":
+		return "histogram"
+	case "d":
+		return "distribution"
+	}
+	return ""
+}
+
+// sanitize cleans the given name or tag key according to the method
+func (s *Statsd) sanitize(method, value string) string {
+	switch method {
+	case "":
+	case "upstream":
+		value = sanitizeWhitespace.ReplaceAllString(value, "_")
+		value = strings.ReplaceAll(value, "/", "-")
+		value = sanitizeAllowedChars.ReplaceAllString(value, "")
+	default:
+		s.Log.Errorf("Unknown sanitize method: %s", method)
+	}
+	return value
+}
+
+// sanitizeTags returns the tags with the keys and values sanitized using the
+// "upstream" method, dropping tags with an empty key or value
+func (s *Statsd) sanitizeTags(tags map[string]string) map[string]string {
+	sanitized := make(map[string]string, len(tags))
+	for k, v := range tags {
+		k = s.sanitize("upstream", k)
+		v = s.sanitize("upstream", v)
+		if k != "" && v != "" {
+			sanitized[k] = v
+		}
+	}
+	return sanitized
+}
+
+// checkNameCollision reports if the original name is converted to the same
+// name as a different, previously seen name. Must be called with the lock held.
+func (s *Statsd) checkNameCollision(original, converted string) {
+	first, found := s.convertedNames[converted]
+	if !found {
+		if len(s.convertedNames) < maxTrackedNames {
+			s.convertedNames[converted] = original
+		}
+		return
+	}
+
+	if first != original && !s.nameCollisions[original] {
+		s.nameCollisions[original] = true
+		s.Stats.NameCollisions.Incr(1)
+		s.Log.Warnf("Names %q and %q are both converted to %q", first, original, converted)
+	}
+}
+
+// Parse the key,value out of a string that looks like "key=value"
+func parseKeyValue(keyValue string) (key, val string) {
+	split := strings.Split(keyValue, "=")
+	// Must be exactly 2 to get anything meaningful out of them
+	if len(split) == 2 {
+		key = split[0]
+		val = split[1]
+	} else if len(split) == 1 {
+		val = split[0]
+	} else if len(split) > 2 {
+		// fix: https://github.com/influxdata/telegraf/issues/10113
+		// fix: value has "=" parse error
+		// uri=/service/endpoint?sampleParam={paramValue} parse value key="uri", val="/service/endpoint?sampleParam\={paramValue}"
+		key = split[0]
+		val = strings.Join(split[1:], "=")
+	}
+
+	return key, val
+}
+
+// extractSignalFxDimensions removes the dimensions enclosed in brackets, e.g.
+// "name[k1=v1,k2=v2]", from the bucket and returns them as tags. Brackets may
+// occur anywhere in the name and may be nested within a dimension value which
+// is kept as is, e.g. "k=[v]". Buckets with unbalanced brackets are returned
+// unchanged.
+func extractSignalFxDimensions(bucket string) (string, map[string]string) {
+	if strings.IndexByte(bucket, '[') < 0 {
+		return bucket, nil
+	}
+
+	var name strings.Builder
+	var dimensions map[string]string
+	var depth, start, last int
+	for i := 0; i < len(bucket); i++ {
+		switch bucket[i] {
+		case '[':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case ']':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			name.WriteString(bucket[last:start])
+			last = i + 1
+			for _, dimension := range splitOutsideBrackets(bucket[start+1 : i]) {
+				if k, v := parseKeyValue(dimension); k != "" {
+					if dimensions == nil {
+						dimensions = make(map[string]string)
+					}
+					dimensions[k] = v
+				}
+			}
+		}
+	}
+	if depth > 0 {
+		return bucket, nil
+	}
+	name.WriteString(bucket[last:])
+	return name.String(), dimensions
+}
+
+// splitOutsideBrackets splits the string at the commas not enclosed in
+// brackets
+func splitOutsideBrackets(s string) []string {
+	var parts []string
+	var depth, last int
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(parts, s[last:])
+}
+
+// parseEscapedKeyValue splits the tag at the first equal sign not escaped by
+// a backslash and removes the line-protocol escaping from key and value
+func parseEscapedKeyValue(keyValue string) (key, val string) {
+	parts := splitUnescaped(keyValue, '=')
+	if len(parts) == 1 {
+		return "", tagUnescaper.Replace(parts[0])
+	}
+	key = tagUnescaper.Replace(parts[0])
+	val = tagUnescaper.Replace(strings.Join(parts[1:], "="))
+	return key, val
+}
+
+// splitUnescaped splits the string at each occurrence of the separator not
+// escaped by a backslash, keeping the escape sequences
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	var start int
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			// Skip the escaped character
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// aggregate takes in a metric. It then
+// aggregates and caches the current value(s). It does not deal with the
+// Delete* options, because those are dealt with in the Gather function.
+func (s *Statsd) aggregate(m metric) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.TypeConflictPolicy == "first_type_wins" || s.TypeConflictPolicy == "error" {
+		// Timings and histograms are aggregated the same way
+		mtype := m.mtype
+		if mtype == "h" {
+			mtype = "ms"
+		}
+		first, found := s.metricTypes[m.name]
+		if !found && len(s.metricTypes) < maxTrackedNames {
+			s.metricTypes[m.name] = mtype
+		}
+		if found && first != mtype {
+			// Coalesced counters represent multiple lines
+			s.countDrop("type_conflict", max(m.samples, 1))
+			if s.TypeConflictPolicy == "error" {
+				s.parseErrorf("Metric %q of type %q conflicts with type %q seen before", m.name, m.mtype, first)
+				return errParsing
+			}
+			return nil
+		}
+	}
+
+	if s.LogHotSeriesInterval > 0 || s.EmitHotSeries {
+		s.hotSeries.add(m.hash, m.name, m.tags, max(m.samples, 1))
+	}
+
+	switch m.mtype {
+	case "d":
+		if !s.DataDogExtensions || !s.DataDogDistributions {
+			break
+		}
+		repeats := 1
+		if s.ApplySampleRateToDistributions && m.samplerate > 0 {
+			repeats = max(int(1.0/m.samplerate), 1)
+		}
+		if len(s.DistributionPercentiles) > 0 || len(s.HistogramBuckets) > 0 {
+			// Aggregate the samples of the interval to compute percentiles
+			// and histogram buckets
+			cached, ok := s.distributionStats[m.hash]
+			if !ok {
+				cached = cachedtimings{
+					name:   m.name,
+					fields: make(map[string]runningStats),
+					tags:   m.tags,
+				}
+			}
+			field, ok := cached.fields[m.field]
+			if !ok {
+				field = runningStats{
+					percLimit: s.PercentileLimit,
+					useDigest: s.PercentileMethod == "tdigest",
+					bounds:    s.HistogramBuckets,
+				}
+			}
+			for range repeats {
+				field.addValue(m.floatvalue)
+			}
+			cached.fields[m.field] = field
+			cached.samples++
+			cached.timestamp = m.timestamp
+			s.distributionStats[m.hash] = cached
+		} else {
+			cached := cacheddistributions{
+				name:      m.name,
+				hash:      m.hash,
+				value:     m.floatvalue,
+				tags:      m.tags,
+				timestamp: m.timestamp,
+			}
+			for range repeats {
+				s.distributions = append(s.distributions, cached)
+			}
+		}
+	case "ms", "h":
+		// Check if the measurement exists
+		cached, ok := s.timings[m.hash]
+		if !ok {
+			cached = cachedtimings{
+				name:   m.name,
+				fields: make(map[string]runningStats),
+				tags:   m.tags,
+			}
+		}
+		// Check if the field exists. If we've not enabled multiple fields per timer
+		// this will be the default field name, eg. "value"
+		field, ok := cached.fields[m.field]
+		if !ok {
+			field = runningStats{
+				percLimit: s.PercentileLimit,
+				window:    time.Duration(s.PercentileWindow),
+				useDigest: s.PercentileMethod == "tdigest",
+				bounds:    s.HistogramBuckets,
+			}
+		}
+		now := time.Now()
+		if m.samplerate > 0 {
+			for i := 0; i < int(1.0/m.samplerate); i++ {
+				field.addTimedValue(m.floatvalue, now)
+			}
+		} else {
+			field.addTimedValue(m.floatvalue, now)
+		}
+		cached.fields[m.field] = field
+		cached.samples++
+		cached.expiresAt = now.Add(time.Duration(s.MaxTTL))
+		cached.timestamp = m.timestamp
+		if m.exemplar != "" {
+			// Keep the latest exemplar of the interval
+			if cached.exemplars == nil {
+				cached.exemplars = make(map[string]exemplar)
+			}
+			cached.exemplars[m.field] = exemplar{value: m.floatvalue, id: m.exemplar}
+		}
+		s.timings[m.hash] = cached
+		if s.PercentileWarmup > 0 {
+			warmup, ok := s.percentileWarmups[m.hash]
+			if !ok {
+				warmup.firstSeen = now
+			}
+			warmup.lastSeen = now
+			warmup.expiresAt = cached.expiresAt
+			s.percentileWarmups[m.hash] = warmup
+		}
+	case "c":
+		// check if the measurement exists
+		cached, ok := s.counters[m.hash]
+		if !ok {
+			cached = cachedcounter{
+				name:   m.name,
+				fields: make(map[string]interface{}),
+				tags:   m.tags,
+			}
+		}
+		// check if the field exists
+		_, ok = cached.fields[m.field]
+		if !ok {
+			cached.fields[m.field] = int64(0)
+		}
+		cached.fields[m.field] = cached.fields[m.field].(int64) + m.intvalue
+		cached.samples += max(m.samples, 1)
+		cached.expiresAt = time.Now().Add(time.Duration(s.MaxTTL))
+		cached.timestamp = m.timestamp
+		s.counters[m.hash] = cached
+	case "g":
+		// check if the measurement exists
+		cached, ok := s.gauges[m.hash]
+		if !ok {
+			cached = cachedgauge{
+				name:    m.name,
+				fields:  make(map[string]interface{}),
+				tags:    m.tags,
+				base:    make(map[string]float64),
+				extrema: make(map[string]gaugeExtrema),
+			}
+		}
+		// check if the field exists
+		_, ok = cached.fields[m.field]
+		if !ok {
+			cached.fields[m.field] = float64(0)
+		}
+		if m.additive {
+			cached.fields[m.field] = cached.fields[m.field].(float64) + m.floatvalue
+		} else {
+			cached.fields[m.field] = m.floatvalue
+			cached.base[m.field] = m.floatvalue
+		}
+		if s.GaugeExtraStats {
+			value := cached.fields[m.field].(float64)
+			extrema, ok := cached.extrema[m.field]
+			if !ok {
+				extrema = gaugeExtrema{min: value, max: value}
+			}
+			extrema.min = min(extrema.min, value)
+			extrema.max = max(extrema.max, value)
+			cached.extrema[m.field] = extrema
+		}
+		cached.samples++
+
+		cached.expiresAt = time.Now().Add(time.Duration(s.MaxTTL))
+		cached.timestamp = m.timestamp
+		s.gauges[m.hash] = cached
+	case "s":
+		// check if the measurement exists
+		cached, ok := s.sets[m.hash]
+		if !ok {
+			cached = cachedset{
+				name:   m.name,
+				fields: make(map[string]map[string]bool),
+				tags:   m.tags,
+			}
+		}
+		// check if the field exists
+		_, ok = cached.fields[m.field]
+		if !ok {
+			cached.fields[m.field] = make(map[string]bool)
+		}
+		cached.samples++
+		now := time.Now()
+		// New members of sets at the cardinality limit are ignored, so the
+		// unique count becomes a lower bound
+		members := cached.fields[m.field]
+		if s.MaxSetCardinality > 0 && len(members) >= s.MaxSetCardinality && !members[m.strvalue] {
+			s.Stats.SetCardinalityCapped.Incr(1)
+			s.countDrop("set_cardinality", 1)
+			cached.expiresAt = now.Add(time.Duration(s.MaxTTL))
+			cached.timestamp = m.timestamp
+			s.sets[m.hash] = cached
+			break
+		}
+		members[m.strvalue] = true
+		if s.SetWindow > 0 {
+			if cached.seen == nil {
+				cached.seen = make(map[string]map[string]time.Time)
+			}
+			if _, ok := cached.seen[m.field]; !ok {
+				cached.seen[m.field] = make(map[string]time.Time)
+			}
+			cached.seen[m.field][m.strvalue] = now
+		}
+		if s.ScaleSampledSets {
+			if m.samplerate > 0 && m.samplerate < 1 {
+				if cached.scales == nil {
+					cached.scales = make(map[string]map[string]float64)
+				}
+				if _, ok := cached.scales[m.field]; !ok {
+					cached.scales[m.field] = make(map[string]float64)
+				}
+				cached.scales[m.field][m.strvalue] = 1 / m.samplerate
+			} else {
+				delete(cached.scales[m.field], m.strvalue)
+			}
+		}
+		cached.expiresAt = now.Add(time.Duration(s.MaxTTL))
+		cached.timestamp = m.timestamp
+		s.sets[m.hash] = cached
+	}
+
+	if s.MaxCachedMetrics > 0 {
+		s.trackSeries(m)
+	}
+
+	return nil
+}
+
+// reportHotSeries emits and logs the series with the most updates within the
+// interval and resets the update counts
+func (s *Statsd) reportHotSeries(acc telegraf.Accumulator, now time.Time) {
+	count := s.HotSeriesCount
+	if count == 0 {
+		count = defaultHotSeriesCount
+	}
+	top := s.hotSeries.top(count)
+	s.hotSeries.reset()
+
+	if s.EmitHotSeries {
+		for i, entry := range top {
+			fields := map[string]interface{}{"updates": entry.updates}
+			tags := map[string]string{"series": entry.series, "rank": strconv.Itoa(i + 1)}
+			acc.AddGauge("statsd_hot_series", fields, tags, now)
+		}
+	}
+
+	interval := time.Duration(s.LogHotSeriesInterval)
+	if interval == 0 || len(top) == 0 || now.Sub(s.hotSeriesLogged) < interval {
+		return
+	}
+	entries := make([]string, 0, len(top))
+	for _, entry := range top {
+		entries = append(entries, fmt.Sprintf("%s (%d)", entry.series, entry.updates))
+	}
+	s.Log.Infof("Most updated series in the last interval: %s", strings.Join(entries, ", "))
+	s.hotSeriesLogged = now
+}
+
+// emitFieldCounts emits the number of distinct fields currently cached for
+// each measurement across all series and metric types
+func (s *Statsd) emitFieldCounts(acc telegraf.Accumulator, now time.Time) {
+	measurements := make(map[string]map[string]bool)
+	add := func(name string, tags map[string]string, field string) {
+		name = s.measurement(name, tags)
+		if _, found := measurements[name]; !found {
+			measurements[name] = make(map[string]bool)
+		}
+		measurements[name][field] = true
+	}
+
+	for _, m := range s.gauges {
+		for field := range m.fields {
+			add(m.name, m.tags, field)
+		}
+	}
+	for _, m := range s.counters {
+		for field := range m.fields {
+			add(m.name, m.tags, field)
+		}
+	}
+	for _, m := range s.sets {
+		for field := range m.fields {
+			add(m.name, m.tags, field)
+		}
+	}
+	for _, m := range s.timings {
+		for field := range m.fields {
+			add(m.name, m.tags, field)
+		}
+	}
+	for _, m := range s.distributionStats {
+		for field := range m.fields {
+			add(m.name, m.tags, field)
+		}
+	}
+
+	for name, fields := range measurements {
+		tags := map[string]string{"measurement": name}
+		acc.AddGauge("statsd_fields", map[string]interface{}{"distinct": int64(len(fields))}, tags, now)
+	}
+}
+
+// sampleSeriesCounts updates the statistics of the number of series currently
+// cached per metric type and emits those if requested. Must be called with
+// the lock held.
+func (s *Statsd) sampleSeriesCounts(acc telegraf.Accumulator, now time.Time) {
+	s.Stats.SeriesGauges.Set(int64(len(s.gauges)))
+	s.Stats.SeriesCounters.Set(int64(len(s.counters)))
+	s.Stats.SeriesSets.Set(int64(len(s.sets)))
+	s.Stats.SeriesTimings.Set(int64(len(s.timings)))
+
+	if s.ReportSeriesCounts {
+		fields := map[string]interface{}{
+			"gauges":   int64(len(s.gauges)),
+			"counters": int64(len(s.counters)),
+			"sets":     int64(len(s.sets)),
+			"timings":  int64(len(s.timings)),
+		}
+		acc.AddGauge("statsd_series", fields, map[string]string{"address": s.ServiceAddress}, now)
+	}
+}
+
+// logDropSummary logs the number of lines dropped per reason and the number
+// of evicted series since the last summary if any. Must be called with the
+// lock held.
+func (s *Statsd) logDropSummary(now time.Time) {
+	if s.dropsLogged == nil {
+		s.dropsLogged = make(map[string]int64, len(dropReasons))
+	}
+	entries := make([]string, 0, len(dropReasons))
+	for _, reason := range dropReasons {
+		total := s.Stats.Dropped[reason].Get()
+		if n := total - s.dropsLogged[reason]; n > 0 {
+			entries = append(entries, fmt.Sprintf("%s=%d", reason, n))
+		}
+		s.dropsLogged[reason] = total
+	}
+	s.dropSummaryTime = now
+	if len(entries) > 0 {
+		s.Log.Infof("Dropped lines since the last summary: %s", strings.Join(entries, ", "))
+	}
+
+	// Evictions drop whole series instead of lines
+	evictions := s.Stats.CacheEvictions.Get()
+	if n := evictions - s.evictionsLogged; n > 0 {
+		s.Log.Infof("Evicted %d cached series since the last summary", n)
+	}
+	s.evictionsLogged = evictions
+}
+
+// trackSeries marks the series of the metric as updated and evicts the least
+// recently updated series exceeding the limit. Must be called with the lock
+// held.
+func (s *Statsd) trackSeries(m metric) {
+	mtype := m.mtype
+	switch mtype {
+	case "d":
+		// Distributions are not cached across intervals
+		return
+	case "h":
+		// Histograms are cached as timings
+		mtype = "ms"
+	}
+
+	s.series.touch(seriesKey{mtype: mtype, hash: m.hash})
+	for s.series.len() > s.MaxCachedMetrics {
+		key, _ := s.series.evict()
+		switch key.mtype {
+		case "g":
+			delete(s.gauges, key.hash)
+		case "c":
+			delete(s.counters, key.hash)
+		case "s":
+			delete(s.sets, key.hash)
+		case "ms":
+			delete(s.timings, key.hash)
+			delete(s.percentileWarmups, key.hash)
+		}
+		delete(s.sequences, key.hash)
+		s.Stats.CacheEvictions.Incr(1)
+	}
+}
+
+// seriesExists checks if the series is still cached. Must be called with the
+// lock held.
+func (s *Statsd) seriesExists(key seriesKey) bool {
+	var found bool
+	switch key.mtype {
+	case "g":
+		_, found = s.gauges[key.hash]
+	case "c":
+		_, found = s.counters[key.hash]
+	case "s":
+		_, found = s.sets[key.hash]
+	case "ms":
+		_, found = s.timings[key.hash]
+	}
+	return found
+}
+
+// handler handles a single TCP Connection
+func (s *Statsd) handler(conn net.Conn, id string) {
+	s.Stats.CurrentConnections.Incr(1)
+	s.Stats.TotalConnections.Incr(1)
+	// connection cleanup function
+	defer func() {
+		s.wg.Done()
+		conn.Close()
+
+		// Add one connection potential back to channel when this one closes
+		s.accept <- true
+		s.forget(id)
+		s.Stats.CurrentConnections.Incr(-1)
+	}()
+
+	var remoteIP string
+	var remotePort int
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		remoteIP = addr.IP.String()
+		remotePort = addr.Port
+	}
+
+	if s.TCPProxyProtocol {
+		pconn, err := acceptProxyHeader(conn)
+		if err != nil {
+			s.Log.Errorf("Reading PROXY protocol header from %s failed, closing the connection: %v", remoteIP, err)
+			return
+		}
+		if addr := pconn.source; addr.IsValid() {
+			remoteIP = addr.Addr().Unmap().String()
+			remotePort = int(addr.Port())
+		}
+		conn = pconn
+	}
+
+	var reader io.Reader = conn
+	if s.tlsConfig != nil {
+		// The handshake is performed on the first read, connections failing
+		// it are closed when the scanner stops
+		reader = tls.Server(conn, s.tlsConfig)
 	}
-}
 
-// udpListen starts listening for UDP packets on the configured port.
-func (s *Statsd) udpListen(conn *net.UDPConn) error {
-	if s.ReadBufferSize > 0 {
-		if err := s.UDPlistener.SetReadBuffer(s.ReadBufferSize); err != nil {
-			return err
-		}
+	if s.TCPChunkSize > 0 {
+		s.handleChunks(conn, reader, remoteIP, remotePort)
+		return
 	}
 
-	buf := make([]byte, udpMaxPacketSize)
+	var n int
+	scanner := bufio.NewScanner(reader)
+	if s.TCPMaxLineSize > 0 {
+		scanner.Buffer(make([]byte, 0, min(s.TCPMaxLineSize, bufio.MaxScanTokenSize)), s.TCPMaxLineSize)
+	}
 	for {
 		select {
 		case <-s.done:
-			return nil
+			return
 		default:
-			n, addr, err := conn.ReadFromUDP(buf)
-			if err != nil {
-				if !strings.Contains(err.Error(), "closed network") {
-					s.Log.Errorf("Error reading: %s", err.Error())
-					continue
+			if s.TCPIdleTimeout > 0 {
+				if err := conn.SetReadDeadline(time.Now().Add(time.Duration(s.TCPIdleTimeout))); err != nil {
+					s.Log.Errorf("Setting read deadline for connection %s failed: %v", remoteIP, err)
+					return
 				}
-				return nil
-			}
-			s.Stats.UDPPacketsRecv.Incr(1)
-			s.Stats.UDPBytesRecv.Incr(int64(n))
-			b, ok := s.bufPool.Get().(*bytes.Buffer)
-			if !ok {
-				return errors.New("bufPool is not a bytes buffer")
 			}
-			b.Reset()
-			b.Write(buf[:n])
-			select {
-			case s.in <- input{
-				Buffer: b,
-				Time:   time.Now(),
-				Addr:   addr.IP.String()}:
-				s.Stats.PendingMessages.Set(int64(len(s.in)))
-			default:
-				s.Stats.UDPPacketsDrop.Incr(1)
-				s.drops++
-				if s.drops == 1 || s.AllowedPendingMessages == 0 || s.drops%s.AllowedPendingMessages == 0 {
-					s.Log.Errorf("Statsd message queue full. "+
-						"We have dropped %d messages so far. "+
-						"You may want to increase allowed_pending_messages in the config", s.drops)
+			if !scanner.Scan() {
+				err := scanner.Err()
+				switch {
+				case errors.Is(err, os.ErrDeadlineExceeded):
+					s.Log.Debugf("Closing connection %s idle for %s", remoteIP, time.Duration(s.TCPIdleTimeout))
+					s.Stats.IdleConnectionsClosed.Incr(1)
+				case errors.Is(err, bufio.ErrTooLong):
+					// The scanner cannot skip the line, so the remaining data
+					// of the connection is lost
+					s.Log.Errorf("Line received from %s exceeds %d bytes, closing the connection. "+
+						"You may want to increase tcp_max_line_size in the config", remoteIP, s.maxLineSize())
+				case err != nil && s.tlsConfig != nil:
+					s.Log.Debugf("Reading from TLS connection %s failed: %v", remoteIP, err)
 				}
+				return
+			}
+			n = len(scanner.Bytes())
+			if n == 0 {
+				continue
 			}
+			s.Stats.TCPBytesRecv.Incr(int64(n))
+			s.Stats.TCPPacketsRecv.Incr(1)
+
+			b := s.bufPool.Get().(*bytes.Buffer)
+			b.Reset()
+			s.writeTCPLine(b, scanner.Text())
+			s.enqueueTCP(b, remoteIP, remotePort)
 		}
 	}
 }
 
-// parser monitors the s.in channel, if there is a packet ready, it parses the
-// packet into statsd strings and then calls parseStatsdLine, which parses a
-// single statsd metric into a struct.
-func (s *Statsd) parser() error {
+// handleChunks reads the connection in chunks of TCPChunkSize bytes and
+// queues the complete lines of each chunk as one message, keeping incomplete
+// lines for the next chunk
+func (s *Statsd) handleChunks(conn net.Conn, reader io.Reader, remoteIP string, remotePort int) {
+	chunk := make([]byte, s.TCPChunkSize)
+	var partial []byte
 	for {
 		select {
 		case <-s.done:
-			return nil
-		case in := <-s.in:
-			s.Stats.PendingMessages.Set(int64(len(s.in)))
-			start := time.Now()
-			lines := strings.Split(in.Buffer.String(), "\n")
-			s.bufPool.Put(in.Buffer)
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				switch {
-				case line == "":
-				case s.DataDogExtensions && strings.HasPrefix(line, "_e"):
-					if err := s.parseEventMessage(in.Time, line, in.Addr); err != nil {
-						// Log the line causing the parsing error and continue
-						// with the next line to not stop the whole gathering
-						// process.
-						s.Log.Errorf("Parsing line failed: %v", err)
-						s.Log.Debugf("  line was: %s", line)
-					}
-				default:
-					if err := s.parseStatsdLine(line); err != nil {
-						if !errors.Is(err, errParsing) {
-							// Ignore parsing errors but error out on
-							// everything else...
-							return err
-						}
-					}
+			return
+		default:
+		}
+
+		if s.TCPIdleTimeout > 0 {
+			if err := conn.SetReadDeadline(time.Now().Add(time.Duration(s.TCPIdleTimeout))); err != nil {
+				s.Log.Errorf("Setting read deadline for connection %s failed: %v", remoteIP, err)
+				return
+			}
+		}
+		n, err := reader.Read(chunk)
+		if n > 0 {
+			s.Stats.TCPBytesRecv.Incr(int64(n))
+			data := chunk[:n]
+			if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+				partial = append(partial, data[:i+1]...)
+				s.enqueueTCPLines(partial, remoteIP, remotePort)
+				partial = append(partial[:0], data[i+1:]...)
+			} else {
+				partial = append(partial, data...)
+			}
+			if len(partial) > s.maxLineSize() {
+				s.Log.Errorf("Line received from %s exceeds %d bytes, closing the connection. "+
+					"You may want to increase tcp_max_line_size in the config", remoteIP, s.maxLineSize())
+				return
+			}
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, io.EOF):
+				// The last line might not be terminated by a newline
+				if len(partial) > 0 {
+					s.enqueueTCPLines(partial, remoteIP, remotePort)
 				}
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				s.Log.Debugf("Closing connection %s idle for %s", remoteIP, time.Duration(s.TCPIdleTimeout))
+				s.Stats.IdleConnectionsClosed.Incr(1)
+			case s.tlsConfig != nil:
+				s.Log.Debugf("Reading from TLS connection %s failed: %v", remoteIP, err)
 			}
-			elapsed := time.Since(start)
-			s.Stats.ParseTimeNS.Set(elapsed.Nanoseconds())
+			return
 		}
 	}
 }
 
-// parseStatsdLine will parse the given statsd line, validating it as it goes.
-// If the line is valid, it will be cached for the next call to Gather()
-func (s *Statsd) parseStatsdLine(line string) error {
-	lineTags := make(map[string]string)
-	if s.DataDogExtensions {
-		recombinedSegments := make([]string, 0)
-		// datadog tags look like this:
-		// users.online:1|c|@0.5|#country:china,environment:production
-		// users.online:1|c|#sometagwithnovalue
-		// we will split on the pipe and remove any elements that are datadog
-		// tags, parse them, and rebuild the line sans the datadog tags
-		pipesplit := strings.Split(line, "|")
-		for _, segment := range pipesplit {
-			if len(segment) > 0 && segment[0] == '#' {
-				// we have ourselves a tag; they are comma separated
-				parseDataDogTags(lineTags, segment[1:])
-			} else if len(segment) > 0 && strings.HasPrefix(segment, "c:") {
-				// This is optional container ID field
-				if s.DataDogKeepContainerTag {
-					lineTags["container"] = segment[2:]
-				}
-			} else {
-				recombinedSegments = append(recombinedSegments, segment)
-			}
+// enqueueTCPLines queues the newline separated lines as one message
+func (s *Statsd) enqueueTCPLines(data []byte, addr string, port int) {
+	b := s.bufPool.Get().(*bytes.Buffer)
+	b.Reset()
+	var lines int64
+	for len(data) > 0 {
+		line, rest, _ := bytes.Cut(data, []byte{'\n'})
+		data = rest
+		if line = bytes.TrimSuffix(line, []byte{'\r'}); len(line) == 0 {
+			continue
 		}
-		line = strings.Join(recombinedSegments, "|")
+		s.writeTCPLine(b, string(line))
+		lines++
 	}
+	if lines == 0 {
+		s.bufPool.Put(b)
+		return
+	}
+	s.Stats.TCPPacketsRecv.Incr(lines)
+	s.enqueueTCP(b, addr, port)
+}
 
-	// Validate splitting the line on ":"
-	bits := strings.Split(line, ":")
-	if len(bits) < 2 {
-		s.Log.Errorf("Splitting ':', unable to parse metric: %s", line)
-		return errParsing
+// writeTCPLine writes the line received via TCP to the buffer, splitting
+// concatenated metrics if enabled
+func (s *Statsd) writeTCPLine(b *bytes.Buffer, line string) {
+	if !s.TCPFallbackSplit {
+		b.WriteString(line)
+		b.WriteByte('\n')
+		return
 	}
+	for _, l := range splitConcatenatedMetrics(line) {
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+}
 
-	// Extract bucket name from individual metric bits
-	bucketName, bits := bits[0], bits[1:]
+// enqueueTCP queues the message received via TCP for parsing, dropping it if
+// the queue is full
+func (s *Statsd) enqueueTCP(b *bytes.Buffer, addr string, port int) {
+	now := time.Now()
+	if !s.limitSource(b, addr, now) {
+		s.bufPool.Put(b)
+		return
+	}
+	select {
+	case s.in <- input{Buffer: b, Time: now, Addr: addr, Port: port}:
+		s.updatePendingMessages()
+	default:
+		s.updatePendingMessages()
+		s.countDrop("queue_full", 1)
+		drops := s.drops.Add(1)
+		if drops == 1 || drops%int64(s.AllowedPendingMessages) == 0 {
+			s.Log.Errorf("Statsd message queue full. "+
+				"We have dropped %d messages so far. "+
+				"You may want to increase allowed_pending_messages in the config", drops)
+		}
+	}
+}
 
-	// Add a metric for each bit available
-	for _, bit := range bits {
-		m := metric{}
+// limitSource removes the lines of the message exceeding the rate limit of
+// the given source before queueing, so noisy sources cannot fill the queue.
+// Returns false if no line is left.
+func (s *Statsd) limitSource(b *bytes.Buffer, addr string, now time.Time) bool {
+	if s.MaxLinesPerSecondPerSource <= 0 || addr == "" {
+		return true
+	}
+	source := normalizeAddr(addr)
 
-		m.bucket = bucketName
+	// Compact the accepted lines in place, they never overtake the lines
+	// still to be checked
+	data := b.Bytes()
+	kept := data[:0]
+	for len(data) > 0 {
+		line, rest, found := bytes.Cut(data, []byte{'\n'})
+		data = rest
+		trimmed := strings.TrimSpace(string(line))
+		switch {
+		case trimmed == "":
+			continue
+		case s.CommentPrefix != "" && strings.HasPrefix(trimmed, s.CommentPrefix):
+		case !s.sourceLimits.allow(source, now, s.MaxLinesPerSecondPerSource):
+			s.Stats.RateLimitedLines.Incr(1)
+			s.countDrop("rate_limited", 1)
+			continue
+		}
+		kept = append(kept, line...)
+		if found {
+			kept = append(kept, '\n')
+		}
+	}
+	b.Truncate(len(kept))
+	return len(kept) > 0
+}
 
-		// Validate splitting the bit on "|"
-		pipesplit := strings.Split(bit, "|")
-		if len(pipesplit) < 2 {
-			s.Log.Errorf("Splitting '|', unable to parse metric: %s", line)
-			return errParsing
-		} else if len(pipesplit) > 2 {
-			sr := pipesplit[2]
+// maxLineSize returns the maximum length of a line received via TCP
+func (s *Statsd) maxLineSize() int {
+	if s.TCPMaxLineSize > 0 {
+		return s.TCPMaxLineSize
+	}
+	return bufio.MaxScanTokenSize
+}
 
-			if strings.Contains(sr, "@") && len(sr) > 1 {
-				samplerate, err := strconv.ParseFloat(sr[1:], 64)
-				if err != nil {
-					s.Log.Errorf("Parsing sample rate: %s", err.Error())
-				} else {
-					// sample rate successfully parsed
-					m.samplerate = samplerate
-				}
-			} else {
-				s.Log.Debugf("Sample rate must be in format like: "+
-					"@0.1, @0.5, etc. Ignoring sample rate for line: %s", line)
+// splitConcatenatedMetrics heuristically splits a line containing multiple
+// metrics without separating newlines after each type segment. The line is
+// returned as-is if it contains at most one metric. DataDog tags or container
+// IDs can only be assigned to the last metric of such a line as the end of
+// their segment is ambiguous.
+func splitConcatenatedMetrics(line string) []string {
+	locs := metricTypeSegment.FindAllStringIndex(line, -1)
+	if len(locs) < 2 {
+		return []string{line}
+	}
+
+	lines := make([]string, 0, len(locs))
+	var start int
+	for _, loc := range locs[:len(locs)-1] {
+		end := loc[1]
+		// Skip DataDog container IDs like "|c:<id>"
+		if end < len(line) && (line[end] == ':' || line[end] == '|') {
+			continue
+		}
+		lines = append(lines, line[start:end])
+		start = end
+	}
+	return append(lines, line[start:])
+}
+
+// refuser refuses a TCP connection
+func (s *Statsd) refuser(conn net.Conn) {
+	conn.Close()
+	s.Log.Infof("Refused TCP Connection from %s", conn.RemoteAddr())
+	s.Log.Warn("Maximum TCP Connections reached, you may want to adjust max_tcp_connections")
+}
+
+// forget a TCP connection
+func (s *Statsd) forget(id string) {
+	s.cleanup.Lock()
+	defer s.cleanup.Unlock()
+	delete(s.conns, id)
+}
+
+// remember a TCP connection
+func (s *Statsd) remember(id string, conn net.Conn) {
+	s.cleanup.Lock()
+	defer s.cleanup.Unlock()
+	s.conns[id] = conn
+}
+
+// gatherInterval returns the time elapsed since the last gather. The interval
+// is measured using the monotonic clock reading of the timestamps if available
+// so wall clock steps do not affect it. Non-positive intervals, e.g. due to a
+// backward clock jump for timestamps without monotonic reading, are counted
+// and returned as zero to skip the derived fields.
+func (s *Statsd) gatherInterval(now time.Time) time.Duration {
+	interval := now.Sub(s.lastGatherTime)
+	if interval > 0 {
+		return interval
+	}
+
+	s.Stats.ClockRegressions.Incr(1)
+	s.Log.Warnf("Non-positive gather interval %s, skipping interval based fields", interval)
+	return 0
+}
+
+// updateUtilization computes the percentage of time the parser workers were
+// busy during the given interval since the last gather
+func (s *Statsd) updateUtilization(interval time.Duration) {
+	if len(s.workers) == 0 {
+		return
+	}
+
+	var busy int64
+	for _, w := range s.workers {
+		busy += w.busy.Get()
+	}
+	available := interval.Nanoseconds() * int64(len(s.workers))
+	if available > 0 {
+		s.Stats.ParserUtilization.Set(min(100, 100*(busy-s.lastWorkerBusy)/available))
+	}
+	s.lastWorkerBusy = busy
+}
+
+// emit adds the fields of a series to the accumulator, applying the settings
+// common to all metric types. The metric is emitted with the given client
+// timestamp if set and the gather time otherwise.
+func (s *Statsd) emit(
+	acc telegraf.Accumulator,
+	hash, name string,
+	fields map[string]interface{},
+	tags map[string]string,
+	vtype telegraf.ValueType,
+	samples int64,
+	now, timestamp time.Time,
+) {
+	if s.SingleFieldNaming == "name" && len(fields) == 1 {
+		if v, ok := fields[s.defaultField()]; ok {
+			delete(fields, s.defaultField())
+			fields[name] = v
+		}
+	}
+	if s.EnableAggregationTemporality {
+		fields["start_time"] = s.lastGatherTime.Format(time.RFC3339)
+	}
+	if s.EmitSequence {
+		fields["sequence"] = s.nextSequence(hash, now)
+	}
+	if s.EmitSampleCount {
+		fields["samples"] = samples
+	}
+	if s.EmitSeriesID {
+		// Copy the tags as they are shared with the cache
+		withID := make(map[string]string, len(tags)+1)
+		maps.Copy(withID, tags)
+		withID["series_id"] = fmt.Sprintf("%016x", seriesDigest(name, tags))
+		tags = withID
+	}
+	if s.FloatPrecision != nil {
+		scale := math.Pow10(*s.FloatPrecision)
+		for k, v := range fields {
+			if f, ok := v.(float64); ok {
+				fields[k] = roundFloat(f, scale)
 			}
 		}
+	}
 
-		// Validate metric type
-		switch pipesplit[1] {
-		case "g", "c", "s", "ms", "h", "d":
-			m.mtype = pipesplit[1]
-		default:
-			s.Log.Errorf("Metric type %q unsupported", pipesplit[1])
-			return errParsing
+	name = s.measurement(name, tags)
+	if s.TypeEmissionMode == "untyped" {
+		vtype = telegraf.Untyped
+	}
+	if timestamp.IsZero() {
+		timestamp = now
+	}
+	switch vtype {
+	case telegraf.Counter:
+		acc.AddCounter(name, fields, tags, timestamp)
+	case telegraf.Gauge:
+		acc.AddGauge(name, fields, tags, timestamp)
+	default:
+		acc.AddFields(name, fields, tags, timestamp)
+	}
+}
+
+// emitMirroredCounter emits the counter both as delta and as cumulative value
+// tagged with the respective temporality
+func (s *Statsd) emitMirroredCounter(
+	acc telegraf.Accumulator,
+	hash string,
+	m cachedcounter,
+	interval time.Duration,
+	now time.Time,
+) {
+	mirror, ok := s.mirroredCounters[hash]
+	if !ok {
+		mirror = mirroredCounter{totals: make(map[string]int64)}
+	}
+	mirror.expiresAt = now.Add(time.Duration(s.MaxTTL))
+	mirror.gather = s.gathers
+
+	deltas := make(map[string]interface{}, len(m.fields))
+	totals := make(map[string]interface{}, len(m.fields))
+	for field, v := range m.fields {
+		// The cached value is the delta if counters are deleted after each
+		// interval and the cumulative value otherwise
+		delta, total := v.(int64), v.(int64)
+		if s.DeleteCounters {
+			total += mirror.totals[field]
+		} else if total >= mirror.totals[field] {
+			delta -= mirror.totals[field]
+		}
+		mirror.totals[field] = total
+		deltas[field] = s.counterValue(delta)
+		totals[field] = s.counterValue(total)
+		if s.CounterRate && interval > 0 {
+			rate := float64(delta) / interval.Seconds()
+			deltas[field+"_per_second"] = rate
+			totals[field+"_per_second"] = rate
 		}
+	}
+	s.mirroredCounters[hash] = mirror
 
-		// Parse the value
-		if strings.HasPrefix(pipesplit[0], "-") || strings.HasPrefix(pipesplit[0], "+") {
-			if m.mtype != "g" && m.mtype != "c" {
-				s.Log.Errorf("+- values are only supported for gauges & counters, unable to parse metric: %s", line)
-				return errParsing
-			}
-			m.additive = true
+	for temporality, fields := range map[string]map[string]interface{}{"delta": deltas, "cumulative": totals} {
+		tags := make(map[string]string, len(m.tags)+1)
+		for k, v := range m.tags {
+			tags[k] = v
 		}
+		tags["temporality"] = temporality
+		s.emit(acc, hash+"temporality="+temporality, m.name, fields, tags, telegraf.Counter, m.samples, now, m.timestamp)
+	}
+}
 
-		switch m.mtype {
-		case "g", "ms", "h", "d":
-			v, err := strconv.ParseFloat(pipesplit[0], 64)
-			if err != nil {
-				s.Log.Errorf("Parsing value to float64, unable to parse metric: %s", line)
-				return errParsing
-			}
-			m.floatvalue = v
-		case "c":
-			var v int64
-			v, err := strconv.ParseInt(pipesplit[0], 10, 64)
-			if err != nil {
-				v2, err2 := strconv.ParseFloat(pipesplit[0], 64)
-				if err2 != nil {
-					s.Log.Errorf("Parsing value to int64, unable to parse metric: %s", line)
-					return errParsing
-				}
-				v = int64(v2)
-			}
-			// If a sample rate is given with a counter, divide value by the rate
-			if m.samplerate != 0 && m.mtype == "c" {
-				v = int64(float64(v) / m.samplerate)
-			}
-			m.intvalue = v
-		case "s":
-			m.strvalue = pipesplit[0]
+// addGaugeExtraStats adds the minimum, maximum and last value of each gauge
+// field within the interval and starts a new interval. Fields without updates
+// in the interval report their current value.
+func (s *Statsd) addGaugeExtraStats(m cachedgauge, fields map[string]interface{}) {
+	for field, v := range m.fields {
+		value := v.(float64)
+		extrema, ok := m.extrema[field]
+		if !ok {
+			extrema = gaugeExtrema{min: value, max: value}
 		}
 
-		// Parse the name & tags from bucket
-		m.name, m.field, m.tags = s.parseName(m.bucket)
-		switch m.mtype {
-		case "c":
-			m.tags["metric_type"] = "counter"
+		prefix := ""
+		if field != s.defaultField() {
+			prefix = field + "_"
+		}
+		fields[prefix+"min"] = extrema.min
+		fields[prefix+"max"] = extrema.max
+		fields[prefix+"last"] = value
+	}
+	clear(m.extrema)
+}
 
-			if s.EnableAggregationTemporality {
-				if s.DeleteCounters {
-					m.tags["temporality"] = "delta"
-				} else {
-					m.tags["temporality"] = "cumulative"
-				}
-			}
-		case "g":
-			m.tags["metric_type"] = "gauge"
-		case "s":
-			m.tags["metric_type"] = "set"
-		case "ms":
-			m.tags["metric_type"] = "timing"
-		case "h":
-			m.tags["metric_type"] = "histogram"
-		case "d":
-			m.tags["metric_type"] = "distribution"
+// addCounterRates adds the per-second rate of each counter field and its
+// change compared to the previous interval as configured. Cumulative counters
+// require a previous value to compute the rate so the rate is available from
+// the second interval on and the acceleration from the third, deleted counters
+// provide them one interval earlier. An invalid interval restarts the
+// computation.
+func (s *Statsd) addCounterRates(
+	hash string,
+	m cachedcounter,
+	fields map[string]interface{},
+	interval time.Duration,
+	now time.Time,
+) {
+	if interval <= 0 {
+		delete(s.counterRates, hash)
+		return
+	}
+	elapsed := interval.Seconds()
+
+	state, ok := s.counterRates[hash]
+	if !ok {
+		state = counterRate{
+			values: make(map[string]int64),
+			rates:  make(map[string]float64),
 		}
-		if len(lineTags) > 0 {
-			for k, v := range lineTags {
-				m.tags[k] = v
+	}
+	state.expiresAt = now.Add(time.Duration(s.MaxTTL))
+	state.gather = s.gathers
+
+	for field, v := range m.fields {
+		value := v.(int64)
+		delta := value
+		if !s.DeleteCounters {
+			last, found := state.values[field]
+			state.values[field] = value
+			if !found {
+				continue
+			}
+			// Handle counter resets by starting over from zero
+			if value >= last {
+				delta = value - last
 			}
 		}
 
-		// Make a unique key for the measurement name/tags
-		var tg []string
-		for k, v := range m.tags {
-			tg = append(tg, k+"="+v)
+		rate := float64(delta) / elapsed
+		if s.CounterRate {
+			fields[field+"_per_second"] = rate
 		}
-		sort.Strings(tg)
-		tg = append(tg, m.name)
-		m.hash = strings.Join(tg, "")
-
-		s.aggregate(m)
+		if last, found := state.rates[field]; found && s.CounterAcceleration {
+			fields[field+"_accel"] = rate - last
+		}
+		state.rates[field] = rate
 	}
+	s.counterRates[hash] = state
+}
 
-	return nil
+// counterValue returns the counter value in the configured type
+func (s *Statsd) counterValue(v int64) interface{} {
+	if s.FloatCounters {
+		return float64(v)
+	}
+	return v
 }
 
-// parseName parses the given bucket name with the list of bucket maps in the
-// config file. If there is a match, it will parse the name of the metric and
-// map of tags.
-// Return values are (<name>, <field>, <tags>)
-func (s *Statsd) parseName(bucket string) (name, field string, tags map[string]string) {
-	s.Lock()
-	defer s.Unlock()
-	tags = make(map[string]string)
+// nextSequence increments and returns the emission sequence of the series
+func (s *Statsd) nextSequence(hash string, now time.Time) int64 {
+	seq := s.sequences[hash]
+	seq.value++
+	seq.emitted = now
+	s.sequences[hash] = seq
+	return seq.value
+}
 
-	bucketparts := strings.Split(bucket, ",")
-	// Parse out any tags in the bucket
-	if len(bucketparts) > 1 {
-		for _, btag := range bucketparts[1:] {
-			k, v := parseKeyValue(btag)
-			if k != "" {
-				tags[k] = v
-			}
+// pruneSeriesState removes the state kept per series beyond the caches for
+// series which left the caches in the gather at the given time, independent
+// of max_ttl. Must be called with the lock held after resetting the caches.
+func (s *Statsd) pruneSeriesState(now time.Time) {
+	for key, seq := range s.sequences {
+		if !seq.emitted.Equal(now) && !s.seriesCached(key) {
+			delete(s.sequences, key)
 		}
 	}
 
-	name = bucketparts[0]
-	switch s.SanitizeNamesMethod {
-	case "":
-	case "upstream":
-		whitespace := regexp.MustCompile(`\s+`)
-		name = whitespace.ReplaceAllString(name, "_")
-		name = strings.ReplaceAll(name, "/", "-")
-		allowedChars := regexp.MustCompile(`[^a-zA-Z_\-0-9\.;=]`)
-		name = allowedChars.ReplaceAllString(name, "")
-	default:
-		s.Log.Errorf("Unknown sanitizae name method: %s", s.SanitizeNamesMethod)
+	// The first-seen time is only needed during the warm-up and as long as
+	// the series is cached or was received in the last interval, as timings
+	// are deleted in each gather by default
+	for key, warmup := range s.percentileWarmups {
+		_, cached := s.timings[key]
+		received := !warmup.lastSeen.Before(s.lastGatherTime)
+		if !cached && !received && now.Sub(warmup.firstSeen) >= time.Duration(s.PercentileWarmup) {
+			delete(s.percentileWarmups, key)
+		}
 	}
 
-	p := s.graphiteParser
-	var err error
-
-	if p == nil || s.graphiteParser.Separator != s.MetricSeparator {
-		p = &graphite.Parser{Separator: s.MetricSeparator, Templates: s.Templates}
-		err = p.Init()
-		s.graphiteParser = p
+	// Counters kept across intervals are emitted in each gather, so the
+	// totals and rates of counters not emitted for a while are stale
+	for key, mirror := range s.mirroredCounters {
+		if s.gathers-mirror.gather >= staleStateIntervals {
+			delete(s.mirroredCounters, key)
+		}
 	}
+	for key, rate := range s.counterRates {
+		if s.gathers-rate.gather >= staleStateIntervals {
+			delete(s.counterRates, key)
+		}
+	}
+	s.gathers++
+}
 
-	if err == nil {
-		p.DefaultTags = tags
-		//nolint:errcheck // unable to propagate
-		name, tags, field, _ = p.ApplyTemplate(name)
+// seriesCached checks if the series with the given hash is cached for any
+// metric type. Must be called with the lock held.
+func (s *Statsd) seriesCached(hash string) bool {
+	for _, mtype := range []string{"g", "c", "s", "ms"} {
+		if s.seriesExists(seriesKey{mtype: mtype, hash: hash}) {
+			return true
+		}
 	}
+	return false
+}
 
-	if s.ConvertNames {
-		name = strings.ReplaceAll(name, ".", "_")
-		name = strings.ReplaceAll(name, "-", "__")
+// seriesKey returns the key identifying the series of the given measurement
+// name and tags, either as string of the sorted tags and name or as xxhash of
+// the same representation
+func (s *Statsd) seriesKey(name string, tags map[string]string) string {
+	if s.SeriesKeyHash != "xxhash" {
+		tg := make([]string, 0, len(tags)+1)
+		for k, v := range tags {
+			tg = append(tg, k+"="+v)
+		}
+		sort.Strings(tg)
+		tg = append(tg, name)
+		return strings.Join(tg, "")
 	}
-	if field == "" {
-		field = defaultFieldName
+
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], seriesDigest(name, tags))
+	return string(key[:])
+}
+
+// seriesDigest returns the xxhash digest of the name and the sorted tags
+func seriesDigest(name string, tags map[string]string) uint64 {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 
-	return name, field, tags
+	var digest xxhash.Digest
+	digest.Reset()
+	for _, k := range keys {
+		digest.WriteString(k)
+		digest.WriteString("=")
+		digest.WriteString(tags[k])
+		digest.WriteString("\x00")
+	}
+	digest.WriteString(name)
+	return digest.Sum64()
 }
 
-// Parse the key,value out of a string that looks like "key=value"
-func parseKeyValue(keyValue string) (key, val string) {
-	split := strings.Split(keyValue, "=")
-	// Must be exactly 2 to get anything meaningful out of them
-	if len(split) == 2 {
-		key = split[0]
-		val = split[1]
-	} else if len(split) == 1 {
-		val = split[0]
-	} else if len(split) > 2 {
-		// fix: https://github.com/influxdata/telegraf/issues/10113
-		// fix: value has "=" parse error
-		// uri=/service/endpoint?sampleParam={paramValue} parse value key="uri", val="/service/endpoint?sampleParam\={paramValue}"
-		key = split[0]
-		val = strings.Join(split[1:], "=")
+// temporality returns the aggregation temporality of the given metric type
+// or an empty string if the type should not be tagged
+func (s *Statsd) temporality(metricType string) string {
+	types := s.AggregationTemporalityTypes
+	if len(types) == 0 {
+		types = []string{"counter"}
+	}
+	if !slices.Contains(types, metricType) {
+		return ""
 	}
 
-	return key, val
+	var deleted bool
+	switch metricType {
+	case "counter":
+		deleted = s.DeleteCounters
+	case "set":
+		deleted = s.DeleteSets
+	case "timing", "histogram":
+		deleted = s.DeleteTimings && !s.CumulativeTimings
+	case "distribution":
+		// Distributions are always published for the current interval only
+		deleted = true
+	}
+	if deleted {
+		return "delta"
+	}
+	return "cumulative"
 }
 
-// aggregate takes in a metric. It then
-// aggregates and caches the current value(s). It does not deal with the
-// Delete* options, because those are dealt with in the Gather function.
-func (s *Statsd) aggregate(m metric) {
-	s.Lock()
-	defer s.Unlock()
+// resetTimings clears the cached timings while keeping the samples within the
+// percentile window if configured
+func (s *Statsd) resetTimings() {
+	if s.PercentileWindow == 0 {
+		s.timings = make(map[string]cachedtimings)
+		return
+	}
 
-	switch m.mtype {
-	case "d":
-		if s.DataDogExtensions && s.DataDogDistributions {
-			cached := cacheddistributions{
-				name:  m.name,
-				value: m.floatvalue,
-				tags:  m.tags,
+	for hash, cached := range s.timings {
+		for name, stats := range cached.fields {
+			if len(stats.samples) == 0 {
+				delete(cached.fields, name)
+				continue
 			}
-			s.distributions = append(s.distributions, cached)
-		}
-	case "ms", "h":
-		// Check if the measurement exists
-		cached, ok := s.timings[m.hash]
-		if !ok {
-			cached = cachedtimings{
-				name:   m.name,
-				fields: make(map[string]runningStats),
-				tags:   m.tags,
+			cached.fields[name] = runningStats{
+				percLimit: stats.percLimit,
+				window:    stats.window,
+				samples:   stats.samples,
+				bounds:    stats.bounds,
 			}
 		}
-		// Check if the field exists. If we've not enabled multiple fields per timer
-		// this will be the default field name, eg. "value"
-		field, ok := cached.fields[m.field]
-		if !ok {
-			field = runningStats{
-				percLimit: s.PercentileLimit,
-			}
+		if len(cached.fields) == 0 {
+			delete(s.timings, hash)
+			continue
 		}
-		if m.samplerate > 0 {
-			for i := 0; i < int(1.0/m.samplerate); i++ {
-				field.addValue(m.floatvalue)
-			}
-		} else {
-			field.addValue(m.floatvalue)
+		cached.exemplars = nil
+		s.timings[hash] = cached
+	}
+}
+
+// resetTimingSamples clears the samples of the percentiles and the median of
+// the cached timings while keeping the aggregates
+func (s *Statsd) resetTimingSamples() {
+	for hash, cached := range s.timings {
+		for name, stats := range cached.fields {
+			stats.resetSamples()
+			cached.fields[name] = stats
 		}
-		cached.fields[m.field] = field
-		cached.expiresAt = time.Now().Add(time.Duration(s.MaxTTL))
-		s.timings[m.hash] = cached
-	case "c":
-		// check if the measurement exists
-		cached, ok := s.counters[m.hash]
-		if !ok {
-			cached = cachedcounter{
-				name:   m.name,
-				fields: make(map[string]interface{}),
-				tags:   m.tags,
+		cached.exemplars = nil
+		s.timings[hash] = cached
+	}
+}
+
+// resetSets clears the cached sets while keeping the members within the set
+// window if configured
+func (s *Statsd) resetSets() {
+	if s.SetWindow == 0 {
+		s.sets = make(map[string]cachedset)
+		return
+	}
+
+	for hash, cached := range s.sets {
+		if len(cached.fields) == 0 {
+			delete(s.sets, hash)
+		}
+	}
+}
+
+// expireSetMembers removes the members of the set not seen within the set
+// window as well as fields without remaining members
+func (s *Statsd) expireSetMembers(cached cachedset, now time.Time) {
+	cutoff := now.Add(-time.Duration(s.SetWindow))
+	for field, members := range cached.seen {
+		for member, seen := range members {
+			if seen.Before(cutoff) {
+				delete(members, member)
+				delete(cached.fields[field], member)
+				delete(cached.scales[field], member)
 			}
 		}
-		// check if the field exists
-		_, ok = cached.fields[m.field]
-		if !ok {
-			cached.fields[m.field] = int64(0)
+		if len(members) == 0 {
+			delete(cached.seen, field)
+			delete(cached.fields, field)
+			delete(cached.scales, field)
 		}
-		cached.fields[m.field] = cached.fields[m.field].(int64) + m.intvalue
-		cached.expiresAt = time.Now().Add(time.Duration(s.MaxTTL))
-		s.counters[m.hash] = cached
-	case "g":
-		// check if the measurement exists
-		cached, ok := s.gauges[m.hash]
-		if !ok {
-			cached = cachedgauge{
-				name:   m.name,
-				fields: make(map[string]interface{}),
-				tags:   m.tags,
-			}
+	}
+}
+
+// emitHistogramBuckets emits the cumulative histogram bucket counts of the
+// given timing or distribution series, one metric per bucket bound tagged with
+// the bound as "le" and a final "+Inf" bucket containing all values
+func (s *Statsd) emitHistogramBuckets(acc telegraf.Accumulator, hash string, m cachedtimings, now time.Time) {
+	if len(s.HistogramBuckets) == 0 {
+		return
+	}
+
+	buckets := make([]map[string]interface{}, len(s.HistogramBuckets)+1)
+	for i := range buckets {
+		buckets[i] = make(map[string]interface{})
+	}
+	for fieldName, stats := range m.fields {
+		// Fields only holding windowed samples have no values in the interval
+		if stats.count() == 0 {
+			continue
 		}
-		// check if the field exists
-		_, ok = cached.fields[m.field]
-		if !ok {
-			cached.fields[m.field] = float64(0)
+		var prefix string
+		if fieldName != s.defaultField() {
+			prefix = fieldName + "_"
 		}
-		if m.additive {
-			cached.fields[m.field] = cached.fields[m.field].(float64) + m.floatvalue
-		} else {
-			cached.fields[m.field] = m.floatvalue
+		for i, count := range stats.cumulativeBuckets() {
+			buckets[i][prefix+"bucket"] = count
 		}
+		buckets[len(s.HistogramBuckets)][prefix+"bucket"] = stats.count()
+	}
 
-		cached.expiresAt = time.Now().Add(time.Duration(s.MaxTTL))
-		s.gauges[m.hash] = cached
-	case "s":
-		// check if the measurement exists
-		cached, ok := s.sets[m.hash]
-		if !ok {
-			cached = cachedset{
-				name:   m.name,
-				fields: make(map[string]map[string]bool),
-				tags:   m.tags,
-			}
+	for i, fields := range buckets {
+		if len(fields) == 0 {
+			continue
 		}
-		// check if the field exists
-		_, ok = cached.fields[m.field]
-		if !ok {
-			cached.fields[m.field] = make(map[string]bool)
+		le := "+Inf"
+		if i < len(s.HistogramBuckets) {
+			le = strconv.FormatFloat(s.HistogramBuckets[i], 'f', -1, 64)
 		}
-		cached.fields[m.field][m.strvalue] = true
-		cached.expiresAt = time.Now().Add(time.Duration(s.MaxTTL))
-		s.sets[m.hash] = cached
+		tags := make(map[string]string, len(m.tags)+1)
+		maps.Copy(tags, m.tags)
+		tags["le"] = le
+		s.emit(acc, hash+",le="+le, m.name, fields, tags, telegraf.Untyped, m.samples, now, m.timestamp)
 	}
 }
 
-// handler handles a single TCP Connection
-func (s *Statsd) handler(conn *net.TCPConn, id string) {
-	s.Stats.CurrentConnections.Incr(1)
-	s.Stats.TotalConnections.Incr(1)
-	// connection cleanup function
-	defer func() {
-		s.wg.Done()
-		conn.Close()
-
-		// Add one connection potential back to channel when this one closes
-		s.accept <- true
-		s.forget(id)
-		s.Stats.CurrentConnections.Incr(-1)
-	}()
+// defaultField returns the name of the field of metrics without a field given
+// by the templates
+func (s *Statsd) defaultField() string {
+	if s.DefaultFieldName != "" {
+		return s.DefaultFieldName
+	}
+	return defaultFieldName
+}
 
-	var remoteIP string
-	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-		remoteIP = addr.IP.String()
+// inPercentileWarmup checks if the timing series with the given hash was first
+// seen within the percentile warm-up
+func (s *Statsd) inPercentileWarmup(hash string, now time.Time) bool {
+	if s.PercentileWarmup == 0 {
+		return false
 	}
+	warmup, ok := s.percentileWarmups[hash]
+	return ok && now.Sub(warmup.firstSeen) < time.Duration(s.PercentileWarmup)
+}
 
-	var n int
-	scanner := bufio.NewScanner(conn)
-	for {
-		select {
-		case <-s.done:
-			return
-		default:
-			if !scanner.Scan() {
-				return
-			}
-			n = len(scanner.Bytes())
-			if n == 0 {
-				continue
-			}
-			s.Stats.TCPBytesRecv.Incr(int64(n))
-			s.Stats.TCPPacketsRecv.Incr(1)
+// percentileField returns the field name for the given percentile
+func (s *Statsd) percentileField(percentile number) string {
+	format := s.PercentileFieldFormat
+	if format == "" {
+		format = defaultPercentileFieldFormat
+	}
+	return fmt.Sprintf(format, percentile)
+}
 
-			b := s.bufPool.Get().(*bytes.Buffer)
-			b.Reset()
-			b.Write(scanner.Bytes())
-			b.WriteByte('\n')
+// validatePercentileFieldFormat checks that the format contains exactly one
+// valid verb for formatting the percentile
+func validatePercentileFieldFormat(format string) error {
+	var verbs int
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		// Skip escaped percent signs
+		if i+1 < len(format) && format[i+1] == '%' {
+			i++
+			continue
+		}
+		verbs++
+	}
+	if verbs != 1 {
+		return fmt.Errorf("expected exactly one verb but found %d", verbs)
+	}
+	if formatted := fmt.Sprintf(format, number(99.9)); strings.Contains(formatted, "%!") {
+		return fmt.Errorf("verb cannot format a percentile: %s", formatted)
+	}
+	return nil
+}
 
-			select {
-			case s.in <- input{Buffer: b, Time: time.Now(), Addr: remoteIP}:
-				s.Stats.PendingMessages.Set(int64(len(s.in)))
-			default:
-				s.drops++
-				if s.drops == 1 || s.drops%s.AllowedPendingMessages == 0 {
-					s.Log.Errorf("Statsd message queue full. "+
-						"We have dropped %d messages so far. "+
-						"You may want to increase allowed_pending_messages in the config", s.drops)
-				}
-			}
+// percentilesFor returns the percentiles to calculate for the timing with the
+// given name and whether those are overridden for the name
+func (s *Statsd) percentilesFor(name string) ([]number, bool) {
+	for _, override := range s.PercentileOverrides {
+		if override.filter != nil && override.filter.Match(name) {
+			return override.Percentiles, true
 		}
 	}
+	return s.Percentiles, false
 }
 
-// refuser refuses a TCP connection
-func (s *Statsd) refuser(conn *net.TCPConn) {
-	conn.Close()
-	s.Log.Infof("Refused TCP Connection from %s", conn.RemoteAddr())
-	s.Log.Warn("Maximum TCP Connections reached, you may want to adjust max_tcp_connections")
+// percentileMinSamples returns the minimum number of samples required to emit
+// the percentile with the given index in adaptive mode. The configured sample
+// counts only apply to the global percentiles.
+func (s *Statsd) percentileMinSamples(percentiles []number, i int, overridden bool) int64 {
+	if len(s.AdaptivePercentileMinSamples) > 0 && !overridden {
+		return int64(s.AdaptivePercentileMinSamples[i])
+	}
+
+	// Resolving the p-th percentile requires at least 100/(100-p) samples,
+	// e.g. 10 for the 90th and 100 for the 99th percentile. Subtract a small
+	// epsilon to avoid rounding up floating point errors.
+	p := float64(percentiles[i])
+	if p >= 100 {
+		return 1
+	}
+	return int64(math.Ceil(100/(100-p) - 1e-9))
 }
 
-// forget a TCP connection
-func (s *Statsd) forget(id string) {
-	s.cleanup.Lock()
-	defer s.cleanup.Unlock()
-	delete(s.conns, id)
+// measurement returns the name of the measurement to emit for the given
+// cached metric name and tags
+func (s *Statsd) measurement(name string, tags map[string]string) string {
+	if !s.MeasurementPerType {
+		return name
+	}
+
+	parts := make([]string, 0, 3)
+	if s.MeasurementTypePrefix != "" {
+		parts = append(parts, s.MeasurementTypePrefix)
+	}
+	parts = append(parts, tags["metric_type"], name)
+	return strings.Join(parts, s.MetricSeparator)
 }
 
-// remember a TCP connection
-func (s *Statsd) remember(id string, conn *net.TCPConn) {
-	s.cleanup.Lock()
-	defer s.cleanup.Unlock()
-	s.conns[id] = conn
+// trackClient records the client address as active in the current interval
+func (s *Statsd) trackClient(addr string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.activeClients) < maxActiveClients {
+		s.activeClients[addr] = struct{}{}
+	}
+}
+
+// roundFloat rounds the value to the precision given as power of ten. Values
+// of at least 2^53 have no fractional digits and are kept as is, as are values
+// overflowing when scaled.
+func roundFloat(f, scale float64) float64 {
+	if math.IsNaN(f) || math.Abs(f) >= 1<<53 {
+		return f
+	}
+	scaled := f * scale
+	if math.IsInf(scaled, 0) {
+		return f
+	}
+	return math.Round(scaled) / scale
 }
 
-// IsUDP returns true if the protocol is UDP, false otherwise.
+// IsUDP returns true if the protocol is datagram based, i.e. UDP or unixgram,
+// false otherwise.
 func (s *Statsd) isUDP() bool {
-	return strings.HasPrefix(s.Protocol, "udp")
+	return strings.HasPrefix(s.Protocol, "udp") || s.Protocol == "unixgram"
+}
+
+// isUnix returns true if the protocol uses a Unix domain socket.
+func (s *Statsd) isUnix() bool {
+	return s.Protocol == "unix" || s.Protocol == "unixgram"
+}
+
+// hasTLSConfig returns true if any of the TLS options is set.
+func (s *Statsd) hasTLSConfig() bool {
+	c := s.ServerConfig
+	return c.TLSCert != "" || c.TLSKey != "" || c.TLSKeyPwd != "" || len(c.TLSAllowedCACerts) > 0 ||
+		len(c.TLSCipherSuites) > 0 || c.TLSMinVersion != "" || c.TLSMaxVersion != "" || len(c.TLSAllowedDNSNames) > 0
 }
 
 func (s *Statsd) expireCachedMetrics() {
@@ -1042,24 +4162,46 @@ func (s *Statsd) expireCachedMetrics() {
 	for key, cached := range s.gauges {
 		if now.After(cached.expiresAt) {
 			delete(s.gauges, key)
+			delete(s.sequences, key)
 		}
 	}
 
 	for key, cached := range s.sets {
 		if now.After(cached.expiresAt) {
 			delete(s.sets, key)
+			delete(s.sequences, key)
 		}
 	}
 
 	for key, cached := range s.timings {
 		if now.After(cached.expiresAt) {
 			delete(s.timings, key)
+			delete(s.sequences, key)
 		}
 	}
 
 	for key, cached := range s.counters {
 		if now.After(cached.expiresAt) {
 			delete(s.counters, key)
+			delete(s.sequences, key)
+		}
+	}
+
+	for key, mirror := range s.mirroredCounters {
+		if now.After(mirror.expiresAt) {
+			delete(s.mirroredCounters, key)
+		}
+	}
+
+	for key, rate := range s.counterRates {
+		if now.After(rate.expiresAt) {
+			delete(s.counterRates, key)
+		}
+	}
+
+	for key, warmup := range s.percentileWarmups {
+		if now.After(warmup.expiresAt) {
+			delete(s.percentileWarmups, key)
 		}
 	}
 }
@@ -1068,7 +4210,7 @@ func init() {
 	inputs.Add("statsd", func() telegraf.Input {
 		return &Statsd{
 			Protocol:               defaultProtocol,
-			ServiceAddress:         ":8125",
+			ServiceAddress:         defaultServiceAddress,
 			MaxTCPConnections:      250,
 			MetricSeparator:        "_",
 			AllowedPendingMessages: defaultAllowPendingMessage,
@@ -1076,6 +4218,7 @@ func init() {
 			DeleteGauges:           true,
 			DeleteSets:             true,
 			DeleteTimings:          true,
+			TrimSeparators:         true,
 			NumberWorkerThreads:    5,
 		}
 	})
@@ -1,16 +1,33 @@
 package statsd
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"math"
 	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/filter"
 	"github.com/influxdata/telegraf/testutil"
 )
 
@@ -19,6 +36,11 @@ const (
 	producerThreads = 10
 )
 
+var pki = testutil.NewPKI("../../../testutil/pki")
+
+// testInstances counts the test instances to register independent statistics
+var testInstances atomic.Int64
+
 func newTestStatsd() *Statsd {
 	s := Statsd{
 		Log:                 testutil.Logger{},
@@ -33,8 +55,18 @@ func newTestStatsd() *Statsd {
 	s.sets = make(map[string]cachedset)
 	s.timings = make(map[string]cachedtimings)
 	s.distributions = make([]cacheddistributions, 0)
+	s.distributionStats = make(map[string]cachedtimings)
+	s.activeClients = make(map[string]struct{})
+	s.sequences = make(map[string]sequence)
+	s.metricTypes = make(map[string]string)
+	s.mirroredCounters = make(map[string]mirroredCounter)
+	s.counterRates = make(map[string]counterRate)
+	s.percentileWarmups = make(map[string]percentileWarmup)
+	s.convertedNames = make(map[string]string)
+	s.nameCollisions = make(map[string]bool)
 
 	s.MetricSeparator = "_"
+	s.registerStats(map[string]string{"address": fmt.Sprintf("test-%d", testInstances.Add(1))})
 
 	return &s
 }
@@ -423,6 +455,39 @@ func TestParse_Gauges(t *testing.T) {
 	}
 }
 
+// Tests interval-scoped additive gauges
+func TestParse_GaugesResetAdditive(t *testing.T) {
+	for _, reset := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reset=%v", reset), func(t *testing.T) {
+			s := newTestStatsd()
+			s.ResetAdditiveGauges = reset
+
+			// First interval
+			for _, line := range []string{"additive:+5|g", "additive:+3|g", "absolute:100|g", "absolute:+5|g"} {
+				require.NoError(t, s.parseStatsdLine(line))
+			}
+			acc := &testutil.Accumulator{}
+			require.NoError(t, s.Gather(acc))
+			acc.AssertContainsFields(t, "additive", map[string]interface{}{"value": float64(8)})
+			acc.AssertContainsFields(t, "absolute", map[string]interface{}{"value": float64(105)})
+
+			// Second interval
+			for _, line := range []string{"additive:+2|g", "absolute:+2|g"} {
+				require.NoError(t, s.parseStatsdLine(line))
+			}
+			acc.ClearMetrics()
+			require.NoError(t, s.Gather(acc))
+			if reset {
+				acc.AssertContainsFields(t, "additive", map[string]interface{}{"value": float64(2)})
+				acc.AssertContainsFields(t, "absolute", map[string]interface{}{"value": float64(102)})
+			} else {
+				acc.AssertContainsFields(t, "additive", map[string]interface{}{"value": float64(10)})
+				acc.AssertContainsFields(t, "absolute", map[string]interface{}{"value": float64(107)})
+			}
+		})
+	}
+}
+
 // Tests low-level functionality of sets
 func TestParse_Sets(t *testing.T) {
 	s := newTestStatsd()
@@ -758,6 +823,68 @@ func TestParse_Timings_TimingsAsFloat(t *testing.T) {
 	acc.AssertContainsFields(t, "test_timing", valid)
 }
 
+// Tests handling of negative timings
+func TestParse_NegativeTimings(t *testing.T) {
+	lines := []string{
+		"test.timing:-5|ms",
+		"test.timing:10|ms",
+		"test.histogram:-1.5|h",
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		s := newTestStatsd()
+
+		for _, line := range lines {
+			_ = s.parseStatsdLine(line)
+		}
+		require.Equal(t, int64(2), s.Stats.NegativeTimingsDropped.Get())
+
+		acc := &testutil.Accumulator{}
+		require.NoError(t, s.Gather(acc))
+		acc.AssertContainsFields(t, "test_timing", map[string]interface{}{
+			"count":  int64(1),
+			"lower":  float64(10),
+			"mean":   float64(10),
+			"median": float64(10),
+			"stddev": float64(0),
+			"sum":    float64(10),
+			"upper":  float64(10),
+		})
+		require.False(t, acc.HasMeasurement("test_histogram"))
+	})
+
+	t.Run("accept", func(t *testing.T) {
+		s := newTestStatsd()
+		s.AllowNegativeTimings = true
+
+		for _, line := range lines {
+			require.NoErrorf(t, s.parseStatsdLine(line), "Parsing line %s should not have resulted in an error", line)
+		}
+		require.Zero(t, s.Stats.NegativeTimingsDropped.Get())
+
+		acc := &testutil.Accumulator{}
+		require.NoError(t, s.Gather(acc))
+		acc.AssertContainsFields(t, "test_timing", map[string]interface{}{
+			"count":  int64(2),
+			"lower":  float64(-5),
+			"mean":   float64(2.5),
+			"median": float64(2.5),
+			"stddev": float64(7.5),
+			"sum":    float64(5),
+			"upper":  float64(10),
+		})
+		acc.AssertContainsFields(t, "test_histogram", map[string]interface{}{
+			"count":  int64(1),
+			"lower":  float64(-1.5),
+			"mean":   float64(-1.5),
+			"median": float64(-1.5),
+			"stddev": float64(0),
+			"sum":    float64(-1.5),
+			"upper":  float64(-1.5),
+		})
+	})
+}
+
 // Tests low-level functionality of distributions
 func TestParse_Distributions(t *testing.T) {
 	s := newTestStatsd()
@@ -812,6 +939,39 @@ func TestParse_Distributions(t *testing.T) {
 	}
 }
 
+// Tests percentile aggregation of distributions
+func TestParse_DistributionPercentiles(t *testing.T) {
+	s := newTestStatsd()
+	s.DataDogExtensions = true
+	s.DataDogDistributions = true
+	s.DistributionPercentiles = []number{50.0, 90.0}
+	acc := &testutil.Accumulator{}
+
+	for i := 1; i <= 10; i++ {
+		line := fmt.Sprintf("test.distribution:%d|d", i)
+		require.NoErrorf(t, s.parseStatsdLine(line), "Parsing line %s should not have resulted in an error", line)
+	}
+	require.NoError(t, s.Gather(acc))
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"test_distribution",
+			map[string]string{"metric_type": "distribution"},
+			map[string]interface{}{
+				"50_percentile": float64(6),
+				"90_percentile": float64(10),
+			},
+			time.Unix(0, 0),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime())
+
+	// Samples must not be carried over to the next interval
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(acc))
+	require.Empty(t, acc.GetTelegrafMetrics())
+}
+
 func TestParseScientificNotation(t *testing.T) {
 	s := newTestStatsd()
 	sciNotationLines := []string{
@@ -825,6 +985,75 @@ func TestParseScientificNotation(t *testing.T) {
 	}
 }
 
+// Tests substitution of default values for empty values
+func TestParse_EmptyValueDefault(t *testing.T) {
+	s := newTestStatsd()
+	for _, line := range []string{"empty.counter:|c", "empty.gauge:|g", "empty.timing:|ms"} {
+		require.ErrorIsf(t, s.parseStatsdLine(line), errParsing, "Parsing line %s should have resulted in an error", line)
+	}
+
+	s.EmptyValueDefault = map[string]string{"c": "1", "g": "5", "ms": "0"}
+	validLines := []string{
+		"empty.counter:|c",
+		"empty.counter:|c",
+		"empty.gauge:|g",
+		"empty.timing:|ms",
+		"empty.timing:10|ms",
+	}
+	for _, line := range validLines {
+		require.NoErrorf(t, s.parseStatsdLine(line), "Parsing line %s should not have resulted in an error", line)
+	}
+	require.ErrorIs(t, s.parseStatsdLine("empty.histogram:|h"), errParsing)
+
+	require.NoError(t, testValidateCounter("empty_counter", 2, s.counters))
+	require.NoError(t, testValidateGauge("empty_gauge", 5, s.gauges))
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, s.Gather(acc))
+	acc.AssertContainsFields(t, "empty_timing", map[string]interface{}{
+		"count":  int64(2),
+		"lower":  float64(0),
+		"mean":   float64(5),
+		"median": float64(5),
+		"stddev": float64(5),
+		"sum":    float64(10),
+		"upper":  float64(10),
+	})
+}
+
+// Tests handling of lines with an empty type
+func TestParse_EmptyType(t *testing.T) {
+	s := newTestStatsd()
+	lines := []string{"trailing.pipe:5|", "empty.type:5||@0.5"}
+	for _, line := range lines {
+		require.ErrorIsf(t, s.parseStatsdLine(line), errParsing, "Parsing line %s should have resulted in an error", line)
+	}
+	require.Equal(t, int64(2), s.Stats.EmptyMetricTypes.Get())
+
+	s.DefaultMetricType = "c"
+	for _, line := range lines {
+		require.NoErrorf(t, s.parseStatsdLine(line), "Parsing line %s should not have resulted in an error", line)
+	}
+	require.Equal(t, int64(2), s.Stats.EmptyMetricTypes.Get())
+	require.NoError(t, testValidateCounter("trailing_pipe", 5, s.counters))
+	require.NoError(t, testValidateCounter("empty_type", 10, s.counters))
+
+	s.DefaultMetricType = "ms"
+	require.NoError(t, s.parseStatsdLine("trailing.timing:5|"))
+	require.Len(t, s.timings, 1)
+}
+
+func TestDefaultMetricTypeInvalid(t *testing.T) {
+	statsd := Statsd{
+		Log:               testutil.Logger{},
+		Protocol:          "udp",
+		ServiceAddress:    "localhost:0",
+		DefaultMetricType: "counter",
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), `invalid default_metric_type "counter"`)
+}
+
 // Invalid lines should return an error
 func TestParse_InvalidLines(t *testing.T) {
 	s := newTestStatsd()
@@ -845,6 +1074,28 @@ func TestParse_InvalidLines(t *testing.T) {
 }
 
 // Invalid sample rates should be ignored and not applied
+func TestParse_ErrorLogRate(t *testing.T) {
+	logger := &testutil.CaptureLogger{}
+	s := newTestStatsd()
+	s.Log = logger
+	s.ParseErrorLogRate = 2
+
+	for i := 0; i < 10; i++ {
+		require.ErrorIs(t, s.parseStatsdLine("invalid.line"), errParsing)
+	}
+	require.Len(t, logger.Errors(), 2)
+	require.Empty(t, logger.Warnings())
+
+	// The summary of suppressed errors is emitted on the next gather
+	require.NoError(t, s.Gather(&testutil.Accumulator{}))
+	warnings := logger.Warnings()
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0], "Suppressed 8 parse errors")
+
+	require.NoError(t, s.Gather(&testutil.Accumulator{}))
+	require.Len(t, logger.Warnings(), 1)
+}
+
 func TestParse_InvalidSampleRate(t *testing.T) {
 	s := newTestStatsd()
 	invalidLines := []string{
@@ -1114,6 +1365,47 @@ func TestParse_TemplateFields(t *testing.T) {
 	}
 }
 
+// Test that counter fields extracted by templates are emitted as fields of a
+// single measurement and can be aggregated across intervals
+func TestParse_TemplateFieldsCounters(t *testing.T) {
+	s := newTestStatsd()
+	s.FloatCounters = true
+	s.EnableAggregationTemporality = true
+	s.Templates = []string{
+		"* measurement.measurement.field",
+	}
+
+	for _, line := range []string{"api.calls.count:1|c", "api.calls.count:2|c", "api.calls.errors:1|c"} {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+	acc := &testutil.Accumulator{}
+	require.NoError(t, s.Gather(acc))
+
+	// Counters are not deleted, so the next interval continues counting
+	for _, line := range []string{"api.calls.count:4|c", "api.calls.errors:1|c"} {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+	require.NoError(t, s.Gather(acc))
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"api_calls",
+			map[string]string{"metric_type": "counter", "temporality": "cumulative"},
+			map[string]interface{}{"count": float64(3), "errors": float64(1)},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"api_calls",
+			map[string]string{"metric_type": "counter", "temporality": "cumulative"},
+			map[string]interface{}{"count": float64(7), "errors": float64(2)},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime(), testutil.IgnoreFields("start_time"))
+}
+
 // Test that fields are parsed correctly
 func TestParse_Fields(t *testing.T) {
 	if false {
@@ -1157,7 +1449,7 @@ func TestParse_Tags(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		name, _, tags := s.parseName(test.bucket)
+		name, _, tags := s.parseName(test.bucket, "")
 		require.Equalf(t, name, test.name, "Expected: %s, got %s", test.name, name)
 
 		for k, v := range test.tags {
@@ -1462,7 +1754,7 @@ func TestParseName(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		name, _, _ := s.parseName(test.inName)
+		name, _, _ := s.parseName(test.inName, "")
 		require.Equalf(t, name, test.outName, "Expected: %s, got %s", test.outName, name)
 	}
 
@@ -1488,11 +1780,38 @@ func TestParseName(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		name, _, _ := s.parseName(test.inName)
+		name, _, _ := s.parseName(test.inName, "")
 		require.Equalf(t, name, test.outName, "Expected: %s, got %s", test.outName, name)
 	}
 }
 
+func TestParseNameCollisions(t *testing.T) {
+	logger := &testutil.CaptureLogger{}
+	s := newTestStatsd()
+	s.Log = logger
+	s.ConvertNames = true
+	s.DetectNameCollisions = true
+	s.MetricSeparator = "."
+
+	lines := []string{
+		"cpu.idle:1|c",
+		"cpu.idle:1|c",
+		"mem-free:1|c",
+		"cpu_idle:1|c",
+		"cpu_idle:1|c",
+		"mem__free:1|c",
+	}
+	for _, line := range lines {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+
+	require.Equal(t, int64(2), s.Stats.NameCollisions.Get())
+	warnings := logger.Warnings()
+	require.Len(t, warnings, 2)
+	require.Contains(t, warnings[0], `Names "cpu.idle" and "cpu_idle" are both converted to "cpu_idle"`)
+	require.Contains(t, warnings[1], `Names "mem-free" and "mem__free" are both converted to "mem__free"`)
+}
+
 // Test that measurements with the same name, but different tags, are treated
 // as different outputs
 func TestParse_MeasurementsWithSameName(t *testing.T) {
@@ -1575,6 +1894,72 @@ func TestCachesExpireAfterMaxTTL(t *testing.T) {
 
 // Test that measurements with multiple bits, are treated as different outputs
 // but are equal to their single-measurement representation
+func TestEmitSequence(t *testing.T) {
+	s := newTestStatsd()
+	s.EmitSequence = true
+	s.DeleteCounters = true
+	s.MaxTTL = config.Duration(10 * time.Millisecond)
+
+	sequences := func() map[string]interface{} {
+		acc := &testutil.Accumulator{}
+		require.NoError(t, s.Gather(acc))
+		seqs := make(map[string]interface{})
+		for _, m := range acc.GetTelegrafMetrics() {
+			seqs[m.Name()], _ = m.GetField("sequence")
+		}
+		return seqs
+	}
+
+	// The sequence increases with each emission even if the counter is
+	// deleted in between
+	require.NoError(t, s.parseStatsdLine("cpu.count:1|c"))
+	require.NoError(t, s.parseStatsdLine("cpu.gauge:1|g"))
+	require.Equal(t, map[string]interface{}{"cpu_count": int64(1), "cpu_gauge": int64(1)}, sequences())
+	require.NoError(t, s.parseStatsdLine("cpu.count:1|c"))
+	require.Equal(t, map[string]interface{}{"cpu_count": int64(2), "cpu_gauge": int64(2)}, sequences())
+
+	// After the series expired, the sequence starts again
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, map[string]interface{}{"cpu_gauge": int64(3)}, sequences())
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, s.parseStatsdLine("cpu.count:1|c"))
+	require.NoError(t, s.parseStatsdLine("cpu.gauge:1|g"))
+	require.Equal(t, map[string]interface{}{"cpu_count": int64(1), "cpu_gauge": int64(1)}, sequences())
+}
+
+func TestEmitSequenceWithoutMaxTTL(t *testing.T) {
+	s := newTestStatsd()
+	s.EmitSequence = true
+	s.DeleteCounters = true
+	s.DeleteGauges = true
+	s.DeleteSets = true
+	s.DeleteTimings = true
+
+	sequences := func() map[string]interface{} {
+		acc := &testutil.Accumulator{}
+		require.NoError(t, s.Gather(acc))
+		seqs := make(map[string]interface{})
+		for _, m := range acc.GetTelegrafMetrics() {
+			seqs[m.Name()], _ = m.GetField("sequence")
+		}
+		return seqs
+	}
+
+	require.NoError(t, s.parseStatsdLine("cpu.count:1|c"))
+	require.NoError(t, s.parseStatsdLine("cpu.time:1|ms"))
+	require.Equal(t, map[string]interface{}{"cpu_count": int64(1), "cpu_time": int64(1)}, sequences())
+	require.NoError(t, s.parseStatsdLine("cpu.count:1|c"))
+	require.NoError(t, s.parseStatsdLine("cpu.time:1|ms"))
+	require.Equal(t, map[string]interface{}{"cpu_count": int64(2), "cpu_time": int64(2)}, sequences())
+
+	// The sequences of the deleted series are dropped once not emitted
+	require.Empty(t, sequences())
+	require.Empty(t, s.sequences)
+
+	require.NoError(t, s.parseStatsdLine("cpu.count:1|c"))
+	require.Equal(t, map[string]interface{}{"cpu_count": int64(1)}, sequences())
+}
+
 func TestParse_MeasurementsWithMultipleValues(t *testing.T) {
 	singleLines := []string{
 		"valid.multiple:0|ms|@0.1",
@@ -1894,6 +2279,37 @@ func BenchmarkParseWith2Templates3TagsAndFilter(b *testing.B) {
 	}
 }
 
+func BenchmarkParseWithTemplateParallel(b *testing.B) {
+	s := newTestStatsd()
+	s.Templates = []string{"measurement.measurement.field"}
+	lines := []string{
+		"test.timing.success:1|ms",
+		"test.timing.error:2|ms",
+		"test.requests.success:1|c",
+		"test.requests.error:1|c",
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			line := lines[i%len(lines)]
+			if err := s.parseStatsdLine(line); err != nil {
+				b.Errorf("Parsing line %s should not have resulted in an error", line)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkParseNameParallel(b *testing.B) {
+	s := newTestStatsd()
+	s.Templates = []string{"measurement.measurement.field"}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.parseName("test.timing.success,host=a", "ms")
+		}
+	})
+}
+
 func TestParse_Timings_Delete(t *testing.T) {
 	s := newTestStatsd()
 	s.DeleteTimings = true
@@ -2155,6 +2571,73 @@ func TestUdp(t *testing.T) {
 	)
 }
 
+func TestUdpMaxPacketSize(t *testing.T) {
+	statsd := Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "udp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 10,
+		NumberWorkerThreads:    1,
+		UDPMaxPacketSize:       1024,
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, statsd.Start(&acc))
+	defer statsd.Stop()
+
+	conn, err := net.Dial("udp", statsd.UDPlistener.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Fill the packet up to the limit using a long bucket name
+	prefix := strings.Repeat("x", 1024-len("cpu.time_idle:42|c"))
+	packet := prefix + "cpu.time_idle:42|c"
+	require.Len(t, packet, 1024)
+	_, err = conn.Write([]byte(packet))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		require.NoError(t, statsd.Gather(&acc))
+		return acc.NMetrics() > 0
+	}, time.Second, 10*time.Millisecond)
+	require.True(t, acc.HasMeasurement(prefix+"cpu_time_idle"))
+}
+
+func TestUdpMaxPacketSizeInvalid(t *testing.T) {
+	statsd := Statsd{
+		Log:              testutil.Logger{},
+		Protocol:         "udp",
+		ServiceAddress:   "localhost:0",
+		UDPMaxPacketSize: 65508,
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), "invalid udp_max_packet_size")
+}
+
+func TestTLSNonTCPProtocolInvalid(t *testing.T) {
+	for _, protocol := range []string{"udp", "udp4", "unixgram", "unix"} {
+		t.Run(protocol, func(t *testing.T) {
+			plugin := &Statsd{
+				Log:            testutil.Logger{},
+				Protocol:       protocol,
+				ServiceAddress: "localhost:0",
+			}
+			plugin.TLSCert = "/etc/telegraf/cert.pem"
+			plugin.TLSKey = "/etc/telegraf/key.pem"
+			var acc testutil.Accumulator
+			require.ErrorContains(t, plugin.Start(&acc), "invalid TLS configuration")
+		})
+	}
+
+	plugin := &Statsd{
+		Log:            testutil.Logger{},
+		Protocol:       "udp",
+		ServiceAddress: "localhost:0",
+	}
+	plugin.TLSAllowedCACerts = []string{"/etc/telegraf/clientca.pem"}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, plugin.Start(&acc), "invalid TLS configuration")
+}
+
 func TestUdpFillQueue(t *testing.T) {
 	logger := testutil.CaptureLogger{}
 	plugin := &Statsd{
@@ -2185,6 +2668,122 @@ func TestUdpFillQueue(t *testing.T) {
 	require.Emptyf(t, errs, "got errors: %v", errs)
 }
 
+func TestActiveClients(t *testing.T) {
+	plugin := &Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "udp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 10,
+		NumberWorkerThreads:    1,
+		TrackActiveClients:     true,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Start(&acc))
+	defer plugin.Stop()
+
+	// Invalid lines must not mark a client as active, the single worker
+	// guarantees the invalid line is processed first
+	plugin.in <- input{Buffer: bytes.NewBufferString("cpu.time_idle:42\n"), Time: time.Now(), Addr: "192.168.0.3"}
+	for _, addr := range []string{"192.168.0.1", "192.168.0.2", "192.168.0.1"} {
+		plugin.in <- input{Buffer: bytes.NewBufferString("cpu.time_idle:42|c\n"), Time: time.Now(), Addr: addr}
+	}
+
+	require.Eventually(t, func() bool {
+		plugin.Lock()
+		defer plugin.Unlock()
+		return len(plugin.activeClients) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, plugin.Gather(&acc))
+	active, found := acc.Int64Field("statsd", "clients_active")
+	require.True(t, found)
+	require.Equal(t, int64(2), active)
+
+	// The clients are tracked per interval
+	acc.ClearMetrics()
+	require.NoError(t, plugin.Gather(&acc))
+	active, found = acc.Int64Field("statsd", "clients_active")
+	require.True(t, found)
+	require.Zero(t, active)
+}
+
+func TestMeasurementPerType(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		expected []string
+	}{
+		{
+			name:     "without prefix",
+			expected: []string{"counter_foo", "gauge_foo", "set_foo", "timing_foo"},
+		},
+		{
+			name:     "with prefix",
+			prefix:   "statsd",
+			expected: []string{"statsd_counter_foo", "statsd_gauge_foo", "statsd_set_foo", "statsd_timing_foo"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.MeasurementPerType = true
+			s.MeasurementTypePrefix = tt.prefix
+
+			for _, line := range []string{"foo:1|c", "foo:2|g", "foo:3|s", "foo:4|ms"} {
+				require.NoError(t, s.parseStatsdLine(line))
+			}
+
+			acc := &testutil.Accumulator{}
+			require.NoError(t, s.Gather(acc))
+
+			names := make([]string, 0, len(tt.expected))
+			for _, m := range acc.GetTelegrafMetrics() {
+				names = append(names, m.Name())
+				require.True(t, m.HasTag("metric_type"))
+			}
+			require.ElementsMatch(t, tt.expected, names)
+		})
+	}
+}
+
+func TestParserUtilization(t *testing.T) {
+	plugin := &Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "udp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 1000,
+		NumberWorkerThreads:    1,
+		Percentiles:            []number{50, 90, 99},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Start(&acc))
+	defer plugin.Stop()
+
+	// Low load baseline
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, plugin.Gather(&acc))
+	baseline := plugin.Stats.ParserUtilization.Get()
+
+	// Keep the worker busy with a large number of timings
+	var payload bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&payload, "test.timing,host=h%d:%d|ms\n", i%10, i)
+	}
+	for i := 0; i < 100; i++ {
+		plugin.in <- input{Buffer: bytes.NewBuffer(payload.Bytes()), Time: time.Now()}
+	}
+	require.Eventually(t, func() bool {
+		return len(plugin.in) == 0
+	}, 10*time.Second, time.Millisecond)
+	require.NoError(t, plugin.Gather(&acc))
+
+	require.Greater(t, plugin.Stats.ParserUtilization.Get(), baseline)
+	require.Positive(t, plugin.workers[0].busy.Get())
+}
+
 func TestParse_Ints(t *testing.T) {
 	s := newTestStatsd()
 	s.Percentiles = []number{90}
@@ -2248,7 +2847,7 @@ func TestParseSanitize(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		name, _, _ := s.parseName(test.inName)
+		name, _, _ := s.parseName(test.inName, "")
 		require.Equalf(t, name, test.outName, "Expected: %s, got %s", test.outName, name)
 	}
 }
@@ -2280,24 +2879,148 @@ func TestParseNoSanitize(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		name, _, _ := s.parseName(test.inName)
+		name, _, _ := s.parseName(test.inName, "")
 		require.Equalf(t, name, test.outName, "Expected: %s, got %s", test.outName, name)
 	}
 }
 
-func TestParse_InvalidAndRecoverIntegration(t *testing.T) {
-	statsd := Statsd{
-		Log:                    testutil.Logger{},
-		Protocol:               "tcp",
-		ServiceAddress:         "localhost:8125",
-		AllowedPendingMessages: 10000,
-		MaxTCPConnections:      250,
-		TCPKeepAlive:           true,
-		NumberWorkerThreads:    5,
+func TestParseSanitizeTagKeys(t *testing.T) {
+	s := newTestStatsd()
+	s.DataDogExtensions = true
+	s.SanitizeTagKeysMethod = "upstream"
+
+	require.NoError(t, s.parseStatsdLine("regex./dev/null:1|c|#my key:a,path/to:b,wow!!!:c"))
+	require.Len(t, s.counters, 1)
+	for _, m := range s.counters {
+		// Name sanitization is not affected by the tag key setting
+		require.Equal(t, "regex_/dev/null", m.name)
+		require.Equal(t, map[string]string{
+			"metric_type": "counter",
+			"my_key":      "a",
+			"path-to":     "b",
+			"wow":         "c",
+		}, m.tags)
 	}
+}
 
-	acc := &testutil.Accumulator{}
-	require.NoError(t, statsd.Start(acc))
+func TestParseSanitizeTagKeysWithNames(t *testing.T) {
+	s := newTestStatsd()
+	s.SanitizeNamesMethod = "upstream"
+	s.SanitizeTagKeysMethod = ""
+
+	require.NoError(t, s.parseStatsdLine("regex./dev/null,my key=a,path/to=b:1|c"))
+	require.Len(t, s.counters, 1)
+	for _, m := range s.counters {
+		require.Equal(t, "regex_-dev-null", m.name)
+		require.Equal(t, map[string]string{
+			"metric_type": "counter",
+			"my key":      "a",
+			"path/to":     "b",
+		}, m.tags)
+	}
+}
+
+func TestParseSanitizePerType(t *testing.T) {
+	tests := []struct {
+		name     string
+		global   string
+		perType  map[string]string
+		expected map[string]string
+	}{
+		{
+			name:    "override unsanitized",
+			perType: map[string]string{"gauge": "upstream"},
+			expected: map[string]string{
+				"g":  "regex_-dev-null",
+				"ms": "regex_/dev/null",
+				"c":  "regex_/dev/null",
+			},
+		},
+		{
+			name:    "override sanitized",
+			global:  "upstream",
+			perType: map[string]string{"timing": ""},
+			expected: map[string]string{
+				"g":  "regex_-dev-null",
+				"ms": "regex_/dev/null",
+				"c":  "regex_-dev-null",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.SanitizeNamesMethod = tt.global
+			s.SanitizeNamesMethodPerType = tt.perType
+
+			for mtype, expected := range tt.expected {
+				name, _, _ := s.parseName("regex./dev/null", mtype)
+				require.Equal(t, expected, name, mtype)
+			}
+		})
+	}
+}
+
+func TestParseSanitizePerTypeLines(t *testing.T) {
+	s := newTestStatsd()
+	s.SanitizeNamesMethodPerType = map[string]string{"gauge": "upstream"}
+
+	require.NoError(t, s.parseStatsdLine("regex./dev/null:1|g"))
+	require.NoError(t, s.parseStatsdLine("regex./dev/null:1|ms"))
+	require.Len(t, s.gauges, 1)
+	for _, m := range s.gauges {
+		require.Equal(t, "regex_-dev-null", m.name)
+	}
+	require.Len(t, s.timings, 1)
+	for _, m := range s.timings {
+		require.Equal(t, "regex_/dev/null", m.name)
+	}
+}
+
+func TestParseSanitizePerTypeInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		perType  map[string]string
+		expected string
+	}{
+		{
+			name:     "unknown type",
+			perType:  map[string]string{"meter": "upstream"},
+			expected: `invalid sanitize_name_method_per_type type "meter"`,
+		},
+		{
+			name:     "unknown method",
+			perType:  map[string]string{"gauge": "strict"},
+			expected: `invalid sanitize_name_method_per_type method "strict"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statsd := Statsd{
+				Log:                        testutil.Logger{},
+				Protocol:                   "udp",
+				ServiceAddress:             "localhost:0",
+				SanitizeNamesMethodPerType: tt.perType,
+			}
+			var acc testutil.Accumulator
+			require.ErrorContains(t, statsd.Start(&acc), tt.expected)
+		})
+	}
+}
+
+func TestParse_InvalidAndRecoverIntegration(t *testing.T) {
+	statsd := Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "tcp",
+		ServiceAddress:         "localhost:8125",
+		AllowedPendingMessages: 10000,
+		MaxTCPConnections:      250,
+		TCPKeepAlive:           true,
+		NumberWorkerThreads:    5,
+	}
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, statsd.Start(acc))
 	defer statsd.Stop()
 
 	addr := statsd.TCPlistener.Addr().String()
@@ -2397,3 +3120,4105 @@ func TestParse_DeltaCounter(t *testing.T) {
 
 	require.NoError(t, conn.Close())
 }
+
+func TestSnapshot(t *testing.T) {
+	s := newTestStatsd()
+
+	lines := []string{
+		"cpu.load:1|g",
+		"requests:1|c",
+		"requests:2|c",
+		"users:alice|s",
+		"latency:-1|ms",
+		"latency:3|ms",
+	}
+	for _, line := range lines {
+		_ = s.parseStatsdLine(line)
+	}
+	s.drops.Add(3)
+
+	snapshot := s.Snapshot()
+	require.Equal(t, 1, snapshot.Gauges)
+	require.Equal(t, 1, snapshot.Counters)
+	require.Equal(t, 1, snapshot.Sets)
+	require.Equal(t, 1, snapshot.Timings)
+	require.Equal(t, 0, snapshot.Distributions)
+	require.Equal(t, int64(3), snapshot.MessagesDropped)
+	require.Equal(t, int64(1), snapshot.Stats["negative_timings_dropped"])
+	require.Equal(t, int64(1), snapshot.Dropped["negative_timing"])
+	require.Contains(t, snapshot.Stats, "udp_packets_received")
+
+	// The snapshot is a copy and must not change with further activity
+	require.NoError(t, s.parseStatsdLine("requests2:1|c"))
+	require.Equal(t, 1, snapshot.Counters)
+	require.Equal(t, 2, s.Snapshot().Counters)
+}
+
+func TestSnapshotConcurrent(t *testing.T) {
+	plugin := &Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "udp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 10,
+		NumberWorkerThreads:    1,
+	}
+
+	// Taking snapshots while the plugin starts and processes data must not
+	// race with the plugin
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			plugin.Snapshot()
+		}
+	}()
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Start(&acc))
+	defer plugin.Stop()
+	for range 10 {
+		require.NoError(t, plugin.parseStatsdLine("requests:1|c"))
+		plugin.Snapshot()
+	}
+	wg.Wait()
+	require.Equal(t, 1, plugin.Snapshot().Counters)
+}
+
+func TestParse_TemplateSeparator(t *testing.T) {
+	s := newTestStatsd()
+	s.Templates = []string{"measurement.measurement.region"}
+	s.MetricSeparator = "_"
+	s.TemplateSeparator = "."
+	s.MeasurementPerType = true
+
+	name, _, tags := s.parseName("cpu.load.us-west", "")
+	require.Equal(t, "cpu.load", name)
+	require.Equal(t, map[string]string{"region": "us-west"}, tags)
+
+	require.NoError(t, s.parseStatsdLine("cpu.load.us-west:100|g"))
+	acc := &testutil.Accumulator{}
+	require.NoError(t, s.Gather(acc))
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+	require.Equal(t, "gauge_cpu.load", acc.GetTelegrafMetrics()[0].Name())
+
+	// Without template separator the metric separator is used for both
+	s.TemplateSeparator = ""
+	name, _, _ = s.parseName("cpu.load.us-west", "")
+	require.Equal(t, "cpu_load", name)
+}
+
+func TestParseConcurrentWorkers(t *testing.T) {
+	// The default "measurement*" template updates its state when applied
+	s := newTestStatsd()
+	s.ConvertNames = true
+	s.DetectNameCollisions = true
+
+	// Names are parsed concurrently by the workers and must not race on any
+	// shared state, run with the race detector to verify
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				line := fmt.Sprintf("test.timing.worker-%d,index=%d:1|ms", worker, i%10)
+				assert.NoError(t, s.parseStatsdLine(line))
+			}
+		}()
+	}
+	wg.Wait()
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, s.Gather(acc))
+	metrics := acc.GetTelegrafMetrics()
+	require.Len(t, metrics, 80)
+	for worker := range 8 {
+		for i := range 10 {
+			acc.AssertContainsTaggedFields(t, fmt.Sprintf("test_timing_worker__%d", worker),
+				map[string]interface{}{
+					"count":  int64(10),
+					"lower":  1.0,
+					"mean":   1.0,
+					"median": 1.0,
+					"stddev": 0.0,
+					"sum":    10.0,
+					"upper":  1.0,
+				},
+				map[string]string{"metric_type": "timing", "index": fmt.Sprint(i)},
+			)
+		}
+	}
+}
+
+func TestParse_SignalFxDimensions(t *testing.T) {
+	tests := []struct {
+		name      string
+		bucket    string
+		disabled  bool
+		templates []string
+		expected  string
+		tags      map[string]string
+	}{
+		{
+			name:     "suffix",
+			bucket:   "api.latency[host=a,region=eu]",
+			expected: "api_latency",
+			tags:     map[string]string{"host": "a", "region": "eu"},
+		},
+		{
+			name:     "prefix",
+			bucket:   "[host=a]api.latency",
+			expected: "api_latency",
+			tags:     map[string]string{"host": "a"},
+		},
+		{
+			name:     "within name",
+			bucket:   "api[host=a].latency",
+			expected: "api_latency",
+			tags:     map[string]string{"host": "a"},
+		},
+		{
+			name:     "value with dots",
+			bucket:   "api.latency[host=web.example.com]",
+			expected: "api_latency",
+			tags:     map[string]string{"host": "web.example.com"},
+		},
+		{
+			name:     "with comma tags",
+			bucket:   "api.latency[host=a],region=eu",
+			expected: "api_latency",
+			tags:     map[string]string{"host": "a", "region": "eu"},
+		},
+		{
+			name:     "dimension overrides comma tag",
+			bucket:   "api.latency[host=a],host=b",
+			expected: "api_latency",
+			tags:     map[string]string{"host": "a"},
+		},
+		{
+			name:     "empty brackets",
+			bucket:   "api.latency[]",
+			expected: "api_latency",
+			tags:     map[string]string{},
+		},
+		{
+			name:     "empty dimensions",
+			bucket:   "api.latency[,=x,host=a,]",
+			expected: "api_latency",
+			tags:     map[string]string{"host": "a"},
+		},
+		{
+			name:     "nested brackets",
+			bucket:   "api.latency[hosts=[a,b],region=eu]",
+			expected: "api_latency",
+			tags:     map[string]string{"hosts": "[a,b]", "region": "eu"},
+		},
+		{
+			name:     "multiple groups",
+			bucket:   "[region=eu]api.latency[host=a]",
+			expected: "api_latency",
+			tags:     map[string]string{"host": "a", "region": "eu"},
+		},
+		{
+			name:     "unbalanced brackets",
+			bucket:   "api.latency[host=a",
+			expected: "api_latency[host=a",
+			tags:     map[string]string{},
+		},
+		{
+			name:      "template",
+			bucket:    "eu.api.latency[host=web.example.com]",
+			templates: []string{"region.measurement*"},
+			expected:  "api_latency",
+			tags:      map[string]string{"host": "web.example.com", "region": "eu"},
+		},
+		{
+			name:     "disabled",
+			bucket:   "api.latency[host=a]",
+			disabled: true,
+			expected: "api_latency[host=a]",
+			tags:     map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.SignalFxDimensions = !tt.disabled
+			s.Templates = tt.templates
+
+			name, _, tags := s.parseName(tt.bucket, "ms")
+			require.Equal(t, tt.expected, name)
+			require.Equal(t, tt.tags, tags)
+		})
+	}
+
+	// The dimensions are part of the series
+	s := newTestStatsd()
+	s.SignalFxDimensions = true
+	require.NoError(t, s.parseStatsdLine("requests[host=a]:1|c"))
+	require.NoError(t, s.parseStatsdLine("requests[host=b]:2|c"))
+	acc := &testutil.Accumulator{}
+	require.NoError(t, s.Gather(acc))
+	acc.AssertContainsTaggedFields(t, "requests",
+		map[string]interface{}{"value": int64(1)},
+		map[string]string{"metric_type": "counter", "host": "a"},
+	)
+	acc.AssertContainsTaggedFields(t, "requests",
+		map[string]interface{}{"value": int64(2)},
+		map[string]string{"metric_type": "counter", "host": "b"},
+	)
+}
+
+func TestParse_FirstSegmentAsTag(t *testing.T) {
+	tests := []struct {
+		name      string
+		bucket    string
+		templates []string
+		expected  string
+		tags      map[string]string
+	}{
+		{
+			name:     "multi segment",
+			bucket:   "myapp.http.requests",
+			expected: "http_requests",
+			tags:     map[string]string{"namespace": "myapp"},
+		},
+		{
+			name:     "single segment",
+			bucket:   "requests",
+			expected: "requests",
+			tags:     map[string]string{},
+		},
+		{
+			name:     "with tags",
+			bucket:   "myapp.requests,host=localhost",
+			expected: "requests",
+			tags:     map[string]string{"namespace": "myapp", "host": "localhost"},
+		},
+		{
+			name:      "with template",
+			bucket:    "myapp.cpu.us-west",
+			templates: []string{"measurement.region"},
+			expected:  "cpu",
+			tags:      map[string]string{"namespace": "myapp", "region": "us-west"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.FirstSegmentAsTag = "namespace"
+			s.Templates = tt.templates
+
+			name, _, tags := s.parseName(tt.bucket, "")
+			require.Equal(t, tt.expected, name)
+			require.Equal(t, tt.tags, tags)
+		})
+	}
+}
+
+func TestParse_AdaptivePercentiles(t *testing.T) {
+	tests := []struct {
+		name       string
+		samples    int
+		minSamples []int
+		expected   []string
+	}{
+		{
+			name:     "sparse",
+			samples:  3,
+			expected: []string{"50_percentile", "100_percentile"},
+		},
+		{
+			name:     "medium",
+			samples:  20,
+			expected: []string{"50_percentile", "90_percentile", "100_percentile"},
+		},
+		{
+			name:     "dense",
+			samples:  200,
+			expected: []string{"50_percentile", "90_percentile", "99_percentile", "100_percentile"},
+		},
+		{
+			name:       "custom thresholds",
+			samples:    20,
+			minSamples: []int{1, 50, 50, 50},
+			expected:   []string{"50_percentile"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.Percentiles = []number{50, 90, 99, 100}
+			s.AdaptivePercentiles = true
+			s.AdaptivePercentileMinSamples = tt.minSamples
+
+			for i := range tt.samples {
+				require.NoError(t, s.parseStatsdLine(fmt.Sprintf("latency:%d|ms", i)))
+			}
+
+			acc := &testutil.Accumulator{}
+			require.NoError(t, s.Gather(acc))
+			require.Len(t, acc.Metrics, 1)
+
+			var percentiles []string
+			for field := range acc.Metrics[0].Fields {
+				if strings.HasSuffix(field, "_percentile") {
+					percentiles = append(percentiles, field)
+				}
+			}
+			require.ElementsMatch(t, tt.expected, percentiles)
+		})
+	}
+}
+
+func TestAdaptivePercentilesInvalid(t *testing.T) {
+	statsd := Statsd{
+		Log:                          testutil.Logger{},
+		Protocol:                     "udp",
+		ServiceAddress:               "localhost:0",
+		Percentiles:                  []number{50, 90},
+		AdaptivePercentileMinSamples: []int{2},
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), "adaptive_percentile_min_samples has 1 entries but 2 percentiles")
+}
+
+func TestPercentileOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides []percentileOverride
+		api       []string
+		db        []string
+	}{
+		{
+			name: "no override",
+			api:  []string{"90_percentile"},
+			db:   []string{"90_percentile"},
+		},
+		{
+			name: "exact name",
+			overrides: []percentileOverride{
+				{Names: []string{"api_latency"}, Percentiles: []number{90, 99.9}},
+			},
+			api: []string{"90_percentile", "99.9_percentile"},
+			db:  []string{"90_percentile"},
+		},
+		{
+			name: "glob",
+			overrides: []percentileOverride{
+				{Names: []string{"db_*"}, Percentiles: []number{50}},
+			},
+			api: []string{"90_percentile"},
+			db:  []string{"50_percentile"},
+		},
+		{
+			name: "first match wins",
+			overrides: []percentileOverride{
+				{Names: []string{"api_*"}, Percentiles: []number{99}},
+				{Names: []string{"*_latency"}, Percentiles: []number{75}},
+			},
+			api: []string{"99_percentile"},
+			db:  []string{"75_percentile"},
+		},
+		{
+			name: "no percentiles",
+			overrides: []percentileOverride{
+				{Names: []string{"db_latency", "cache_*"}},
+			},
+			api: []string{"90_percentile"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := &Statsd{
+				Log:                 testutil.Logger{},
+				Protocol:            "udp",
+				ServiceAddress:      "localhost:0",
+				Percentiles:         []number{90},
+				PercentileOverrides: tt.overrides,
+			}
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Start(&acc))
+			defer plugin.Stop()
+
+			for _, line := range []string{"api_latency:10|ms", "api_latency:20|ms", "db_latency:5|ms"} {
+				require.NoError(t, plugin.parseStatsdLine(line))
+			}
+			require.NoError(t, plugin.Gather(&acc))
+
+			for measurement, expected := range map[string][]string{"api_latency": tt.api, "db_latency": tt.db} {
+				m, found := acc.Get(measurement)
+				require.True(t, found)
+				var percentiles []string
+				for field := range m.Fields {
+					if strings.HasSuffix(field, "_percentile") {
+						percentiles = append(percentiles, field)
+					}
+				}
+				require.ElementsMatch(t, expected, percentiles, measurement)
+			}
+		})
+	}
+}
+
+func TestPercentileOverridesInvalid(t *testing.T) {
+	plugin := &Statsd{
+		Log:                 testutil.Logger{},
+		Protocol:            "udp",
+		ServiceAddress:      "localhost:0",
+		PercentileOverrides: []percentileOverride{{Percentiles: []number{99}}},
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, plugin.Start(&acc), "percentile_overrides entry 1 requires names")
+}
+
+func TestTimingCountExceedsPercentileLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		window config.Duration
+		method string
+	}{
+		{name: "exact"},
+		{name: "window", window: config.Duration(time.Minute)},
+		{name: "tdigest", method: "tdigest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.PercentileLimit = 10
+			s.PercentileWindow = tt.window
+			s.PercentileMethod = tt.method
+			s.Percentiles = []number{90}
+
+			// Sampled lines count with the inverse of their sample rate
+			for i := range 90 {
+				require.NoError(t, s.parseStatsdLine(fmt.Sprintf("latency:%d|ms", i)))
+			}
+			for range 5 {
+				require.NoError(t, s.parseStatsdLine("latency:100|ms|@0.5"))
+			}
+
+			acc := &testutil.Accumulator{}
+			require.NoError(t, s.Gather(acc))
+			m, found := acc.Get("latency")
+			require.True(t, found)
+			require.Equal(t, int64(100), m.Fields["count"])
+			require.InDelta(t, 5005.0, m.Fields["sum"], 1e-9)
+			require.InDelta(t, 100.0, m.Fields["upper"], 1e-9)
+			require.InDelta(t, 0.0, m.Fields["lower"], 1e-9)
+		})
+	}
+}
+
+func TestPercentileFieldFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		expected []string
+	}{
+		{
+			name:     "default",
+			expected: []string{"90_percentile", "99.9_percentile", "success_90_percentile", "success_99.9_percentile"},
+		},
+		{
+			name:     "prefix",
+			format:   "p%v",
+			expected: []string{"p90", "p99.9", "success_p90", "success_p99.9"},
+		},
+		{
+			name:     "underscore",
+			format:   "percentile_%v",
+			expected: []string{"percentile_90", "percentile_99.9", "success_percentile_90", "success_percentile_99.9"},
+		},
+		{
+			name:     "escaped percent sign",
+			format:   "%g%%",
+			expected: []string{"90%", "99.9%", "success_90%", "success_99.9%"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.Templates = []string{"measurement.field"}
+			s.Percentiles = []number{90, 99.9}
+			s.PercentileFieldFormat = tt.format
+			for _, line := range []string{"latency:10|ms", "latency.success:10|ms"} {
+				require.NoError(t, s.parseStatsdLine(line))
+			}
+
+			acc := &testutil.Accumulator{}
+			require.NoError(t, s.Gather(acc))
+			m, found := acc.Get("latency")
+			require.True(t, found)
+			for _, field := range tt.expected {
+				require.Contains(t, m.Fields, field)
+			}
+			if tt.format != "" {
+				require.NotContains(t, m.Fields, "90_percentile")
+			}
+		})
+	}
+}
+
+func TestPercentileFieldFormatInvalid(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{format: "p90", expected: "expected exactly one verb but found 0"},
+		{format: "p%v_%v", expected: "expected exactly one verb but found 2"},
+		{format: "p%d", expected: "verb cannot format a percentile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			plugin := &Statsd{
+				Log:                   testutil.Logger{},
+				Protocol:              "udp",
+				ServiceAddress:        "localhost:0",
+				PercentileFieldFormat: tt.format,
+			}
+			var acc testutil.Accumulator
+			require.ErrorContains(t, plugin.Start(&acc), tt.expected)
+		})
+	}
+}
+
+func TestParse_StrictSignSemantics(t *testing.T) {
+	tests := []struct {
+		name     string
+		strict   bool
+		line     string
+		expected float64
+		invalid  bool
+	}{
+		{name: "absolute zero", line: "gauge:0|g", expected: 0},
+		{name: "plus zero", line: "gauge:+0|g", expected: 10},
+		{name: "minus zero", line: "gauge:-0|g", expected: 10},
+		{name: "strict absolute zero", strict: true, line: "gauge:0|g", expected: 0},
+		{name: "strict plus zero", strict: true, line: "gauge:+0|g", invalid: true},
+		{name: "strict minus zero", strict: true, line: "gauge:-0.0|g", invalid: true},
+		{name: "strict additive", strict: true, line: "gauge:+1|g", expected: 11},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.StrictSignSemantics = tt.strict
+
+			require.NoError(t, s.parseStatsdLine("gauge:10|g"))
+			err := s.parseStatsdLine(tt.line)
+			if tt.invalid {
+				require.ErrorIs(t, err, errParsing)
+				tt.expected = 10
+			} else {
+				require.NoError(t, err)
+			}
+			require.NoError(t, testValidateGauge("gauge", tt.expected, s.gauges))
+		})
+	}
+}
+
+func TestMirrorTemporality(t *testing.T) {
+	for _, deleteCounters := range []bool{true, false} {
+		t.Run(fmt.Sprintf("delete_counters=%v", deleteCounters), func(t *testing.T) {
+			s := newTestStatsd()
+			s.MirrorTemporality = true
+			s.DeleteCounters = deleteCounters
+
+			var acc testutil.Accumulator
+			for _, value := range []int{3, 4} {
+				require.NoError(t, s.parseStatsdLine(fmt.Sprintf("requests:%d|c", value)))
+				require.NoError(t, s.Gather(&acc))
+			}
+
+			expected := []telegraf.Metric{
+				testutil.MustMetric("requests",
+					map[string]string{"metric_type": "counter", "temporality": "delta"},
+					map[string]interface{}{"value": int64(3)},
+					time.Unix(0, 0), telegraf.Counter),
+				testutil.MustMetric("requests",
+					map[string]string{"metric_type": "counter", "temporality": "cumulative"},
+					map[string]interface{}{"value": int64(3)},
+					time.Unix(0, 0), telegraf.Counter),
+				testutil.MustMetric("requests",
+					map[string]string{"metric_type": "counter", "temporality": "delta"},
+					map[string]interface{}{"value": int64(4)},
+					time.Unix(0, 0), telegraf.Counter),
+				testutil.MustMetric("requests",
+					map[string]string{"metric_type": "counter", "temporality": "cumulative"},
+					map[string]interface{}{"value": int64(7)},
+					time.Unix(0, 0), telegraf.Counter),
+			}
+			testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime(), testutil.SortMetrics())
+		})
+	}
+}
+
+func TestMirrorTemporalityStaleTotals(t *testing.T) {
+	s := newTestStatsd()
+	s.MirrorTemporality = true
+	s.DeleteCounters = true
+
+	cumulative := func() interface{} {
+		var acc testutil.Accumulator
+		require.NoError(t, s.Gather(&acc))
+		for _, m := range acc.GetTelegrafMetrics() {
+			if m.Tags()["temporality"] == "cumulative" {
+				v, _ := m.GetField("value")
+				return v
+			}
+		}
+		return nil
+	}
+
+	require.NoError(t, s.parseStatsdLine("requests:3|c"))
+	require.Equal(t, int64(3), cumulative())
+
+	// The totals are kept for a counter missing for a few intervals
+	for range staleStateIntervals - 1 {
+		require.Nil(t, cumulative())
+	}
+	require.NoError(t, s.parseStatsdLine("requests:4|c"))
+	require.Equal(t, int64(7), cumulative())
+
+	// The totals are removed once the counter is missing for too long
+	for range staleStateIntervals {
+		require.Nil(t, cumulative())
+	}
+	require.Empty(t, s.mirroredCounters)
+	require.NoError(t, s.parseStatsdLine("requests:4|c"))
+	require.Equal(t, int64(4), cumulative())
+}
+
+func TestCommentPrefix(t *testing.T) {
+	logger := &testutil.CaptureLogger{}
+	plugin := &Statsd{
+		Log:                    logger,
+		Protocol:               "udp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 10,
+		NumberWorkerThreads:    1,
+		CommentPrefix:          "#",
+		DataDogExtensions:      true,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Start(&acc))
+	defer plugin.Stop()
+
+	payload := "# replayed capture\n   \n\t\ncpu:1|c|#host:a\n  # indented comment\n\ncpu:2|c|#host:a\n"
+	plugin.in <- input{Buffer: bytes.NewBufferString(payload), Time: time.Now()}
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric("cpu",
+			map[string]string{"metric_type": "counter", "host": "a"},
+			map[string]interface{}{"value": int64(3)},
+			time.Unix(0, 0), telegraf.Counter),
+	}
+	require.Eventually(t, func() bool {
+		acc.ClearMetrics()
+		require.NoError(t, plugin.Gather(&acc))
+		v, found := acc.Get("cpu")
+		return found && v.Fields["value"] == int64(3)
+	}, time.Second, 10*time.Millisecond)
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime())
+	require.Empty(t, logger.Errors())
+}
+
+func TestParse_PercentileWindow(t *testing.T) {
+	s := newTestStatsd()
+	s.Percentiles = []number{10}
+	s.DeleteTimings = true
+	s.PercentileWindow = config.Duration(time.Minute)
+
+	var acc testutil.Accumulator
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, s.parseStatsdLine(fmt.Sprintf("latency:%d|ms", i)))
+	}
+	require.NoError(t, s.Gather(&acc))
+	for i := 100; i <= 110; i++ {
+		require.NoError(t, s.parseStatsdLine(fmt.Sprintf("latency:%d|ms", i)))
+	}
+	require.NoError(t, s.Gather(&acc))
+
+	// The percentile of the second interval spans both intervals while the
+	// other stats only cover the current interval
+	metrics := acc.GetTelegrafMetrics()
+	require.Len(t, metrics, 2)
+	require.Equal(t, 2.0, metrics[0].Fields()["10_percentile"])
+	require.Equal(t, 3.0, metrics[1].Fields()["10_percentile"])
+	require.Equal(t, int64(11), metrics[1].Fields()["count"])
+
+	// Without new samples only the percentiles are emitted until the
+	// samples expire
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+	require.Equal(t, map[string]interface{}{"10_percentile": 3.0}, acc.GetTelegrafMetrics()[0].Fields())
+
+	for _, cached := range s.timings {
+		for name, stats := range cached.fields {
+			for i := range stats.samples {
+				stats.samples[i].time = stats.samples[i].time.Add(-2 * time.Minute)
+			}
+			cached.fields[name] = stats
+		}
+	}
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	require.Empty(t, acc.GetTelegrafMetrics())
+	require.Empty(t, s.timings)
+}
+
+func TestSingleFieldNaming(t *testing.T) {
+	s := newTestStatsd()
+	s.SingleFieldNaming = "name"
+	s.Templates = []string{"multi.* measurement.field"}
+
+	lines := []string{
+		"requests:1|c",
+		"load:2|g",
+		"users:alice|s",
+		"multi.rx:3|c",
+		"multi.tx:4|c",
+		"requests.sent:5|c",
+	}
+	for _, line := range lines {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, s.Gather(acc))
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric("requests",
+			map[string]string{"metric_type": "counter"},
+			map[string]interface{}{"requests": int64(1)},
+			time.Unix(0, 0), telegraf.Counter),
+		testutil.MustMetric("load",
+			map[string]string{"metric_type": "gauge"},
+			map[string]interface{}{"load": 2.0},
+			time.Unix(0, 0), telegraf.Gauge),
+		testutil.MustMetric("users",
+			map[string]string{"metric_type": "set"},
+			map[string]interface{}{"users": int64(1)},
+			time.Unix(0, 0)),
+		testutil.MustMetric("multi",
+			map[string]string{"metric_type": "counter"},
+			map[string]interface{}{"rx": int64(3), "tx": int64(4)},
+			time.Unix(0, 0), telegraf.Counter),
+		testutil.MustMetric("requests_sent",
+			map[string]string{"metric_type": "counter"},
+			map[string]interface{}{"requests_sent": int64(5)},
+			time.Unix(0, 0), telegraf.Counter),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime(), testutil.SortMetrics())
+}
+
+func TestSingleFieldNamingInvalid(t *testing.T) {
+	statsd := Statsd{
+		Log:               testutil.Logger{},
+		Protocol:          "udp",
+		ServiceAddress:    "localhost:0",
+		SingleFieldNaming: "field",
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), `invalid single_field_naming "field"`)
+}
+
+func TestParse_TypeConflictPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expected []string
+		errors   int
+	}{
+		{policy: "allow", expected: []string{"counter", "gauge", "timing", "histogram"}},
+		{policy: "first_type_wins", expected: []string{"counter", "timing", "histogram"}},
+		{policy: "error", expected: []string{"counter", "timing", "histogram"}, errors: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			logger := &testutil.CaptureLogger{}
+			s := newTestStatsd()
+			s.Log = logger
+			s.TypeConflictPolicy = tt.policy
+
+			require.NoError(t, s.parseStatsdLine("foo:1|c"))
+			err := s.parseStatsdLine("foo:2|g")
+			if tt.errors > 0 {
+				require.ErrorIs(t, err, errParsing)
+			} else {
+				require.NoError(t, err)
+			}
+			require.NoError(t, s.parseStatsdLine("bar:3|ms"))
+			require.NoError(t, s.parseStatsdLine("bar:4|h"))
+
+			acc := &testutil.Accumulator{}
+			require.NoError(t, s.Gather(acc))
+			types := make([]string, 0, len(tt.expected))
+			for _, m := range acc.GetTelegrafMetrics() {
+				mtype, _ := m.GetTag("metric_type")
+				types = append(types, mtype)
+			}
+			require.ElementsMatch(t, tt.expected, types)
+			require.Len(t, logger.Errors(), tt.errors)
+		})
+	}
+}
+
+func TestGeoIPTags(t *testing.T) {
+	plugin := &Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "udp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 10,
+		NumberWorkerThreads:    1,
+		GeoIPDB:                "testdata/geoip-city-test.mmdb",
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Start(&acc))
+	defer plugin.Stop()
+
+	plugin.in <- input{Buffer: bytes.NewBufferString("requests:1|c\n"), Time: time.Now(), Addr: "81.2.69.142"}
+	plugin.in <- input{Buffer: bytes.NewBufferString("errors:1|c\n"), Time: time.Now(), Addr: "10.0.0.1"}
+	plugin.in <- input{Buffer: bytes.NewBufferString("logins,country=FR:1|c\n"), Time: time.Now(), Addr: "2.125.160.216"}
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric("requests",
+			map[string]string{"metric_type": "counter", "country": "GB"},
+			map[string]interface{}{"value": int64(1)},
+			time.Unix(0, 0), telegraf.Counter),
+		testutil.MustMetric("errors",
+			map[string]string{"metric_type": "counter"},
+			map[string]interface{}{"value": int64(1)},
+			time.Unix(0, 0), telegraf.Counter),
+		testutil.MustMetric("logins",
+			map[string]string{"metric_type": "counter", "country": "FR"},
+			map[string]interface{}{"value": int64(1)},
+			time.Unix(0, 0), telegraf.Counter),
+	}
+	require.Eventually(t, func() bool {
+		acc.ClearMetrics()
+		require.NoError(t, plugin.Gather(&acc))
+		return acc.NMetrics() == uint64(len(expected))
+	}, time.Second, 10*time.Millisecond)
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime(), testutil.SortMetrics())
+}
+
+func TestGeoIPClosedOnStartFailure(t *testing.T) {
+	// Occupy the address to make starting the listener fail
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	plugin := &Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "tcp",
+		ServiceAddress:         l.Addr().String(),
+		AllowedPendingMessages: 10,
+		MaxTCPConnections:      2,
+		NumberWorkerThreads:    1,
+		GeoIPDB:                "testdata/geoip-city-test.mmdb",
+	}
+	var acc testutil.Accumulator
+	require.Error(t, plugin.Start(&acc))
+	require.Nil(t, plugin.geoip)
+}
+
+func TestParse_AggregationTemporalityTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		types    []string
+		deleted  bool
+		expected map[string]string
+	}{
+		{
+			name:    "default",
+			deleted: true,
+			expected: map[string]string{
+				"counter": "delta",
+			},
+		},
+		{
+			name:    "all types deleted",
+			types:   []string{"counter", "set", "timing", "histogram", "distribution"},
+			deleted: true,
+			expected: map[string]string{
+				"counter":      "delta",
+				"set":          "delta",
+				"timing":       "delta",
+				"histogram":    "delta",
+				"distribution": "delta",
+			},
+		},
+		{
+			name:  "all types kept",
+			types: []string{"counter", "set", "timing", "histogram", "distribution"},
+			expected: map[string]string{
+				"counter":      "cumulative",
+				"set":          "cumulative",
+				"timing":       "cumulative",
+				"histogram":    "cumulative",
+				"distribution": "delta",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.EnableAggregationTemporality = true
+			s.AggregationTemporalityTypes = tt.types
+			s.DataDogExtensions = true
+			s.DataDogDistributions = true
+			s.DeleteCounters = tt.deleted
+			s.DeleteSets = tt.deleted
+			s.DeleteTimings = tt.deleted
+
+			for _, line := range []string{"cnt:1|c", "gauge:1|g", "set:1|s", "timing:1|ms", "hist:1|h", "dist:1|d"} {
+				require.NoError(t, s.parseStatsdLine(line))
+			}
+
+			acc := &testutil.Accumulator{}
+			require.NoError(t, s.Gather(acc))
+			require.Len(t, acc.GetTelegrafMetrics(), 6)
+
+			actual := make(map[string]string)
+			for _, m := range acc.GetTelegrafMetrics() {
+				if temporality, found := m.GetTag("temporality"); found {
+					mtype, _ := m.GetTag("metric_type")
+					actual[mtype] = temporality
+				}
+			}
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestAggregationTemporalityTypesInvalid(t *testing.T) {
+	statsd := Statsd{
+		Log:                         testutil.Logger{},
+		Protocol:                    "udp",
+		ServiceAddress:              "localhost:0",
+		AggregationTemporalityTypes: []string{"gauge"},
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), `invalid aggregation_temporality_types entry "gauge"`)
+}
+
+func TestSeriesKeyHash(t *testing.T) {
+	lines := []string{
+		"cpu,host=a,region=west:1|c",
+		"cpu,region=west,host=a:2|c",
+		"cpu,host=b,region=west:4|c",
+		"cpu,host=a:8|c",
+		"cpu,host=a:1|g",
+	}
+
+	var expected []telegraf.Metric
+	for _, mode := range []string{"string", "xxhash"} {
+		s := newTestStatsd()
+		s.SeriesKeyHash = mode
+		for _, line := range lines {
+			require.NoError(t, s.parseStatsdLine(line))
+		}
+		for hash := range s.counters {
+			if mode == "xxhash" {
+				require.Len(t, hash, 8)
+			}
+		}
+
+		acc := &testutil.Accumulator{}
+		require.NoError(t, s.Gather(acc))
+		require.Len(t, acc.GetTelegrafMetrics(), 4)
+		if expected == nil {
+			expected = acc.GetTelegrafMetrics()
+			continue
+		}
+		testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime(), testutil.SortMetrics())
+	}
+}
+
+func BenchmarkSeriesKeyHash(b *testing.B) {
+	lines := []string{
+		"test.timing,host=h1,region=us-east,service=api,rack=r01:1|ms",
+		"test.timing,host=h2,region=us-east,service=api,rack=r01:11|ms",
+		"test.timing,host=h3,region=us-west,service=web,rack=r02:1|ms",
+		"test.counter,host=h1,region=us-east,service=api,rack=r01:1|c",
+		"test.counter,host=h2,region=us-west,service=web,rack=r02:2|c",
+	}
+	for _, mode := range []string{"string", "xxhash"} {
+		b.Run(mode, func(b *testing.B) {
+			s := newTestStatsd()
+			s.SeriesKeyHash = mode
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				for _, line := range lines {
+					if err := s.parseStatsdLine(line); err != nil {
+						b.Errorf("Parsing line %s should not have resulted in an error\n", line)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParse_SetWindow(t *testing.T) {
+	s := newTestStatsd()
+	s.DeleteSets = true
+	s.SetWindow = config.Duration(time.Hour)
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.parseStatsdLine("users:alice|s"))
+	require.NoError(t, s.parseStatsdLine("users:bob|s"))
+	require.NoError(t, s.Gather(&acc))
+	require.NoError(t, s.parseStatsdLine("users:alice|s"))
+	require.NoError(t, s.parseStatsdLine("users:carol|s"))
+	require.NoError(t, s.Gather(&acc))
+
+	// The second interval counts the members of both intervals
+	metrics := acc.GetTelegrafMetrics()
+	require.Len(t, metrics, 2)
+	require.Equal(t, int64(2), metrics[0].Fields()["value"])
+	require.Equal(t, int64(3), metrics[1].Fields()["value"])
+
+	// Members are counted until they were not seen for the window duration
+	for _, cached := range s.sets {
+		members := cached.seen["value"]
+		members["bob"] = members["bob"].Add(-2 * time.Hour)
+	}
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+	require.Equal(t, int64(2), acc.GetTelegrafMetrics()[0].Fields()["value"])
+
+	for _, cached := range s.sets {
+		for member, seen := range cached.seen["value"] {
+			cached.seen["value"][member] = seen.Add(-2 * time.Hour)
+		}
+	}
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	require.Empty(t, acc.GetTelegrafMetrics())
+	require.Empty(t, s.sets)
+}
+
+func TestTCPFallbackSplit(t *testing.T) {
+	statsd := Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "tcp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 10000,
+		MaxTCPConnections:      2,
+		NumberWorkerThreads:    5,
+		TCPFallbackSplit:       true,
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, statsd.Start(&acc))
+	defer statsd.Stop()
+
+	conn, err := net.Dial("tcp", statsd.TCPlistener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("requests:1|cload:2|glatency:3|ms|@0.5users:alice|s"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool {
+		require.NoError(t, statsd.Gather(&acc))
+		return acc.NMetrics() >= 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"requests",
+			map[string]string{"metric_type": "counter"},
+			map[string]interface{}{"value": 1},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"load",
+			map[string]string{"metric_type": "gauge"},
+			map[string]interface{}{"value": 2.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"latency",
+			map[string]string{"metric_type": "timing"},
+			map[string]interface{}{
+				"count":  2,
+				"lower":  3.0,
+				"mean":   3.0,
+				"median": 3.0,
+				"stddev": 0.0,
+				"sum":    6.0,
+				"upper":  3.0,
+			},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"users",
+			map[string]string{"metric_type": "set"},
+			map[string]interface{}{"value": 1},
+			time.Unix(0, 0),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime(), testutil.SortMetrics())
+}
+
+func TestSplitConcatenatedMetrics(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{
+			line:     "requests:1|c",
+			expected: []string{"requests:1|c"},
+		},
+		{
+			line:     "requests:1|cload:2|g",
+			expected: []string{"requests:1|c", "load:2|g"},
+		},
+		{
+			line:     "latency:3|ms|@0.1hits:1|h",
+			expected: []string{"latency:3|ms|@0.1", "hits:1|h"},
+		},
+		{
+			line:     "requests:1|c|#env:prod",
+			expected: []string{"requests:1|c|#env:prod"},
+		},
+		{
+			line:     "requests:1|cload:2|g|#env:prod",
+			expected: []string{"requests:1|c", "load:2|g|#env:prod"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			require.Equal(t, tt.expected, splitConcatenatedMetrics(tt.line))
+		})
+	}
+}
+
+func TestEmitSampleCount(t *testing.T) {
+	s := newTestStatsd()
+	s.EmitSampleCount = true
+	s.DeleteCounters = true
+	s.DeleteSets = true
+	s.DeleteTimings = true
+
+	lines := []string{
+		"requests:1|c",
+		"requests:2|c|@0.5",
+		"load:1|g",
+		"load:+2|g",
+		"load:3|g",
+		"users:alice|s",
+		"users:alice|s",
+		"latency:1|ms|@0.1",
+		"latency:2|ms",
+	}
+	for _, line := range lines {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	samples := make(map[string]interface{})
+	for _, m := range acc.GetTelegrafMetrics() {
+		samples[m.Name()] = m.Fields()["samples"]
+	}
+	expected := map[string]interface{}{
+		"requests": int64(2),
+		"load":     int64(3),
+		"users":    int64(2),
+		"latency":  int64(2),
+	}
+	require.Equal(t, expected, samples)
+
+	// Gauges are kept across intervals and report no samples if not updated
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+	require.Equal(t, "load", acc.GetTelegrafMetrics()[0].Name())
+	require.Equal(t, int64(0), acc.GetTelegrafMetrics()[0].Fields()["samples"])
+}
+
+func BenchmarkGatherDistributions(b *testing.B) {
+	s := newTestStatsd()
+	s.DataDogExtensions = true
+	s.DataDogDistributions = true
+
+	lines := make([]string, 0, 100)
+	for i := range 100 {
+		lines = append(lines, fmt.Sprintf("request.latency:%d|d|#host:h%d", i, i%10))
+	}
+
+	var acc testutil.NopAccumulator
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		for _, line := range lines {
+			if err := s.parseStatsdLine(line); err != nil {
+				b.Errorf("Parsing line %s should not have resulted in an error\n", line)
+			}
+		}
+		if err := s.Gather(&acc); err != nil {
+			b.Error(err)
+		}
+	}
+}
+
+func TestGatherDistributionsReuseBuffer(t *testing.T) {
+	s := newTestStatsd()
+	s.DataDogExtensions = true
+	s.DataDogDistributions = true
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.parseStatsdLine("latency:1|d|#host:a"))
+	require.NoError(t, s.parseStatsdLine("latency:2|d|#host:b"))
+	require.NoError(t, s.Gather(&acc))
+	require.Len(t, acc.GetTelegrafMetrics(), 2)
+
+	// The emitted entries must not retain references to their tags
+	require.Empty(t, s.distributions)
+	for _, m := range s.distributions[:cap(s.distributions)] {
+		require.Nil(t, m.tags)
+	}
+
+	acc.ClearMetrics()
+	require.NoError(t, s.parseStatsdLine("latency:3|d|#host:c"))
+	require.NoError(t, s.Gather(&acc))
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+	require.Equal(t, 3.0, acc.GetTelegrafMetrics()[0].Fields()["value"])
+}
+
+func TestTypeEmissionMode(t *testing.T) {
+	lines := []string{
+		"requests:1|c",
+		"load:2|g",
+		"users:alice|s",
+		"latency:3|ms",
+		"size:4|h",
+		"duration:5|d",
+	}
+
+	tests := []struct {
+		mode     string
+		expected map[string]telegraf.ValueType
+	}{
+		{
+			mode: "typed",
+			expected: map[string]telegraf.ValueType{
+				"requests": telegraf.Counter,
+				"load":     telegraf.Gauge,
+				"users":    telegraf.Untyped,
+				"latency":  telegraf.Untyped,
+				"size":     telegraf.Untyped,
+				"duration": telegraf.Untyped,
+			},
+		},
+		{
+			mode: "untyped",
+			expected: map[string]telegraf.ValueType{
+				"requests": telegraf.Untyped,
+				"load":     telegraf.Untyped,
+				"users":    telegraf.Untyped,
+				"latency":  telegraf.Untyped,
+				"size":     telegraf.Untyped,
+				"duration": telegraf.Untyped,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			s := newTestStatsd()
+			s.DataDogExtensions = true
+			s.DataDogDistributions = true
+			s.TypeEmissionMode = tt.mode
+			for _, line := range lines {
+				require.NoError(t, s.parseStatsdLine(line))
+			}
+
+			var acc testutil.Accumulator
+			require.NoError(t, s.Gather(&acc))
+			actual := make(map[string]telegraf.ValueType)
+			for _, m := range acc.Metrics {
+				actual[m.Measurement] = m.Type
+			}
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestTypeEmissionModeInvalid(t *testing.T) {
+	statsd := Statsd{
+		Log:              testutil.Logger{},
+		Protocol:         "udp",
+		ServiceAddress:   "localhost:0",
+		TypeEmissionMode: "counter",
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), `invalid type_emission_mode "counter"`)
+}
+
+func TestTCPListenBacklogInvalid(t *testing.T) {
+	statsd := Statsd{
+		Log:              testutil.Logger{},
+		Protocol:         "tcp",
+		ServiceAddress:   "localhost:0",
+		TCPListenBacklog: -1,
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), "invalid tcp_listen_backlog -1")
+}
+
+func TestTCPWithTLS(t *testing.T) {
+	clientTLSConfig, err := pki.TLSClientConfig().TLSConfig()
+	require.NoError(t, err)
+
+	cas := x509.NewCertPool()
+	require.True(t, cas.AppendCertsFromPEM([]byte(pki.ReadCACert())))
+	noClientCertTLSConfig := &tls.Config{
+		RootCAs:    cas,
+		ServerName: clientTLSConfig.ServerName,
+	}
+
+	tests := []struct {
+		name      string
+		tlsConfig *tls.Config
+		accepted  bool
+	}{
+		{
+			name:      "valid client certificate",
+			tlsConfig: clientTLSConfig,
+			accepted:  true,
+		},
+		{
+			name:      "no client certificate",
+			tlsConfig: noClientCertTLSConfig,
+		},
+		{
+			name: "plain TCP",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statsd := Statsd{
+				Log:                    testutil.Logger{},
+				Protocol:               "tcp",
+				ServiceAddress:         "localhost:0",
+				AllowedPendingMessages: 10000,
+				MaxTCPConnections:      2,
+				NumberWorkerThreads:    5,
+				ServerConfig:           *pki.TLSServerConfig(),
+			}
+			var acc testutil.Accumulator
+			require.NoError(t, statsd.Start(&acc))
+			defer statsd.Stop()
+
+			addr := statsd.TCPlistener.Addr().String()
+			var conn net.Conn
+			if tt.tlsConfig != nil {
+				conn, err = tls.Dial("tcp", addr, tt.tlsConfig)
+			} else {
+				conn, err = net.Dial("tcp", addr)
+			}
+			require.NoError(t, err)
+			defer conn.Close()
+
+			// Writing might fail if the server already rejected the client
+			// certificate during the handshake
+			_, err = conn.Write([]byte("cpu.time_idle:42|c\n"))
+			if !tt.accepted {
+				// The server closes the connection after a failed handshake
+				if err == nil {
+					require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+					_, err = conn.Read(make([]byte, 1))
+				}
+				require.Error(t, err)
+				require.NoError(t, statsd.Gather(&acc))
+				require.Empty(t, acc.GetTelegrafMetrics())
+				return
+			}
+			require.NoError(t, err)
+
+			require.Eventually(t, func() bool {
+				require.NoError(t, statsd.Gather(&acc))
+				return acc.NMetrics() > 0
+			}, 5*time.Second, 10*time.Millisecond)
+			require.Equal(t, map[string]interface{}{"value": int64(42)}, acc.GetTelegrafMetrics()[0].Fields())
+		})
+	}
+}
+
+func TestPendingMessagesRecovery(t *testing.T) {
+	logger := &testutil.CaptureLogger{}
+	s := newTestStatsd()
+	s.Log = logger
+	s.in = make(chan input, 10)
+	s.PendingMessagesHighWatermark = 8
+	s.PendingMessagesLowWatermark = 2
+
+	// Fill the queue above the high watermark
+	for range 10 {
+		s.in <- input{}
+		s.updatePendingMessages()
+	}
+
+	// Drain the queue, the recovery is signaled when falling to the low
+	// watermark and not repeated for the remaining messages
+	for range 10 {
+		<-s.in
+		s.updatePendingMessages()
+	}
+	require.Equal(t, int64(1), s.Stats.QueueRecoveries.Get())
+
+	// Refilling below the high watermark does not signal another recovery
+	for range 5 {
+		s.in <- input{}
+		s.updatePendingMessages()
+	}
+	for range 5 {
+		<-s.in
+		s.updatePendingMessages()
+	}
+	require.Equal(t, int64(1), s.Stats.QueueRecoveries.Get())
+
+	var recoveries int
+	for _, entry := range logger.Messages() {
+		if strings.Contains(entry.Text, "queue recovered") {
+			recoveries++
+		}
+	}
+	require.Equal(t, 1, recoveries)
+}
+
+func TestPendingMessagesWatermarksInvalid(t *testing.T) {
+	statsd := Statsd{
+		Log:                          testutil.Logger{},
+		Protocol:                     "udp",
+		ServiceAddress:               "localhost:0",
+		AllowedPendingMessages:       100,
+		PendingMessagesLowWatermark:  100,
+		PendingMessagesHighWatermark: 50,
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), "invalid pending_messages_low_watermark 100")
+}
+
+func TestApplySampleRateToDistributions(t *testing.T) {
+	tests := []struct {
+		name        string
+		apply       bool
+		percentiles []number
+		expected    []telegraf.Metric
+	}{
+		{
+			name: "ignored",
+			expected: []telegraf.Metric{
+				testutil.MustMetric("latency", map[string]string{"metric_type": "distribution"}, map[string]interface{}{"value": 1.0}, time.Unix(0, 0)),
+				testutil.MustMetric("latency", map[string]string{"metric_type": "distribution"}, map[string]interface{}{"value": 100.0}, time.Unix(0, 0)),
+			},
+		},
+		{
+			name:  "raw values",
+			apply: true,
+			expected: []telegraf.Metric{
+				testutil.MustMetric("latency", map[string]string{"metric_type": "distribution"}, map[string]interface{}{"value": 1.0}, time.Unix(0, 0)),
+				testutil.MustMetric("latency", map[string]string{"metric_type": "distribution"}, map[string]interface{}{"value": 100.0}, time.Unix(0, 0)),
+				testutil.MustMetric("latency", map[string]string{"metric_type": "distribution"}, map[string]interface{}{"value": 100.0}, time.Unix(0, 0)),
+				testutil.MustMetric("latency", map[string]string{"metric_type": "distribution"}, map[string]interface{}{"value": 100.0}, time.Unix(0, 0)),
+				testutil.MustMetric("latency", map[string]string{"metric_type": "distribution"}, map[string]interface{}{"value": 100.0}, time.Unix(0, 0)),
+			},
+		},
+		{
+			name:        "percentiles",
+			apply:       true,
+			percentiles: []number{50},
+			expected: []telegraf.Metric{
+				testutil.MustMetric("latency", map[string]string{"metric_type": "distribution"}, map[string]interface{}{"50_percentile": 100.0}, time.Unix(0, 0)),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.DataDogExtensions = true
+			s.DataDogDistributions = true
+			s.DistributionPercentiles = tt.percentiles
+			s.ApplySampleRateToDistributions = tt.apply
+
+			require.NoError(t, s.parseStatsdLine("latency:1|d"))
+			require.NoError(t, s.parseStatsdLine("latency:100|d|@0.25"))
+
+			var acc testutil.Accumulator
+			require.NoError(t, s.Gather(&acc))
+			testutil.RequireMetricsEqual(t, tt.expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime())
+		})
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	for _, protocol := range []string{"unix", "unixgram"} {
+		t.Run(protocol, func(t *testing.T) {
+			if runtime.GOOS == "windows" {
+				t.Skip("Unix domain sockets are not fully supported on Windows")
+			}
+
+			path := filepath.Join(t.TempDir(), "statsd.sock")
+			statsd := Statsd{
+				Log:                    testutil.Logger{},
+				Protocol:               protocol,
+				ServiceAddress:         path,
+				UnixSocketMode:         "600",
+				AllowedPendingMessages: 10000,
+				MaxTCPConnections:      2,
+				NumberWorkerThreads:    5,
+			}
+			var acc testutil.Accumulator
+			require.NoError(t, statsd.Start(&acc))
+
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			require.Equal(t, os.ModeSocket|0600, info.Mode())
+
+			conn, err := net.Dial(protocol, path)
+			require.NoError(t, err)
+			_, err = conn.Write([]byte("requests:1|c\nrequests:2|c\n"))
+			require.NoError(t, err)
+			_, err = conn.Write([]byte("requests:4|c\n"))
+			require.NoError(t, err)
+			require.NoError(t, conn.Close())
+
+			// Counters are not deleted so the last gathered metric holds the
+			// total once all lines are parsed
+			require.Eventually(t, func() bool {
+				acc.ClearMetrics()
+				require.NoError(t, statsd.Gather(&acc))
+				metrics := acc.GetTelegrafMetrics()
+				return len(metrics) == 1 && metrics[0].Fields()["value"] == int64(7)
+			}, 5*time.Second, 10*time.Millisecond)
+
+			expected := []telegraf.Metric{
+				testutil.MustMetric(
+					"requests",
+					map[string]string{"metric_type": "counter"},
+					map[string]interface{}{"value": 7},
+					time.Unix(0, 0),
+					telegraf.Counter,
+				),
+			}
+			testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime())
+
+			// The socket file is removed on shutdown
+			statsd.Stop()
+			require.NoFileExists(t, path)
+		})
+	}
+}
+
+func TestUnixSocketPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix domain sockets are not fully supported on Windows")
+	}
+
+	newPlugin := func(path string) *Statsd {
+		return &Statsd{
+			Log:                    testutil.Logger{},
+			Protocol:               "unix",
+			ServiceAddress:         path,
+			AllowedPendingMessages: 10,
+			MaxTCPConnections:      2,
+			NumberWorkerThreads:    1,
+		}
+	}
+
+	// The default address is not a usable path
+	var acc testutil.Accumulator
+	require.ErrorContains(t, newPlugin(defaultServiceAddress).Start(&acc), "must be set to a socket path")
+
+	// Other files are never removed
+	path := filepath.Join(t.TempDir(), "statsd.conf")
+	require.NoError(t, os.WriteFile(path, []byte("keep"), 0600))
+	require.ErrorContains(t, newPlugin(path).Start(&acc), "is not a socket")
+	require.FileExists(t, path)
+
+	// Stale sockets are replaced
+	path = filepath.Join(t.TempDir(), "statsd.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	l.SetUnlinkOnClose(false)
+	require.NoError(t, l.Close())
+	plugin := newPlugin(path)
+	require.NoError(t, plugin.Start(&acc))
+	plugin.Stop()
+}
+
+func TestCounterAcceleration(t *testing.T) {
+	for _, deleteCounters := range []bool{true, false} {
+		t.Run(fmt.Sprintf("delete_counters=%v", deleteCounters), func(t *testing.T) {
+			s := newTestStatsd()
+			s.CounterAcceleration = true
+			s.DeleteCounters = deleteCounters
+
+			// Linearly increase the rate by one per second each interval of
+			// ten seconds
+			var accels []interface{}
+			for i := 1; i <= 5; i++ {
+				require.NoError(t, s.parseStatsdLine(fmt.Sprintf("requests:%d|c", 10*i)))
+				s.lastGatherTime = time.Now().Add(-10 * time.Second)
+
+				var acc testutil.Accumulator
+				require.NoError(t, s.Gather(&acc))
+				metrics := acc.GetTelegrafMetrics()
+				require.Len(t, metrics, 1)
+				if accel, found := metrics[0].GetField("value_accel"); found {
+					accels = append(accels, accel)
+				}
+			}
+
+			// The rate requires a previous value for cumulative counters
+			expected := 4
+			if !deleteCounters {
+				expected = 3
+			}
+			require.Len(t, accels, expected)
+			for _, accel := range accels {
+				require.InDelta(t, 1.0, accel, 0.01)
+			}
+		})
+	}
+}
+
+func TestCounterAccelerationStaleRates(t *testing.T) {
+	s := newTestStatsd()
+	s.CounterAcceleration = true
+	s.DeleteCounters = true
+
+	gather := func() *testutil.Accumulator {
+		s.lastGatherTime = time.Now().Add(-10 * time.Second)
+		var acc testutil.Accumulator
+		require.NoError(t, s.Gather(&acc))
+		return &acc
+	}
+
+	require.NoError(t, s.parseStatsdLine("requests:10|c"))
+	gather()
+	require.Len(t, s.counterRates, 1)
+
+	// The rates of a counter missing for too long are removed so the
+	// acceleration starts over
+	for range staleStateIntervals {
+		gather()
+	}
+	require.Empty(t, s.counterRates)
+	require.NoError(t, s.parseStatsdLine("requests:20|c"))
+	metrics := gather().GetTelegrafMetrics()
+	require.Len(t, metrics, 1)
+	require.False(t, metrics[0].HasField("value_accel"))
+}
+
+func TestClockRegression(t *testing.T) {
+	s := newTestStatsd()
+	s.CounterAcceleration = true
+	s.DeleteCounters = true
+
+	gather := func(lastGatherTime time.Time) telegraf.Metric {
+		require.NoError(t, s.parseStatsdLine("requests:10|c"))
+		s.lastGatherTime = lastGatherTime
+
+		var acc testutil.Accumulator
+		require.NoError(t, s.Gather(&acc))
+		metrics := acc.GetTelegrafMetrics()
+		require.Len(t, metrics, 1)
+		return metrics[0]
+	}
+
+	gather(time.Now().Add(-10 * time.Second))
+	m := gather(time.Now().Add(-10 * time.Second))
+	require.True(t, m.HasField("value_accel"))
+	require.Zero(t, s.Stats.ClockRegressions.Get())
+
+	// Simulate the wall clock stepping backwards, stripping the monotonic
+	// clock reading of the last gather time
+	m = gather(time.Now().Add(time.Hour).Round(0))
+	require.True(t, m.HasField("value"))
+	require.False(t, m.HasField("value_accel"))
+	require.Equal(t, int64(1), s.Stats.ClockRegressions.Get())
+
+	// The computation restarts with the next valid interval
+	m = gather(time.Now().Add(-10 * time.Second))
+	require.False(t, m.HasField("value_accel"))
+	m = gather(time.Now().Add(-10 * time.Second))
+	require.InDelta(t, 0.0, m.Fields()["value_accel"], 0.01)
+	require.Equal(t, int64(1), s.Stats.ClockRegressions.Get())
+}
+
+func TestReportInternalStats(t *testing.T) {
+	// Without parser workers the queue is never drained
+	statsd := Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "udp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 1,
+		ReportInternalStats:    true,
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, statsd.Start(&acc))
+	defer statsd.Stop()
+
+	conn, err := net.Dial("udp", statsd.UDPlistener.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	for range 5 {
+		_, err = conn.Write([]byte("requests:1|c"))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		return statsd.drops.Load() >= 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, statsd.Gather(&acc))
+	m, found := acc.Get("internal_statsd")
+	require.True(t, found)
+	require.Equal(t, map[string]string{"address": "localhost:0"}, m.Tags)
+	require.Equal(t, int64(4), m.Fields["dropped"])
+	require.Equal(t, int64(1), m.Fields["pending"])
+}
+
+func TestMaxLinesPerSecondPerSource(t *testing.T) {
+	plugin := &Statsd{
+		Log:                        testutil.Logger{},
+		Protocol:                   "udp",
+		ServiceAddress:             "localhost:0",
+		AllowedPendingMessages:     10,
+		NumberWorkerThreads:        1,
+		MaxLinesPerSecondPerSource: 5,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Start(&acc))
+	defer plugin.Stop()
+
+	// The first source sends a burst exceeding the limit while the second
+	// one stays within the limit, the lines exceeding the limit are removed
+	// before queueing
+	plugin.enqueueTCP(bytes.NewBufferString(strings.Repeat("noisy:1|c\n", 10)), "10.0.0.1", 1234)
+	plugin.enqueueTCP(bytes.NewBufferString(strings.Repeat("quiet:1|c\n", 3)), "10.0.0.2", 1234)
+	require.Equal(t, int64(5), plugin.Stats.RateLimitedLines.Get())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric("noisy",
+			map[string]string{"metric_type": "counter"},
+			map[string]interface{}{"value": int64(5)},
+			time.Unix(0, 0), telegraf.Counter),
+		testutil.MustMetric("quiet",
+			map[string]string{"metric_type": "counter"},
+			map[string]interface{}{"value": int64(3)},
+			time.Unix(0, 0), telegraf.Counter),
+	}
+	require.Eventually(t, func() bool {
+		return plugin.Stats.RateLimitedLines.Get() == 5 && len(plugin.in) == 0
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		acc.ClearMetrics()
+		require.NoError(t, plugin.Gather(&acc))
+		return acc.NMetrics() == 2
+	}, time.Second, 10*time.Millisecond)
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime(), testutil.SortMetrics())
+	require.Equal(t, int64(5), plugin.Stats.RateLimitedLines.Get())
+}
+
+func TestFloatPrecision(t *testing.T) {
+	precision := 2
+	s := newTestStatsd()
+	s.FloatPrecision = &precision
+	s.Percentiles = []number{50}
+	s.CounterAcceleration = true
+	s.DeleteCounters = true
+	s.DeleteTimings = true
+
+	lines := []string{
+		"latency:1|ms",
+		"latency:2|ms",
+		"latency:2.123456|ms",
+		"load:3.14159|g",
+		"requests:1|c",
+	}
+	for _, line := range lines {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+	s.lastGatherTime = time.Now().Add(-3 * time.Second)
+	require.NoError(t, s.Gather(&testutil.Accumulator{}))
+	require.NoError(t, s.parseStatsdLine("requests:2|c"))
+	for _, line := range lines[:3] {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+
+	var acc testutil.Accumulator
+	s.lastGatherTime = time.Now().Add(-3 * time.Second)
+	require.NoError(t, s.Gather(&acc))
+
+	latency, found := acc.Get("latency")
+	require.True(t, found)
+	require.Equal(t, 1.71, latency.Fields["mean"])
+	require.Equal(t, 0.5, latency.Fields["stddev"])
+	require.Equal(t, 5.12, latency.Fields["sum"])
+	require.Equal(t, 2.12, latency.Fields["upper"])
+	require.Equal(t, 2.0, latency.Fields["50_percentile"])
+	require.Equal(t, int64(3), latency.Fields["count"])
+
+	load, found := acc.Get("load")
+	require.True(t, found)
+	require.Equal(t, 3.14, load.Fields["value"])
+
+	requests, found := acc.Get("requests")
+	require.True(t, found)
+	require.Equal(t, int64(2), requests.Fields["value"])
+	require.Equal(t, 0.33, requests.Fields["value_accel"])
+}
+
+func TestFloatPrecisionHugeValues(t *testing.T) {
+	precision := maxFloatPrecision
+	s := newTestStatsd()
+	s.FloatPrecision = &precision
+
+	// Scaling huge values overflows, those are kept instead of becoming
+	// infinite
+	require.NoError(t, s.parseStatsdLine("huge:1e300|g"))
+	require.NoError(t, s.parseStatsdLine("negative:-1e300|g"))
+	require.NoError(t, s.parseStatsdLine("small:0.1234567890123456789|g"))
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	acc.AssertContainsFields(t, "huge", map[string]interface{}{"value": 1e300})
+	acc.AssertContainsFields(t, "negative", map[string]interface{}{"value": -1e300})
+	acc.AssertContainsFields(t, "small", map[string]interface{}{"value": 0.123456789012346})
+}
+
+func TestFloatPrecisionInvalid(t *testing.T) {
+	for _, precision := range []int{-1, maxFloatPrecision + 1} {
+		t.Run(fmt.Sprint(precision), func(t *testing.T) {
+			statsd := Statsd{
+				Log:            testutil.Logger{},
+				Protocol:       "udp",
+				ServiceAddress: "localhost:0",
+				FloatPrecision: &precision,
+			}
+			var acc testutil.Accumulator
+			require.ErrorContains(t, statsd.Start(&acc), fmt.Sprintf("invalid float_precision %d", precision))
+		})
+	}
+}
+
+func TestPercentileMethodTDigest(t *testing.T) {
+	s := newTestStatsd()
+	s.PercentileMethod = "tdigest"
+	s.Percentiles = []number{50, 90}
+	for i := range 100 {
+		require.NoError(t, s.parseStatsdLine(fmt.Sprintf("test.timing:%d|ms", i+1)))
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+
+	m, found := acc.Get("test_timing")
+	require.True(t, found)
+	fields := m.Fields
+	require.EqualValues(t, 100, fields["count"])
+	require.InDelta(t, 50.0, fields["50_percentile"], 2)
+	require.InDelta(t, 90.0, fields["90_percentile"], 2)
+	require.InDelta(t, 50.5, fields["median"], 2)
+}
+
+func TestPercentileMethodInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		window   config.Duration
+		expected string
+	}{
+		{
+			name:     "unknown method",
+			method:   "sketch",
+			expected: `invalid percentile_method "sketch"`,
+		},
+		{
+			name:     "tdigest with window",
+			method:   "tdigest",
+			window:   config.Duration(time.Minute),
+			expected: "cannot be used with percentile_window",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statsd := Statsd{
+				Log:              testutil.Logger{},
+				Protocol:         "udp",
+				ServiceAddress:   "localhost:0",
+				PercentileMethod: tt.method,
+				PercentileWindow: tt.window,
+			}
+			var acc testutil.Accumulator
+			require.ErrorContains(t, statsd.Start(&acc), tt.expected)
+		})
+	}
+}
+
+func TestGaugeExtraStats(t *testing.T) {
+	s := newTestStatsd()
+	s.GaugeExtraStats = true
+	s.Templates = []string{"disk.* measurement.field"}
+
+	lines := []string{
+		"cpu:50|g",
+		"cpu:+30|g",
+		"cpu:-70|g",
+		"cpu:20|g",
+		"mem,field=used:5|g",
+		"disk.used:5|g",
+		"disk.used:8|g",
+	}
+	for _, line := range lines {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"metric_type": "gauge"},
+			map[string]interface{}{
+				"value": float64(20),
+				"min":   float64(10),
+				"max":   float64(80),
+				"last":  float64(20),
+			},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"disk",
+			map[string]string{"metric_type": "gauge"},
+			map[string]interface{}{
+				"used":      float64(8),
+				"used_min":  float64(5),
+				"used_max":  float64(8),
+				"used_last": float64(8),
+			},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{"metric_type": "gauge", "field": "used"},
+			map[string]interface{}{
+				"value": float64(5),
+				"min":   float64(5),
+				"max":   float64(5),
+				"last":  float64(5),
+			},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime(), testutil.SortMetrics())
+
+	// Gauges without updates report their current value in the next interval
+	require.NoError(t, s.parseStatsdLine("cpu:+5|g"))
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	m, found := acc.Get("cpu")
+	require.True(t, found)
+	require.Equal(t, map[string]interface{}{
+		"value": float64(25),
+		"min":   float64(25),
+		"max":   float64(25),
+		"last":  float64(25),
+	}, m.Fields)
+	m, found = acc.Get("disk")
+	require.True(t, found)
+	require.Equal(t, float64(8), m.Fields["used_min"])
+}
+
+func TestCounterRate(t *testing.T) {
+	tests := []struct {
+		name           string
+		deleteCounters bool
+		floatCounters  bool
+		temporality    bool
+		expected       []float64
+	}{
+		{
+			name:           "delta",
+			deleteCounters: true,
+			expected:       []float64{1, 2, 3},
+		},
+		{
+			name:     "cumulative",
+			expected: []float64{2, 3},
+		},
+		{
+			name:           "float counters",
+			deleteCounters: true,
+			floatCounters:  true,
+			expected:       []float64{1, 2, 3},
+		},
+		{
+			name:           "aggregation temporality",
+			deleteCounters: true,
+			temporality:    true,
+			expected:       []float64{1, 2, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.CounterRate = true
+			s.DeleteCounters = tt.deleteCounters
+			s.FloatCounters = tt.floatCounters
+			s.EnableAggregationTemporality = tt.temporality
+
+			// Increase the counter by 10, 20 and 30 in intervals of ten seconds
+			var rates []float64
+			for i := 1; i <= 3; i++ {
+				require.NoError(t, s.parseStatsdLine(fmt.Sprintf("requests:%d|c", 10*i)))
+				s.lastGatherTime = time.Now().Add(-10 * time.Second)
+
+				var acc testutil.Accumulator
+				require.NoError(t, s.Gather(&acc))
+				metrics := acc.GetTelegrafMetrics()
+				require.Len(t, metrics, 1)
+				require.True(t, metrics[0].HasField("value"))
+				if rate, found := metrics[0].GetField("value_per_second"); found {
+					rates = append(rates, rate.(float64))
+				}
+			}
+
+			require.Len(t, rates, len(tt.expected))
+			for i, rate := range rates {
+				require.InDelta(t, tt.expected[i], rate, 0.01)
+			}
+		})
+	}
+}
+
+func TestCounterRateMirrorTemporality(t *testing.T) {
+	s := newTestStatsd()
+	s.CounterRate = true
+	s.MirrorTemporality = true
+
+	require.NoError(t, s.parseStatsdLine("requests:50|c"))
+	s.lastGatherTime = time.Now().Add(-10 * time.Second)
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	metrics := acc.GetTelegrafMetrics()
+	require.Len(t, metrics, 2)
+	for _, m := range metrics {
+		rate, found := m.GetField("value_per_second")
+		require.True(t, found, m.Tags()["temporality"])
+		require.InDelta(t, 5.0, rate, 0.01)
+	}
+}
+
+func TestMaxSetCardinality(t *testing.T) {
+	s := newTestStatsd()
+	s.MaxSetCardinality = 10
+	s.DeleteSets = true
+
+	for i := range 100 {
+		require.NoError(t, s.parseStatsdLine(fmt.Sprintf("users:user%d|s", i)))
+	}
+	// Known members are still accepted and other sets are not affected
+	require.NoError(t, s.parseStatsdLine("users:user0|s"))
+	require.NoError(t, s.parseStatsdLine("sessions:a|s"))
+
+	require.Len(t, s.sets, 2)
+	for _, cached := range s.sets {
+		if cached.name == "users" {
+			require.Len(t, cached.fields["value"], 10)
+			require.Equal(t, int64(101), cached.samples)
+		}
+	}
+	require.Equal(t, int64(90), s.Stats.SetCardinalityCapped.Get())
+	require.Equal(t, int64(90), s.Stats.Dropped["set_cardinality"].Get())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, s.Gather(acc))
+	acc.AssertContainsFields(t, "users", map[string]interface{}{"value": int64(10)})
+	acc.AssertContainsFields(t, "sessions", map[string]interface{}{"value": int64(1)})
+
+	// New members are accepted again after the set is reset
+	require.NoError(t, s.parseStatsdLine("users:user99|s"))
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(acc))
+	acc.AssertContainsFields(t, "users", map[string]interface{}{"value": int64(1)})
+	require.Equal(t, int64(90), s.Stats.SetCardinalityCapped.Get())
+}
+
+func TestMaxSetCardinalityInvalid(t *testing.T) {
+	plugin := &Statsd{
+		Log:               testutil.Logger{},
+		Protocol:          "udp",
+		ServiceAddress:    "localhost:0",
+		MaxSetCardinality: -1,
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, plugin.Start(&acc), "invalid max_set_cardinality -1")
+}
+
+func TestScaleSampledSets(t *testing.T) {
+	tests := []struct {
+		name     string
+		scale    bool
+		float    bool
+		expected interface{}
+	}{
+		{
+			name:     "disabled",
+			expected: int64(3),
+		},
+		{
+			name:     "enabled",
+			scale:    true,
+			expected: int64(13),
+		},
+		{
+			name:     "float sets",
+			scale:    true,
+			float:    true,
+			expected: float64(13),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.ScaleSampledSets = tt.scale
+			s.FloatSets = tt.float
+
+			lines := []string{
+				"users.unique:101|s|@0.1",
+				"users.unique:102|s",
+				"users.unique:103|s|@0.5",
+			}
+			for _, line := range lines {
+				require.NoError(t, s.parseStatsdLine(line))
+			}
+
+			var acc testutil.Accumulator
+			require.NoError(t, s.Gather(&acc))
+			m, found := acc.Get("users_unique")
+			require.True(t, found)
+			require.Equal(t, tt.expected, m.Fields["value"])
+		})
+	}
+}
+
+func TestScaleSampledSetsResample(t *testing.T) {
+	s := newTestStatsd()
+	s.ScaleSampledSets = true
+
+	// Members count with the sample rate of the last line received
+	require.NoError(t, s.parseStatsdLine("users.unique:101|s|@0.1"))
+	require.NoError(t, s.parseStatsdLine("users.unique:101|s"))
+	require.NoError(t, s.parseStatsdLine("users.unique:102|s|@0.25"))
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	m, found := acc.Get("users_unique")
+	require.True(t, found)
+	require.Equal(t, int64(5), m.Fields["value"])
+}
+
+func TestDebugRing(t *testing.T) {
+	tests := []struct {
+		name     string
+		buckets  []string
+		expected []string
+	}{
+		{
+			name:     "all buckets",
+			expected: []string{"cpu.load:3|g", "mem,host=a:4|g", "cpu.idle:5|g"},
+		},
+		{
+			name:     "filtered buckets",
+			buckets:  []string{"cpu.*"},
+			expected: []string{"cpu.load:2|g", "cpu.load:3|g", "cpu.idle:5|g"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := &Statsd{
+				Log:              testutil.Logger{},
+				Protocol:         "udp",
+				ServiceAddress:   "localhost:0",
+				DebugRingSize:    3,
+				DebugRingBuckets: tt.buckets,
+			}
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Start(&acc))
+			defer plugin.Stop()
+			require.Empty(t, plugin.RecentLines())
+
+			lines := []string{
+				"cpu.load:1|g",
+				"cpu.load:2|g",
+				"cpu.load:3|g",
+				"mem,host=a:4|g",
+				"cpu.idle:5|g",
+			}
+			for _, line := range lines {
+				require.NoError(t, plugin.parseStatsdLine(line))
+			}
+			require.Equal(t, tt.expected, plugin.RecentLines())
+		})
+	}
+}
+
+func TestDebugRingDisabled(t *testing.T) {
+	s := newTestStatsd()
+	require.NoError(t, s.parseStatsdLine("cpu.load:1|g"))
+	require.Nil(t, s.RecentLines())
+}
+
+func TestStartReadiness(t *testing.T) {
+	for _, protocol := range []string{"udp", "tcp"} {
+		t.Run(protocol, func(t *testing.T) {
+			for range 50 {
+				plugin := &Statsd{
+					Log:                    testutil.Logger{},
+					Protocol:               protocol,
+					ServiceAddress:         "localhost:0",
+					AllowedPendingMessages: 100,
+					MaxTCPConnections:      10,
+					NumberWorkerThreads:    4,
+				}
+				var acc testutil.Accumulator
+				require.NoError(t, plugin.Start(&acc))
+
+				// Send immediately after Start returned
+				var addr string
+				if protocol == "udp" {
+					addr = plugin.UDPlistener.LocalAddr().String()
+				} else {
+					addr = plugin.TCPlistener.Addr().String()
+				}
+				conn, err := net.Dial(protocol, addr)
+				require.NoError(t, err)
+				_, err = conn.Write([]byte("cpu.load:1|c\n"))
+				require.NoError(t, err)
+
+				require.Eventually(t, func() bool {
+					require.NoError(t, plugin.Gather(&acc))
+					return acc.HasMeasurement("cpu_load")
+				}, 5*time.Second, time.Millisecond)
+				require.NoError(t, conn.Close())
+				plugin.Stop()
+			}
+		})
+	}
+}
+
+func TestParse_DataDogTimestamp(t *testing.T) {
+	s := newTestStatsd()
+	s.DataDogExtensions = true
+	s.DataDogDistributions = true
+	s.DataDogKeepContainerTag = true
+
+	ts := time.Unix(1656581400, 0)
+	lines := []string{
+		"my_counter:1|c|#host:localhost|T1656581400",
+		"my_gauge:10.1|g|T1656581400|#live",
+		"my_set:1|s|c:abc|T1656581400",
+		"my_timer:3|ms|@0.1|T1656581400",
+		"my_distribution:5|d|T1656581400",
+		"no_timestamp:1|c",
+	}
+	for _, line := range lines {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	metrics := acc.GetTelegrafMetrics()
+	require.Len(t, metrics, len(lines))
+	for _, m := range metrics {
+		if m.Name() == "no_timestamp" {
+			require.NotEqual(t, ts, m.Time())
+			continue
+		}
+		require.Equal(t, ts, m.Time(), m.Name())
+	}
+
+	// The timestamp segment is removed before parsing the remaining segments
+	m, found := acc.Get("my_set")
+	require.True(t, found)
+	require.Equal(t, map[string]string{"metric_type": "set", "container": "abc"}, m.Tags)
+}
+
+func TestParse_DataDogTimestampWindow(t *testing.T) {
+	s := newTestStatsd()
+	s.DataDogExtensions = true
+	s.DataDogTimestampWindow = config.Duration(time.Hour)
+
+	now := time.Now()
+	valid := fmt.Sprintf("recent:1|c|T%d", now.Add(-30*time.Minute).Unix())
+	require.NoError(t, s.parseStatsdLine(valid))
+
+	invalid := []string{
+		fmt.Sprintf("past:1|c|T%d", now.Add(-2*time.Hour).Unix()),
+		fmt.Sprintf("future:1|c|T%d", now.Add(2*time.Hour).Unix()),
+		"malformed:1|c|Tabc",
+	}
+	for _, line := range invalid {
+		require.ErrorIs(t, s.parseStatsdLine(line), errParsing, line)
+	}
+	require.Len(t, s.counters, 1)
+
+	// Without extensions the timestamp is treated as sample rate
+	s.DataDogExtensions = false
+	require.NoError(t, s.parseStatsdLine(invalid[0]))
+}
+
+func TestMaxCachedMetrics(t *testing.T) {
+	s := newTestStatsd()
+	s.MaxCachedMetrics = 3
+
+	lines := []string{
+		"series.a:1|c",
+		"series.b:1|g",
+		"series.c:1|ms",
+		"series.a:1|c",
+		"series.d:1|s",
+		"series.e:1|h",
+	}
+	for _, line := range lines {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+	require.Equal(t, int64(2), s.Stats.CacheEvictions.Get())
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	names := make([]string, 0, len(acc.Metrics))
+	for _, m := range acc.Metrics {
+		names = append(names, m.Measurement)
+	}
+	require.ElementsMatch(t, []string{"series_a", "series_d", "series_e"}, names)
+
+	// Deleted series do not count against the limit
+	s.DeleteCounters = true
+	s.DeleteSets = true
+	s.DeleteTimings = true
+	require.NoError(t, s.Gather(&acc))
+	require.Equal(t, 0, s.series.len())
+	for _, line := range []string{"series.f:1|c", "series.g:1|c", "series.h:1|c"} {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+	require.Equal(t, int64(2), s.Stats.CacheEvictions.Get())
+	require.Len(t, s.counters, 3)
+}
+
+func TestMaxCachedMetricsInvalid(t *testing.T) {
+	statsd := Statsd{
+		Log:              testutil.Logger{},
+		Protocol:         "udp",
+		ServiceAddress:   "localhost:0",
+		MaxCachedMetrics: -1,
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), "invalid max_cached_metrics -1")
+}
+
+func TestNameMap(t *testing.T) {
+	s := newTestStatsd()
+	s.NameMap = map[string]string{"legacy_requests": "http_requests"}
+
+	name, _, _ := s.parseName("legacy.requests", "c")
+	require.Equal(t, "http_requests", name)
+	name, _, _ = s.parseName("other.requests", "c")
+	require.Equal(t, "other_requests", name)
+
+	// Legacy and canonical names are aggregated into the same series
+	lines := []string{
+		"legacy.requests,host=a:1|c",
+		"http.requests,host=a:2|c",
+		"other.requests,host=a:4|c",
+	}
+	for _, line := range lines {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+	require.Len(t, s.counters, 2)
+	require.NoError(t, testValidateCounter("http_requests", 3, s.counters))
+	require.NoError(t, testValidateCounter("other_requests", 4, s.counters))
+}
+
+func TestMetricNamePassDrop(t *testing.T) {
+	tests := []struct {
+		name     string
+		pass     []string
+		drop     []string
+		perType  bool
+		expected []string
+	}{
+		{
+			name:     "pass",
+			pass:     []string{"cpu_*"},
+			expected: []string{"cpu_load", "cpu_idle"},
+		},
+		{
+			name:     "drop",
+			drop:     []string{"cpu_?oad", "disk*"},
+			expected: []string{"cpu_idle", "mem_used"},
+		},
+		{
+			name:     "pass and drop",
+			pass:     []string{"cpu_*", "mem_*"},
+			drop:     []string{"*_idle"},
+			expected: []string{"cpu_load", "mem_used"},
+		},
+		{
+			name:     "measurement per type",
+			pass:     []string{"gauge_*"},
+			drop:     []string{"*_idle"},
+			perType:  true,
+			expected: []string{"gauge_cpu_load"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := &Statsd{
+				Log:                testutil.Logger{},
+				Protocol:           "udp",
+				ServiceAddress:     "localhost:0",
+				MetricNamePass:     tt.pass,
+				MetricNameDrop:     tt.drop,
+				MeasurementPerType: tt.perType,
+			}
+			var acc testutil.Accumulator
+			require.NoError(t, plugin.Start(&acc))
+			defer plugin.Stop()
+			// The statistics are shared by all instances on the same address
+			filtered := plugin.Stats.FilteredLines.Get()
+
+			lines := []string{
+				"cpu.load:1|g",
+				"cpu.idle:2|g",
+				"mem.used:3|c",
+				"disk.free:4|ms",
+			}
+			for _, line := range lines {
+				require.NoError(t, plugin.parseStatsdLine(line))
+			}
+			require.NoError(t, plugin.Gather(&acc))
+
+			names := make([]string, 0, len(acc.Metrics))
+			for _, m := range acc.Metrics {
+				names = append(names, m.Measurement)
+			}
+			require.ElementsMatch(t, tt.expected, names)
+			require.Equal(t, int64(len(lines)-len(tt.expected)), plugin.Stats.FilteredLines.Get()-filtered)
+		})
+	}
+}
+
+func TestReportFieldCounts(t *testing.T) {
+	s := newTestStatsd()
+	s.ReportFieldCounts = true
+	s.Templates = []string{"measurement.field"}
+
+	lines := []string{
+		"latency.db:1|ms",
+		"latency.cache:2|ms",
+		"latency.http:3|ms",
+		"latency.db:4|ms",
+		"latency.db,host=a:5|ms",
+		"load.cpu:1|g",
+	}
+	for _, line := range lines {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	acc.AssertContainsTaggedFields(t, "statsd_fields",
+		map[string]interface{}{"distinct": int64(3)},
+		map[string]string{"measurement": "latency"},
+	)
+	acc.AssertContainsTaggedFields(t, "statsd_fields",
+		map[string]interface{}{"distinct": int64(1)},
+		map[string]string{"measurement": "load"},
+	)
+}
+
+func TestDefaultTags(t *testing.T) {
+	s := newTestStatsd()
+	s.DataDogExtensions = true
+	s.DataDogDistributions = true
+	s.DefaultTags = map[string]string{"env": "prod", "region": "eu"}
+
+	lines := []string{
+		"requests:1|c",
+		"load:1|g",
+		"users:1|s",
+		"latency:1|ms",
+		"size:1|h",
+		"duration:1|d",
+	}
+	for _, line := range lines {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	require.Len(t, acc.Metrics, len(lines))
+	for _, m := range acc.Metrics {
+		require.Equal(t, "prod", m.Tags["env"], m.Measurement)
+		require.Equal(t, "eu", m.Tags["region"], m.Measurement)
+	}
+
+	// Client tags take precedence and the series stay distinct, the counter
+	// without client tags accumulates across both intervals
+	acc.ClearMetrics()
+	s.DeleteCounters = true
+	require.NoError(t, s.parseStatsdLine("requests:1|c"))
+	require.NoError(t, s.parseStatsdLine("requests,env=dev:2|c"))
+	require.NoError(t, s.parseStatsdLine("requests:4|c|#env:test"))
+	require.NoError(t, s.Gather(&acc))
+	for env, value := range map[string]int64{"prod": 2, "dev": 2, "test": 4} {
+		acc.AssertContainsTaggedFields(t, "requests",
+			map[string]interface{}{"value": value},
+			map[string]string{"metric_type": "counter", "env": env, "region": "eu"},
+		)
+	}
+}
+
+func TestMaxTagsetBytes(t *testing.T) {
+	// The tag set "metric_type=counter,host=a,path=/api/v1/users,region=eu"
+	// has 55 bytes
+	line := "requests,host=a,region=eu,path=/api/v1/users:1|c"
+
+	t.Run("drop", func(t *testing.T) {
+		s := newTestStatsd()
+		s.MaxTagsetBytes = 40
+		s.MaxTagsetAction = "drop"
+
+		require.NoError(t, s.parseStatsdLine(line))
+		require.NoError(t, s.parseStatsdLine("requests,host=a:1|c"))
+
+		var acc testutil.Accumulator
+		require.NoError(t, s.Gather(&acc))
+		require.Len(t, acc.Metrics, 1)
+		acc.AssertContainsTaggedFields(t, "requests",
+			map[string]interface{}{"value": int64(1)},
+			map[string]string{"metric_type": "counter", "host": "a"},
+		)
+		require.Equal(t, int64(1), s.Stats.OversizedTagsets.Get())
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		s := newTestStatsd()
+		s.MaxTagsetBytes = 40
+		s.MaxTagsetAction = "truncate"
+
+		// Removing the largest value "/api/v1/users" is sufficient
+		require.NoError(t, s.parseStatsdLine(line))
+		// Both "region" and "zone" hold values of equal size so "region" is
+		// removed as it sorts first
+		require.NoError(t, s.parseStatsdLine("latency,region=eu-west-1,zone=eu-west-2:1|c"))
+
+		var acc testutil.Accumulator
+		require.NoError(t, s.Gather(&acc))
+		require.Len(t, acc.Metrics, 2)
+		acc.AssertContainsTaggedFields(t, "requests",
+			map[string]interface{}{"value": int64(1)},
+			map[string]string{"metric_type": "counter", "host": "a", "region": "eu"},
+		)
+		acc.AssertContainsTaggedFields(t, "latency",
+			map[string]interface{}{"value": int64(1)},
+			map[string]string{"metric_type": "counter", "zone": "eu-west-2"},
+		)
+		require.Equal(t, int64(2), s.Stats.OversizedTagsets.Get())
+	})
+}
+
+func TestMaxTagsetBytesInvalid(t *testing.T) {
+	statsd := &Statsd{
+		Log:             testutil.Logger{},
+		Protocol:        "udp",
+		ServiceAddress:  "localhost:0",
+		MaxTagsetBytes:  10,
+		MaxTagsetAction: "shorten",
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), "invalid max_tagset_action")
+}
+
+func TestPercentileWarmup(t *testing.T) {
+	s := newTestStatsd()
+	s.Percentiles = []number{50}
+	s.PercentileWarmup = config.Duration(time.Hour)
+	s.DeleteTimings = true
+
+	require.NoError(t, s.parseStatsdLine("latency:10|ms"))
+	require.NoError(t, s.parseStatsdLine("latency:20|ms"))
+
+	// Percentiles are suppressed within the warm-up of the series
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	m, found := acc.Get("latency")
+	require.True(t, found)
+	require.Contains(t, m.Fields, "mean")
+	require.NotContains(t, m.Fields, "50_percentile")
+
+	// Percentiles are emitted after the warm-up even though the timings were
+	// reset, a series seen later starts its own warm-up
+	hash := s.seriesKey("latency", map[string]string{"metric_type": "timing"})
+	warmup := s.percentileWarmups[hash]
+	warmup.firstSeen = warmup.firstSeen.Add(-2 * time.Hour)
+	s.percentileWarmups[hash] = warmup
+
+	require.NoError(t, s.parseStatsdLine("latency:30|ms"))
+	require.NoError(t, s.parseStatsdLine("duration:30|ms"))
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	m, found = acc.Get("latency")
+	require.True(t, found)
+	require.InDelta(t, 30.0, m.Fields["50_percentile"], testutil.DefaultDelta)
+	m, found = acc.Get("duration")
+	require.True(t, found)
+	require.NotContains(t, m.Fields, "50_percentile")
+
+	// The first-seen time of a series missing for an interval is removed
+	// after the warm-up, so the series warms up again when seen later
+	require.NoError(t, s.Gather(&acc))
+	require.NotContains(t, s.percentileWarmups, hash)
+	require.Len(t, s.percentileWarmups, 1)
+
+	require.NoError(t, s.parseStatsdLine("latency:40|ms"))
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	m, found = acc.Get("latency")
+	require.True(t, found)
+	require.NotContains(t, m.Fields, "50_percentile")
+}
+
+func TestSourceIPTag(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		address  string
+		expected string
+	}{
+		{
+			name:     "udp",
+			protocol: "udp",
+			address:  "127.0.0.1:0",
+			expected: "127.0.0.1",
+		},
+		{
+			name:     "tcp",
+			protocol: "tcp",
+			address:  "127.0.0.1:0",
+			expected: "127.0.0.1",
+		},
+		{
+			name:     "udp6",
+			protocol: "udp6",
+			address:  "[::1]:0",
+			expected: "::1",
+		},
+		{
+			name:     "tcp6",
+			protocol: "tcp",
+			address:  "[::1]:0",
+			expected: "::1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statsd := &Statsd{
+				Log:                    testutil.Logger{},
+				Protocol:               tt.protocol,
+				ServiceAddress:         tt.address,
+				AllowedPendingMessages: 10,
+				MaxTCPConnections:      2,
+				NumberWorkerThreads:    1,
+				SourceIPTag:            "source_ip",
+			}
+			var acc testutil.Accumulator
+			if err := statsd.Start(&acc); err != nil {
+				if strings.HasPrefix(tt.expected, "::") {
+					t.Skipf("IPv6 not available: %v", err)
+				}
+				require.NoError(t, err)
+			}
+			defer statsd.Stop()
+
+			var addr string
+			if statsd.TCPlistener != nil {
+				addr = statsd.TCPlistener.Addr().String()
+			} else {
+				addr = statsd.UDPlistener.LocalAddr().String()
+			}
+			network := strings.TrimSuffix(tt.protocol, "6")
+			conn, err := net.Dial(network, addr)
+			require.NoError(t, err)
+			_, err = conn.Write([]byte("requests:1|c\nrequests,source_ip=client:1|c\n"))
+			require.NoError(t, err)
+			require.NoError(t, conn.Close())
+
+			require.Eventually(t, func() bool {
+				require.NoError(t, statsd.Gather(&acc))
+				return acc.NMetrics() >= 2
+			}, time.Second, 10*time.Millisecond)
+
+			// The tag is part of the series and client tags take precedence
+			acc.AssertContainsTaggedFields(t, "requests",
+				map[string]interface{}{"value": int64(1)},
+				map[string]string{"metric_type": "counter", "source_ip": tt.expected},
+			)
+			acc.AssertContainsTaggedFields(t, "requests",
+				map[string]interface{}{"value": int64(1)},
+				map[string]string{"metric_type": "counter", "source_ip": "client"},
+			)
+		})
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	s := newTestStatsd()
+	s.DataDogExtensions = true
+	s.DataDogDistributions = true
+	s.Percentiles = []number{50}
+	s.HistogramBuckets = []float64{1, 5, 10}
+
+	for _, v := range []string{"0.5", "1", "2", "5", "7", "20"} {
+		require.NoError(t, s.parseStatsdLine("latency:"+v+"|ms"))
+		require.NoError(t, s.parseStatsdLine("size:"+v+"|d"))
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+
+	expected := map[string]int64{"1": 2, "5": 4, "10": 5, "+Inf": 6}
+	for name, mtype := range map[string]string{"latency": "timing", "size": "distribution"} {
+		for le, count := range expected {
+			acc.AssertContainsTaggedFields(t, name,
+				map[string]interface{}{"bucket": count},
+				map[string]string{"metric_type": mtype, "le": le},
+			)
+		}
+	}
+
+	// Percentiles are emitted alongside the buckets
+	var found bool
+	for _, m := range acc.Metrics {
+		if m.Measurement == "latency" && m.Tags["le"] == "" {
+			require.Contains(t, m.Fields, "50_percentile")
+			found = true
+		}
+	}
+	require.True(t, found)
+}
+
+func TestHistogramBucketsNormalized(t *testing.T) {
+	statsd := &Statsd{
+		Log:              testutil.Logger{},
+		Protocol:         "udp",
+		ServiceAddress:   "localhost:0",
+		HistogramBuckets: []float64{10, 1, math.Inf(1), 5, 1},
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, statsd.Start(&acc))
+	defer statsd.Stop()
+	require.Equal(t, []float64{1, 5, 10}, statsd.HistogramBuckets)
+}
+
+func TestHistogramBucketsInvalid(t *testing.T) {
+	statsd := &Statsd{
+		Log:              testutil.Logger{},
+		Protocol:         "udp",
+		ServiceAddress:   "localhost:0",
+		HistogramBuckets: []float64{1, math.NaN()},
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), "invalid histogram_buckets")
+}
+
+func TestSourcePortTag(t *testing.T) {
+	for _, protocol := range []string{"udp", "tcp"} {
+		t.Run(protocol, func(t *testing.T) {
+			statsd := &Statsd{
+				Log:                    testutil.Logger{},
+				Protocol:               protocol,
+				ServiceAddress:         "127.0.0.1:0",
+				AllowedPendingMessages: 10,
+				MaxTCPConnections:      2,
+				NumberWorkerThreads:    1,
+				MaxTTL:                 config.Duration(time.Minute),
+				SourcePortTag:          "source_port",
+			}
+			var acc testutil.Accumulator
+			require.NoError(t, statsd.Start(&acc))
+			defer statsd.Stop()
+
+			var addr string
+			if statsd.TCPlistener != nil {
+				addr = statsd.TCPlistener.Addr().String()
+			} else {
+				addr = statsd.UDPlistener.LocalAddr().String()
+			}
+
+			// Send the same metric from two sockets with distinct ports
+			ports := make(map[string]bool, 2)
+			for range 2 {
+				conn, err := net.Dial(protocol, addr)
+				require.NoError(t, err)
+				_, port, err := net.SplitHostPort(conn.LocalAddr().String())
+				require.NoError(t, err)
+				ports[port] = true
+				_, err = conn.Write([]byte("requests:1|c\n"))
+				require.NoError(t, err)
+				require.NoError(t, conn.Close())
+			}
+			require.Len(t, ports, 2)
+
+			require.Eventually(t, func() bool {
+				acc.ClearMetrics()
+				require.NoError(t, statsd.Gather(&acc))
+				return acc.NMetrics() == 2
+			}, time.Second, 10*time.Millisecond)
+			for port := range ports {
+				acc.AssertContainsTaggedFields(t, "requests",
+					map[string]interface{}{"value": int64(1)},
+					map[string]string{"metric_type": "counter", "source_port": port},
+				)
+			}
+		})
+	}
+}
+
+func TestDrainOnStop(t *testing.T) {
+	for _, drain := range []bool{false, true} {
+		t.Run(fmt.Sprintf("drain=%v", drain), func(t *testing.T) {
+			s := newTestStatsd()
+			s.DrainOnStop = drain
+			s.in = make(chan input, 10)
+			var acc testutil.Accumulator
+			s.acc = &acc
+
+			// Queue messages without parsers running, the drained metrics
+			// are emitted on stop as the agent does not gather afterwards
+			for i := range 3 {
+				s.in <- input{Buffer: bytes.NewBufferString(fmt.Sprintf("requests:%d|c\n", i+1)), Time: time.Now()}
+			}
+			s.Stop()
+
+			if !drain {
+				require.Empty(t, acc.Metrics)
+				return
+			}
+			acc.AssertContainsTaggedFields(t, "requests",
+				map[string]interface{}{"value": int64(6)},
+				map[string]string{"metric_type": "counter"},
+			)
+		})
+	}
+}
+
+func TestExemplarTag(t *testing.T) {
+	s := newTestStatsd()
+	s.DataDogExtensions = true
+	s.ExemplarTag = "trace_id"
+	s.DeleteTimings = true
+
+	require.NoError(t, s.parseStatsdLine("latency:10|ms|#trace_id:abc,host:a"))
+	require.NoError(t, s.parseStatsdLine("latency:20|ms|#host:a"))
+	require.NoError(t, s.parseStatsdLine("latency:30|ms|#trace_id:def,host:a"))
+	require.NoError(t, s.parseStatsdLine("requests:1|c|#trace_id:abc"))
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+
+	// The trace tag does not split the timing series and the latest sample
+	// with a trace is kept as exemplar, other types keep the tag
+	var found bool
+	for _, m := range acc.Metrics {
+		switch m.Measurement {
+		case "latency":
+			require.NotContains(t, m.Tags, "trace_id")
+			require.Equal(t, int64(3), m.Fields["count"])
+			require.InDelta(t, 30.0, m.Fields["exemplar_value"], testutil.DefaultDelta)
+			require.Equal(t, "def", m.Fields["exemplar_trace_id"])
+			found = true
+		case "requests":
+			require.Equal(t, "abc", m.Tags["trace_id"])
+		}
+	}
+	require.True(t, found)
+
+	// Exemplars are reset with the timings
+	require.NoError(t, s.parseStatsdLine("latency:40|ms|#host:a"))
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	m, ok := acc.Get("latency")
+	require.True(t, ok)
+	require.NotContains(t, m.Fields, "exemplar_value")
+}
+
+func TestTrimSeparators(t *testing.T) {
+	tests := []struct {
+		bucket   string
+		expected string
+	}{
+		{bucket: ".api.calls", expected: "api_calls"},
+		{bucket: "api.calls.", expected: "api_calls"},
+		{bucket: "..api.calls..", expected: "api_calls"},
+		{bucket: "api..calls", expected: "api__calls"},
+		{bucket: "api.calls", expected: "api_calls"},
+		{bucket: "_api.calls_", expected: "_api_calls_"},
+		{bucket: "._api.calls_.", expected: "_api_calls_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.bucket, func(t *testing.T) {
+			s := newTestStatsd()
+			s.TrimSeparators = true
+			name, _, _ := s.parseName(tt.bucket, "c")
+			require.Equal(t, tt.expected, name)
+		})
+	}
+
+	// Names differing only by leading or trailing separators form one series
+	s := newTestStatsd()
+	s.TrimSeparators = true
+	require.NoError(t, s.parseStatsdLine(".api.calls.:1|c"))
+	require.NoError(t, s.parseStatsdLine("api.calls:2|c"))
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	require.Len(t, acc.Metrics, 1)
+	acc.AssertContainsFields(t, "api_calls", map[string]interface{}{"value": int64(3)})
+
+	// Disabled trimming keeps the separators
+	s = newTestStatsd()
+	name, _, _ := s.parseName(".api.calls.", "c")
+	require.Equal(t, "_api_calls_", name)
+}
+
+func TestTCPMaxLineSize(t *testing.T) {
+	// Line exceeding the default token size of the scanner
+	value := strings.Repeat("x", 2*bufio.MaxScanTokenSize)
+	line := "requests,payload=" + value + ":1|c\n"
+
+	for _, maxSize := range []int{0, 4 * bufio.MaxScanTokenSize} {
+		t.Run(fmt.Sprintf("max=%d", maxSize), func(t *testing.T) {
+			logger := &testutil.CaptureLogger{}
+			statsd := &Statsd{
+				Log:                    logger,
+				Protocol:               "tcp",
+				ServiceAddress:         "localhost:0",
+				AllowedPendingMessages: 10,
+				MaxTCPConnections:      2,
+				NumberWorkerThreads:    1,
+				TCPMaxLineSize:         maxSize,
+			}
+			var acc testutil.Accumulator
+			require.NoError(t, statsd.Start(&acc))
+			defer statsd.Stop()
+
+			conn, err := net.Dial("tcp", statsd.TCPlistener.Addr().String())
+			require.NoError(t, err)
+			_, err = conn.Write([]byte(line))
+			require.NoError(t, err)
+			require.NoError(t, conn.Close())
+
+			if maxSize == 0 {
+				require.Eventually(t, func() bool {
+					return len(logger.Errors()) > 0
+				}, time.Second, 10*time.Millisecond)
+				require.Contains(t, logger.Errors()[0], "tcp_max_line_size")
+				require.NoError(t, statsd.Gather(&acc))
+				require.Empty(t, acc.Metrics)
+				return
+			}
+			require.Eventually(t, func() bool {
+				require.NoError(t, statsd.Gather(&acc))
+				return acc.NMetrics() > 0
+			}, time.Second, 10*time.Millisecond)
+			acc.AssertContainsTaggedFields(t, "requests",
+				map[string]interface{}{"value": int64(1)},
+				map[string]string{"metric_type": "counter", "payload": value},
+			)
+		})
+	}
+}
+
+func TestTCPMaxLineSizeInvalid(t *testing.T) {
+	statsd := &Statsd{
+		Log:            testutil.Logger{},
+		Protocol:       "tcp",
+		ServiceAddress: "localhost:0",
+		TCPMaxLineSize: -1,
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), "invalid tcp_max_line_size")
+}
+
+func TestHotSeriesReporting(t *testing.T) {
+	logger := &testutil.CaptureLogger{}
+	s := newTestStatsd()
+	s.Log = logger
+	s.EmitHotSeries = true
+	s.HotSeriesCount = 2
+	s.LogHotSeriesInterval = config.Duration(time.Hour)
+
+	for range 10 {
+		require.NoError(t, s.parseStatsdLine("requests,host=a:1|c"))
+	}
+	for range 3 {
+		require.NoError(t, s.parseStatsdLine("latency:1|ms"))
+	}
+	require.NoError(t, s.parseStatsdLine("requests,host=b:1|c"))
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	acc.AssertContainsTaggedFields(t, "statsd_hot_series",
+		map[string]interface{}{"updates": int64(10)},
+		map[string]string{"series": "requests,host=a,metric_type=counter", "rank": "1"},
+	)
+	acc.AssertContainsTaggedFields(t, "statsd_hot_series",
+		map[string]interface{}{"updates": int64(3)},
+		map[string]string{"series": "latency,metric_type=timing", "rank": "2"},
+	)
+	require.Len(t, acc.GetTelegrafMetrics(), 5)
+	require.Equal(t, 1, logger.NMessages())
+	require.Contains(t, logger.Messages()[0].Text, "requests,host=a,metric_type=counter (10)")
+
+	// The counts are reset each interval and logging is limited to the
+	// logging interval
+	require.NoError(t, s.parseStatsdLine("requests,host=b:1|c"))
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	acc.AssertContainsTaggedFields(t, "statsd_hot_series",
+		map[string]interface{}{"updates": int64(1)},
+		map[string]string{"series": "requests,host=b,metric_type=counter", "rank": "1"},
+	)
+	require.Equal(t, 1, logger.NMessages())
+}
+
+func TestTCPIdleTimeout(t *testing.T) {
+	statsd := &Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "tcp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 10,
+		MaxTCPConnections:      1,
+		NumberWorkerThreads:    1,
+		TCPIdleTimeout:         config.Duration(100 * time.Millisecond),
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, statsd.Start(&acc))
+	defer statsd.Stop()
+	addr := statsd.TCPlistener.Addr().String()
+
+	// The statistics are shared by all instances using the same address
+	closed := statsd.Stats.IdleConnectionsClosed.Get()
+
+	// Sending data restarts the timeout, staying idle closes the connection
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	for range 3 {
+		_, err = conn.Write([]byte("requests:1|c\n"))
+		require.NoError(t, err)
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.Eventually(t, func() bool {
+		return statsd.Stats.CurrentConnections.Get() == 0
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(1), statsd.Stats.IdleConnectionsClosed.Get()-closed)
+
+	// The slot is returned, so a new connection is accepted
+	conn2, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn2.Close()
+	_, err = conn2.Write([]byte("requests:1|c\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		acc.ClearMetrics()
+		require.NoError(t, statsd.Gather(&acc))
+		m, found := acc.Get("requests")
+		return found && m.Fields["value"] == int64(4)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCoalesceCounters(t *testing.T) {
+	payload := strings.Join([]string{
+		"requests:1|c",
+		"requests:2|c|@0.5",
+		"requests,host=a:1|c",
+		"latency:10|ms",
+		"requests:3|c",
+	}, "\n")
+
+	var expected []telegraf.Metric
+	for _, coalesce := range []bool{false, true} {
+		s := newTestStatsd()
+		s.CoalesceCounters = coalesce
+		s.EmitSampleCount = true
+		require.NoError(t, s.parseInput(input{Buffer: bytes.NewBufferString(payload), Time: time.Now()}))
+
+		var acc testutil.Accumulator
+		require.NoError(t, s.Gather(&acc))
+		acc.AssertContainsTaggedFields(t, "requests",
+			map[string]interface{}{"value": int64(8), "samples": int64(3)},
+			map[string]string{"metric_type": "counter"},
+		)
+
+		// Coalescing does not change the emitted metrics
+		if !coalesce {
+			expected = acc.GetTelegrafMetrics()
+			continue
+		}
+		testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime(), testutil.SortMetrics())
+	}
+}
+
+func BenchmarkCoalesceCounters(b *testing.B) {
+	payload := strings.Repeat("requests,host=a:1|c\n", 1000)
+	for _, coalesce := range []bool{false, true} {
+		b.Run(fmt.Sprintf("coalesce=%v", coalesce), func(b *testing.B) {
+			s := newTestStatsd()
+			s.CoalesceCounters = coalesce
+
+			// Parse concurrently like the parser workers competing for the
+			// lock
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					in := input{Buffer: bytes.NewBufferString(payload), Time: time.Now()}
+					if err := s.parseInput(in); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestDefaultFieldName(t *testing.T) {
+	s := newTestStatsd()
+	s.DefaultFieldName = "gauge"
+	s.Templates = []string{"measurement.field"}
+
+	lines := []string{
+		"requests:1|c",
+		"load:2|g",
+		"latency:10|ms",
+		"cpu.idle:20|ms",
+	}
+	for _, line := range lines {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	acc.AssertContainsFields(t, "requests", map[string]interface{}{"gauge": int64(1)})
+	acc.AssertContainsFields(t, "load", map[string]interface{}{"gauge": float64(2)})
+
+	// Timings of the default field are not prefixed, other fields are
+	m, found := acc.Get("latency")
+	require.True(t, found)
+	require.Contains(t, m.Fields, "mean")
+	require.NotContains(t, m.Fields, "gauge_mean")
+	m, found = acc.Get("cpu")
+	require.True(t, found)
+	require.Contains(t, m.Fields, "idle_mean")
+
+	// The name is used for single field naming as well
+	s = newTestStatsd()
+	s.DefaultFieldName = "gauge"
+	s.SingleFieldNaming = "name"
+	require.NoError(t, s.parseStatsdLine("requests:1|c"))
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	acc.AssertContainsFields(t, "requests", map[string]interface{}{"requests": int64(1)})
+}
+
+func TestNormalizeSourceAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrs    []string
+		expected string
+	}{
+		{
+			name: "ipv6",
+			addrs: []string{
+				"2001:db8::1",
+				"2001:DB8::1",
+				"2001:0db8:0000:0000:0000:0000:0000:0001",
+				"[2001:db8::1]",
+				"2001:db8::1%eth0",
+				"[2001:db8::1%25eth0]",
+			},
+			expected: "2001:db8::1",
+		},
+		{
+			name: "ipv4-mapped",
+			addrs: []string{
+				"192.0.2.1",
+				"::ffff:192.0.2.1",
+				"::FFFF:c000:0201",
+				"[::ffff:192.0.2.1]",
+			},
+			expected: "192.0.2.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.SourceIPTag = "source_ip"
+			for _, addr := range tt.addrs {
+				in := input{Buffer: bytes.NewBufferString("requests:1|c\n"), Time: time.Now(), Addr: addr}
+				require.NoError(t, s.parseInput(in))
+			}
+
+			// All representations result in a single series
+			var acc testutil.Accumulator
+			require.NoError(t, s.Gather(&acc))
+			require.Len(t, acc.Metrics, 1)
+			acc.AssertContainsTaggedFields(t, "requests",
+				map[string]interface{}{"value": int64(len(tt.addrs))},
+				map[string]string{"metric_type": "counter", "source_ip": tt.expected},
+			)
+		})
+	}
+
+	// Invalid addresses are kept as-is
+	require.Equal(t, "unknown", normalizeAddr("unknown"))
+}
+
+func TestEmitSeriesID(t *testing.T) {
+	lines := []string{
+		"requests,a=1,b=2:1|c",
+		"requests,b=2,a=1:1|c",
+		"requests:1|c|#b:2,a:1",
+	}
+
+	for _, mode := range []string{"string", "xxhash"} {
+		t.Run(mode, func(t *testing.T) {
+			ids := make([]string, 0, len(lines))
+			for _, line := range lines {
+				s := newTestStatsd()
+				s.DataDogExtensions = true
+				s.SeriesKeyHash = mode
+				s.EmitSeriesID = true
+				require.NoError(t, s.parseStatsdLine(line))
+
+				var acc testutil.Accumulator
+				require.NoError(t, s.Gather(&acc))
+				m, found := acc.Get("requests")
+				require.True(t, found)
+				require.Len(t, m.Tags["series_id"], 16)
+				ids = append(ids, m.Tags["series_id"])
+
+				// The series ID matches the internal key in xxhash mode
+				if mode == "xxhash" {
+					for hash := range s.counters {
+						require.Equal(t, hex.EncodeToString([]byte(hash)), m.Tags["series_id"])
+					}
+				}
+			}
+			require.Equal(t, ids[0], ids[1])
+			require.Equal(t, ids[0], ids[2])
+		})
+	}
+
+	// Different tags result in different IDs
+	s := newTestStatsd()
+	s.EmitSeriesID = true
+	require.NoError(t, s.parseStatsdLine("requests,a=1:1|c"))
+	require.NoError(t, s.parseStatsdLine("requests,a=2:1|c"))
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	require.Len(t, acc.Metrics, 2)
+	require.NotEqual(t, acc.Metrics[0].Tags["series_id"], acc.Metrics[1].Tags["series_id"])
+}
+
+func TestSeriesCounts(t *testing.T) {
+	s := newTestStatsd()
+	s.ReportSeriesCounts = true
+
+	for i := range 3 {
+		require.NoError(t, s.parseStatsdLine(fmt.Sprintf("load,host=h%d:1|g", i)))
+	}
+	for i := range 5 {
+		require.NoError(t, s.parseStatsdLine(fmt.Sprintf("requests,host=h%d:1|c", i)))
+	}
+	require.NoError(t, s.parseStatsdLine("users:1|s"))
+	for i := range 2 {
+		require.NoError(t, s.parseStatsdLine(fmt.Sprintf("latency,host=h%d:1|ms", i)))
+	}
+	// Updates of an existing series are not counted
+	require.NoError(t, s.parseStatsdLine("requests,host=h0:1|c"))
+
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	acc.AssertContainsTaggedFields(t, "statsd_series",
+		map[string]interface{}{
+			"gauges":   int64(3),
+			"counters": int64(5),
+			"sets":     int64(1),
+			"timings":  int64(2),
+		},
+		map[string]string{"address": s.ServiceAddress},
+	)
+	require.Equal(t, int64(3), s.Stats.SeriesGauges.Get())
+	require.Equal(t, int64(5), s.Stats.SeriesCounters.Get())
+	require.Equal(t, int64(1), s.Stats.SeriesSets.Get())
+	require.Equal(t, int64(2), s.Stats.SeriesTimings.Get())
+
+	// The counts drop after deleting the series
+	s.DeleteCounters = true
+	require.NoError(t, s.Gather(&acc))
+	require.NoError(t, s.Gather(&acc))
+	require.Equal(t, int64(0), s.Stats.SeriesCounters.Get())
+	require.Equal(t, int64(3), s.Stats.SeriesGauges.Get())
+}
+
+func TestPause(t *testing.T) {
+	s := newTestStatsd()
+	s.PauseMode = "accumulate"
+	require.NoError(t, s.parseStatsdLine("requests:1|c"))
+
+	// Paused gathers emit nothing but the metrics are still accumulated
+	s.Pause()
+	require.NoError(t, s.parseStatsdLine("requests:2|c"))
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	require.Empty(t, acc.GetTelegrafMetrics())
+	require.NoError(t, s.parseStatsdLine("requests:3|c"))
+	require.NoError(t, s.Gather(&acc))
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	s.Resume()
+	require.NoError(t, s.Gather(&acc))
+	acc.AssertContainsFields(t, "requests", map[string]interface{}{"value": int64(6)})
+}
+
+func TestPauseDiscard(t *testing.T) {
+	s := newTestStatsd()
+	s.PauseMode = "discard"
+	s.DeleteCounters = true
+
+	parse := func(payload string) {
+		require.NoError(t, s.parseInput(input{Buffer: bytes.NewBufferString(payload), Time: time.Now()}))
+	}
+	parse("requests:1|c")
+	s.Pause()
+	parse("requests:2|c")
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	s.Resume()
+	parse("requests:4|c")
+	require.NoError(t, s.Gather(&acc))
+	acc.AssertContainsFields(t, "requests", map[string]interface{}{"value": int64(5)})
+}
+
+func TestPauseWindows(t *testing.T) {
+	w, err := parsePauseWindow("23:30-00:30")
+	require.NoError(t, err)
+	day := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	require.True(t, w.contains(day(23, 45)))
+	require.True(t, w.contains(day(0, 15)))
+	require.False(t, w.contains(day(0, 30)))
+	require.False(t, w.contains(day(12, 0)))
+
+	w, err = parsePauseWindow("02:00-03:00")
+	require.NoError(t, err)
+	require.True(t, w.contains(day(2, 0)))
+	require.False(t, w.contains(day(3, 0)))
+	require.False(t, w.contains(day(1, 59)))
+
+	for _, window := range []string{"02:00", "2am-3am", "02:00-02:00", "25:00-03:00"} {
+		statsd := &Statsd{
+			Log:            testutil.Logger{},
+			Protocol:       "udp",
+			ServiceAddress: "localhost:0",
+			PauseWindows:   []string{window},
+		}
+		var acc testutil.Accumulator
+		require.ErrorContains(t, statsd.Start(&acc), "invalid pause_windows", window)
+	}
+}
+
+func TestTagPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		strip    bool
+		line     string
+		expected string
+		tags     map[string]string
+	}{
+		{
+			name:     "extract",
+			line:     "myapp.requests.eu:1|c",
+			expected: "myapp_requests_eu",
+			tags:     map[string]string{"service": "myapp", "region": "eu", "metric_type": "counter"},
+		},
+		{
+			name:     "strip",
+			strip:    true,
+			line:     "myapp.requests.eu:1|c",
+			expected: "requests",
+			tags:     map[string]string{"service": "myapp", "region": "eu", "metric_type": "counter"},
+		},
+		{
+			name:     "partial match",
+			strip:    true,
+			line:     "myapp.requests:1|c",
+			expected: "requests",
+			tags:     map[string]string{"service": "myapp", "metric_type": "counter"},
+		},
+		{
+			name:     "explicit tag wins",
+			line:     "myapp.requests,service=other:1|c",
+			expected: "myapp_requests",
+			tags:     map[string]string{"service": "other", "metric_type": "counter"},
+		},
+		{
+			name:     "no match",
+			strip:    true,
+			line:     "MyApp:1|c",
+			expected: "MyApp",
+			tags:     map[string]string{"metric_type": "counter"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.TagPatternsStrip = tt.strip
+			s.tagPatterns = []*regexp.Regexp{
+				regexp.MustCompile(`^(?P<service>[a-z]+)\.`),
+				regexp.MustCompile(`\.(?P<region>eu|us)$`),
+			}
+			require.NoError(t, s.parseStatsdLine(tt.line))
+
+			var acc testutil.Accumulator
+			require.NoError(t, s.Gather(&acc))
+			acc.AssertContainsTaggedFields(t, tt.expected, map[string]interface{}{"value": int64(1)}, tt.tags)
+		})
+	}
+}
+
+func TestTagPatternsInvalid(t *testing.T) {
+	for _, pattern := range []string{`^([a-z]+)\.`, `^(?P<service>[a-z]+`} {
+		statsd := &Statsd{
+			Log:            testutil.Logger{},
+			Protocol:       "udp",
+			ServiceAddress: "localhost:0",
+			TagPatterns:    []string{pattern},
+		}
+		var acc testutil.Accumulator
+		require.ErrorContains(t, statsd.Start(&acc), "invalid tag_patterns", pattern)
+	}
+}
+
+func TestSanitizeTags(t *testing.T) {
+	s := newTestStatsd()
+	s.DataDogExtensions = true
+	s.SanitizeTags = true
+	s.DefaultTags = map[string]string{"default tag": "a/b"}
+
+	require.NoError(t, s.parseStatsdLine("requests,my key=a b,path/to=x/y,!!!=z:1|c|#dd key:v!al/ue,empty:???,flag"))
+	require.Len(t, s.counters, 1)
+	for _, m := range s.counters {
+		require.Equal(t, map[string]string{
+			"metric_type": "counter",
+			"my_key":      "a_b",
+			"path-to":     "x-y",
+			"dd_key":      "val-ue",
+			"flag":        "true",
+			"default tag": "a/b",
+		}, m.tags)
+	}
+
+	// Tags are left untouched by default
+	s = newTestStatsd()
+	s.DataDogExtensions = true
+	require.NoError(t, s.parseStatsdLine("requests,my key=a b:1|c|#dd key:v!al"))
+	for _, m := range s.counters {
+		require.Equal(t, "a b", m.tags["my key"])
+		require.Equal(t, "v!al", m.tags["dd key"])
+	}
+}
+
+func TestGaugeSampleRate(t *testing.T) {
+	tests := []struct {
+		mode     string
+		expected float64
+		errors   int
+	}{
+		{mode: "ignore", expected: 15},
+		{mode: "scale", expected: 20},
+		{mode: "reject", expected: 10, errors: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			logger := &testutil.CaptureLogger{}
+			s := newTestStatsd()
+			s.Log = logger
+			s.GaugeSampleRate = tt.mode
+
+			require.NoError(t, s.parseStatsdLine("gauge:10|g|@0.5"))
+			err := s.parseStatsdLine("gauge:+5|g|@0.5")
+			if tt.errors > 0 {
+				require.ErrorIs(t, err, errParsing)
+			} else {
+				require.NoError(t, err)
+			}
+			// Additive gauges without a sample rate are not affected
+			require.NoError(t, s.parseStatsdLine("other:+5|g"))
+
+			var acc testutil.Accumulator
+			require.NoError(t, s.Gather(&acc))
+			acc.AssertContainsFields(t, "gauge", map[string]interface{}{"value": tt.expected})
+			acc.AssertContainsFields(t, "other", map[string]interface{}{"value": float64(5)})
+			require.Len(t, logger.Errors(), tt.errors)
+		})
+	}
+}
+
+func TestGaugeSampleRateWarnOnce(t *testing.T) {
+	logger := &testutil.CaptureLogger{}
+	s := newTestStatsd()
+	s.Log = logger
+
+	require.NoError(t, s.parseStatsdLine("gauge:+5|g|@0.5"))
+	require.NoError(t, s.parseStatsdLine("gauge:+5|g|@0.1"))
+	require.NoError(t, s.parseStatsdLine("gauge:+5|g|@1"))
+	require.Len(t, logger.Warnings(), 1)
+	require.Contains(t, logger.Warnings()[0], "gauge_sample_rate")
+}
+
+func TestGaugeSampleRateInvalid(t *testing.T) {
+	statsd := &Statsd{
+		Log:             testutil.Logger{},
+		Protocol:        "udp",
+		ServiceAddress:  "localhost:0",
+		GaugeSampleRate: "double",
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), "invalid gauge_sample_rate")
+}
+
+func TestCumulativeTimings(t *testing.T) {
+	s := newTestStatsd()
+	s.CumulativeTimings = true
+	s.DeleteTimings = true
+	s.DeleteCounters = true
+	s.Percentiles = []number{90}
+
+	require.NoError(t, s.parseStatsdLine("latency:10|ms"))
+	require.NoError(t, s.parseStatsdLine("latency:50|ms"))
+	require.NoError(t, s.parseStatsdLine("requests:1|c"))
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	acc.AssertContainsFields(t, "latency", map[string]interface{}{
+		"lower":         float64(10),
+		"upper":         float64(50),
+		"mean":          float64(30),
+		"median":        float64(30),
+		"stddev":        float64(20),
+		"sum":           float64(60),
+		"count":         int64(2),
+		"90_percentile": float64(50),
+	})
+
+	// The aggregates persist while the percentiles only cover the interval
+	acc.ClearMetrics()
+	require.NoError(t, s.parseStatsdLine("latency:20|ms"))
+	require.NoError(t, s.Gather(&acc))
+	m, found := acc.Get("latency")
+	require.True(t, found)
+	require.Equal(t, float64(10), m.Fields["lower"])
+	require.Equal(t, float64(50), m.Fields["upper"])
+	require.Equal(t, float64(80), m.Fields["sum"])
+	require.Equal(t, int64(3), m.Fields["count"])
+	require.Equal(t, float64(20), m.Fields["median"])
+	require.Equal(t, float64(20), m.Fields["90_percentile"])
+	// Counters are still reset
+	require.False(t, acc.HasMeasurement("requests"))
+
+	// Series without values in the interval only emit the aggregates
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	m, found = acc.Get("latency")
+	require.True(t, found)
+	require.Equal(t, float64(50), m.Fields["upper"])
+	require.NotContains(t, m.Fields, "median")
+	require.NotContains(t, m.Fields, "90_percentile")
+}
+
+func TestMetricNamePrefixSuffix(t *testing.T) {
+	tests := []struct {
+		name      string
+		prefix    string
+		suffix    string
+		separator string
+		expected  string
+	}{
+		{name: "prefix", prefix: "app1", separator: "_", expected: "app1_cpu_idle"},
+		{name: "suffix", suffix: "app1", separator: "_", expected: "cpu_idle_app1"},
+		{name: "both", prefix: "prod", suffix: "app1", separator: "_", expected: "prod_cpu_idle_app1"},
+		{name: "dot separator", prefix: "prod", suffix: "app1", separator: ".", expected: "prod.cpu.idle.app1"},
+		{name: "dash separator", prefix: "prod", separator: "-", expected: "prod-cpu-idle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			s.MetricSeparator = tt.separator
+			s.MetricNamePrefix = tt.prefix
+			s.MetricNameSuffix = tt.suffix
+			require.NoError(t, s.parseStatsdLine("cpu.idle:1|c"))
+
+			var acc testutil.Accumulator
+			require.NoError(t, s.Gather(&acc))
+			acc.AssertContainsFields(t, tt.expected, map[string]interface{}{"value": int64(1)})
+		})
+	}
+
+	// The prefix is part of the series key, so buckets already carrying the
+	// prefix are not merged with the prefixed ones
+	s := newTestStatsd()
+	s.MetricNamePrefix = "app1"
+	require.NoError(t, s.parseStatsdLine("app1_requests:1|c"))
+	require.NoError(t, s.parseStatsdLine("requests:2|c"))
+	require.Len(t, s.counters, 2)
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	acc.AssertContainsFields(t, "app1_app1_requests", map[string]interface{}{"value": int64(1)})
+	acc.AssertContainsFields(t, "app1_requests", map[string]interface{}{"value": int64(2)})
+}
+
+func TestTCPChunkSize(t *testing.T) {
+	statsd := &Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "tcp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 10,
+		MaxTCPConnections:      2,
+		NumberWorkerThreads:    2,
+		TCPChunkSize:           16,
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, statsd.Start(&acc))
+	defer statsd.Stop()
+
+	// Lines span multiple chunks and the last line is not terminated
+	conn, err := net.Dial("tcp", statsd.TCPlistener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("requests:1|c\nrequests:2|c\r\nlatency,host=a:10|ms\n\nrequests:3|c"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool {
+		require.NoError(t, statsd.Gather(&acc))
+		m, found := acc.Get("requests")
+		return found && m.Fields["value"] == int64(6)
+	}, time.Second, 10*time.Millisecond)
+	acc.AssertContainsTaggedFields(t, "latency",
+		map[string]interface{}{
+			"lower":  float64(10),
+			"upper":  float64(10),
+			"mean":   float64(10),
+			"median": float64(10),
+			"stddev": float64(0),
+			"sum":    float64(10),
+			"count":  int64(1),
+		},
+		map[string]string{"metric_type": "timing", "host": "a"},
+	)
+}
+
+func TestTCPChunkSizeMaxLineSize(t *testing.T) {
+	logger := &testutil.CaptureLogger{}
+	statsd := &Statsd{
+		Log:                    logger,
+		Protocol:               "tcp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 10,
+		MaxTCPConnections:      2,
+		NumberWorkerThreads:    1,
+		TCPChunkSize:           16,
+		TCPMaxLineSize:         64,
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, statsd.Start(&acc))
+	defer statsd.Stop()
+
+	conn, err := net.Dial("tcp", statsd.TCPlistener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("requests,payload=" + strings.Repeat("x", 100) + ":1|c\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(logger.Errors()) > 0
+	}, time.Second, 10*time.Millisecond)
+	require.Contains(t, logger.Errors()[0], "tcp_max_line_size")
+	require.NoError(t, statsd.Gather(&acc))
+	require.Empty(t, acc.Metrics)
+}
+
+func TestTCPChunkSizeInvalid(t *testing.T) {
+	statsd := &Statsd{
+		Log:            testutil.Logger{},
+		Protocol:       "tcp",
+		ServiceAddress: "localhost:0",
+		TCPChunkSize:   -1,
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, statsd.Start(&acc), "invalid tcp_chunk_size")
+}
+
+func BenchmarkTCPChunkSize(b *testing.B) {
+	payload := []byte(strings.Repeat("requests,host=a,region=eu-west-1:1|c\n", 1000))
+	for _, chunkSize := range []int{0, 64 * 1024} {
+		b.Run(fmt.Sprintf("chunk_size=%d", chunkSize), func(b *testing.B) {
+			statsd := &Statsd{
+				Log:                    testutil.Logger{},
+				Protocol:               "tcp",
+				ServiceAddress:         "localhost:0",
+				AllowedPendingMessages: 1000000,
+				MaxTCPConnections:      2,
+				NumberWorkerThreads:    runtime.GOMAXPROCS(0),
+				TCPChunkSize:           chunkSize,
+			}
+			acc := &testutil.Accumulator{Discard: true}
+			require.NoError(b, statsd.Start(acc))
+			defer statsd.Stop()
+
+			conn, err := net.Dial("tcp", statsd.TCPlistener.Addr().String())
+			require.NoError(b, err)
+			defer conn.Close()
+
+			// Send all lines over a single connection and wait until they
+			// are parsed
+			b.ResetTimer()
+			for range b.N {
+				_, err := conn.Write(payload)
+				require.NoError(b, err)
+			}
+			expected := int64(b.N) * 1000
+			for {
+				statsd.Lock()
+				var value int64
+				for _, m := range statsd.counters {
+					value = m.fields["value"].(int64)
+				}
+				statsd.Unlock()
+				if value == expected {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			b.ReportMetric(float64(expected)/b.Elapsed().Seconds(), "lines/s")
+		})
+	}
+}
+
+func TestParseEscapedTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected string
+		tags     map[string]string
+	}{
+		{
+			name:     "escaped comma",
+			line:     `requests,team=pay\,roll,region=eu:1|c`,
+			expected: "requests",
+			tags:     map[string]string{"team": "pay,roll", "region": "eu"},
+		},
+		{
+			name:     "escaped equal sign",
+			line:     `requests,query=a\=b,key\=x=y:1|c`,
+			expected: "requests",
+			tags:     map[string]string{"query": "a=b", "key=x": "y"},
+		},
+		{
+			name:     "escaped space",
+			line:     `requests,my\ key=a\ b:1|c`,
+			expected: "requests",
+			tags:     map[string]string{"my key": "a b"},
+		},
+		{
+			name:     "escaped name",
+			line:     `http\,requests,host=a:1|c`,
+			expected: "http,requests",
+			tags:     map[string]string{"host": "a"},
+		},
+		{
+			name:     "other backslashes are kept",
+			line:     `requests,path=a\b:1|c`,
+			expected: "requests",
+			tags:     map[string]string{"path": `a\b`},
+		},
+		{
+			name:     "unescaped equal sign in value",
+			line:     `requests,uri=/a?b=c\,d:1|c`,
+			expected: "requests",
+			tags:     map[string]string{"uri": "/a?b=c,d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStatsd()
+			require.NoError(t, s.parseStatsdLine(tt.line))
+
+			tt.tags["metric_type"] = "counter"
+			var acc testutil.Accumulator
+			require.NoError(t, s.Gather(&acc))
+			acc.AssertContainsTaggedFields(t, tt.expected, map[string]interface{}{"value": int64(1)}, tt.tags)
+		})
+	}
+}
+
+func TestDropReasons(t *testing.T) {
+	logger := &testutil.CaptureLogger{}
+	s := newTestStatsd()
+	s.Log = logger
+	s.TypeConflictPolicy = "first_type_wins"
+	s.MaxTagsetBytes = 32
+	s.MaxTagsetAction = "drop"
+	s.MaxLinesPerSecondPerSource = 2
+	s.PauseMode = "discard"
+	s.DataDogExtensions = true
+	s.DataDogTimestampWindow = config.Duration(time.Hour)
+	s.LogDropSummaryInterval = config.Duration(time.Minute)
+	f, err := filter.NewIncludeExcludeFilter(nil, []string{"debug_*"})
+	require.NoError(t, err)
+	s.nameFilter = f
+
+	parse := func(addr string, lines ...string) {
+		payload := strings.Join(lines, "\n")
+		require.NoError(t, s.parseInput(input{Buffer: bytes.NewBufferString(payload), Time: time.Now(), Addr: addr}))
+	}
+	parse("",
+		"requests:1|c",
+		"requests:1|g",
+		"debug_requests:1|c",
+		"debug_latency:1|ms",
+		"latency:-1|ms",
+		"requests:1|",
+		"requests,payload="+strings.Repeat("x", 64)+":1|c",
+		"temperature:1|g",
+		"requests:1|c|T1000000000",
+	)
+
+	// Coalesced counters conflicting with the type count each line
+	s.CoalesceCounters = true
+	parse("", "temperature:1|c", "temperature:1|c", "temperature:1|c")
+	s.CoalesceCounters = false
+	limited := bytes.NewBufferString("a:1|c\na:1|c\na:1|c")
+	require.True(t, s.limitSource(limited, "10.0.0.1", time.Now()))
+	require.NoError(t, s.parseInput(input{Buffer: limited, Time: time.Now(), Addr: "10.0.0.1"}))
+	s.Pause()
+	parse("", "a:1|c", "", "a:1|c")
+	s.Resume()
+
+	expected := map[string]int64{
+		"type_conflict":    4,
+		"timestamp_window": 1,
+		"name_filter":      2,
+		"negative_timing":  1,
+		"empty_type":       1,
+		"oversized_tagset": 1,
+		"rate_limited":     1,
+		"paused":           2,
+	}
+	for _, reason := range dropReasons {
+		require.Equal(t, expected[reason], s.Stats.Dropped[reason].Get(), reason)
+	}
+	snapshot := s.Snapshot()
+	require.Equal(t, int64(2), snapshot.Dropped["name_filter"])
+
+	// The summary is logged once per interval with the drops since the last
+	// summary, the evicted series are reported separately
+	s.Stats.CacheEvictions.Incr(2)
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	var summaries []string
+	for _, entry := range logger.Messages() {
+		if strings.Contains(entry.Text, "Dropped lines") {
+			summaries = append(summaries, entry.Text)
+		}
+	}
+	require.Len(t, summaries, 1)
+	require.Contains(t, summaries[0], "paused=2, rate_limited=1, empty_type=1, negative_timing=1, "+
+		"name_filter=2, oversized_tagset=1, type_conflict=4, timestamp_window=1")
+	var evictions []string
+	for _, entry := range logger.Messages() {
+		if strings.Contains(entry.Text, "Evicted") {
+			evictions = append(evictions, entry.Text)
+		}
+	}
+	require.Equal(t, []string{"Evicted 2 cached series since the last summary"}, evictions)
+
+	parse("", "debug_requests:1|c")
+	require.NoError(t, s.Gather(&acc))
+	s.dropSummaryTime = time.Time{}
+	require.NoError(t, s.Gather(&acc))
+	summaries = summaries[:0]
+	for _, entry := range logger.Messages() {
+		if strings.Contains(entry.Text, "Dropped lines") {
+			summaries = append(summaries, entry.Text)
+		}
+	}
+	require.Len(t, summaries, 2)
+	require.Contains(t, summaries[1], "Dropped lines since the last summary: name_filter=1")
+}
+
+func TestUDPContentEncodingGzip(t *testing.T) {
+	plugin := &Statsd{
+		Log:                    testutil.Logger{},
+		Protocol:               "udp",
+		ServiceAddress:         "localhost:0",
+		AllowedPendingMessages: 10,
+		NumberWorkerThreads:    1,
+		ContentEncoding:        "gzip",
+		MaxDecompressionSize:   1024,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Start(&acc))
+	defer plugin.Stop()
+	failed := plugin.Stats.Dropped["decoding_failed"].Get()
+
+	conn, err := net.Dial("udp", plugin.UDPlistener.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	compress := func(payload string) []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write([]byte(payload))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	// Uncompressed datagrams and decompression bombs are dropped
+	_, err = conn.Write([]byte("plain:1|c"))
+	require.NoError(t, err)
+	_, err = conn.Write(compress("bomb:1|c\n" + strings.Repeat("\n", 2048)))
+	require.NoError(t, err)
+	_, err = conn.Write(compress("requests:1|c\nrequests:2|c\nlatency:5|ms\nload:0.5|g"))
+	require.NoError(t, err)
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"requests",
+			map[string]string{"metric_type": "counter"},
+			map[string]interface{}{"value": int64(3)},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"load",
+			map[string]string{"metric_type": "gauge"},
+			map[string]interface{}{"value": 0.5},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+	}
+	require.Eventually(t, func() bool {
+		plugin.Lock()
+		defer plugin.Unlock()
+		return len(plugin.timings) == 1 && len(plugin.gauges) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, failed+2, plugin.Stats.Dropped["decoding_failed"].Get())
+
+	require.NoError(t, plugin.Gather(&acc))
+	testutil.RequireMetricsSubset(t, expected, acc.GetTelegrafMetrics(), testutil.IgnoreTime())
+	require.True(t, acc.HasMeasurement("latency"))
+	require.False(t, acc.HasMeasurement("plain"))
+	require.False(t, acc.HasMeasurement("bomb"))
+}
+
+func TestInvalidContentEncoding(t *testing.T) {
+	plugin := &Statsd{
+		Log:             testutil.Logger{},
+		Protocol:        "udp",
+		ServiceAddress:  "localhost:0",
+		ContentEncoding: "brotli",
+	}
+	var acc testutil.Accumulator
+	require.ErrorContains(t, plugin.Start(&acc), `invalid content_encoding "brotli"`)
+}
+
+func TestTCPProxyProtocol(t *testing.T) {
+	logger := &testutil.CaptureLogger{}
+	plugin := &Statsd{
+		Log:                    logger,
+		Protocol:               "tcp",
+		ServiceAddress:         "127.0.0.1:0",
+		AllowedPendingMessages: 10,
+		MaxTCPConnections:      10,
+		NumberWorkerThreads:    1,
+		SourceIPTag:            "source_ip",
+		SourcePortTag:          "source_port",
+		TCPProxyProtocol:       true,
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, plugin.Start(&acc))
+	defer plugin.Stop()
+
+	send := func(header []byte, payload string) {
+		conn, err := net.Dial("tcp", plugin.TCPlistener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write(append(header, payload...))
+		require.NoError(t, err)
+	}
+	send([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 8125\r\n"), "v1:1|c\n")
+	send(proxyV2Header(1, 0x21,
+		netip.MustParseAddrPort("[2001:db8::1]:40000"),
+		netip.MustParseAddrPort("[2001:db8::2]:8125"),
+	), "v2:1|c\n")
+	send([]byte("PROXY TCP4 192.0.2.1\r\n"), "malformed:1|c\n")
+
+	require.Eventually(t, func() bool {
+		return len(logger.Errors()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Contains(t, logger.Errors()[0], "Reading PROXY protocol header from 127.0.0.1 failed")
+
+	require.Eventually(t, func() bool {
+		require.NoError(t, plugin.Gather(&acc))
+		return acc.HasMeasurement("v1") && acc.HasMeasurement("v2")
+	}, 5*time.Second, 10*time.Millisecond)
+	acc.AssertContainsTaggedFields(t, "v1",
+		map[string]interface{}{"value": int64(1)},
+		map[string]string{"metric_type": "counter", "source_ip": "192.0.2.1", "source_port": "56324"},
+	)
+	acc.AssertContainsTaggedFields(t, "v2",
+		map[string]interface{}{"value": int64(1)},
+		map[string]string{"metric_type": "counter", "source_ip": "2001:db8::1", "source_port": "40000"},
+	)
+	require.False(t, acc.HasMeasurement("malformed"))
+}
@@ -3,6 +3,8 @@ package filepath
 
 import (
 	_ "embed"
+	"errors"
+	"fmt"
 	"path/filepath"
 	"strings"
 
@@ -14,12 +16,20 @@ import (
 var sampleConfig string
 
 type Filepath struct {
-	BaseName []baseOpts `toml:"basename"`
-	DirName  []baseOpts `toml:"dirname"`
-	Stem     []baseOpts `toml:"stem"`
-	Clean    []baseOpts `toml:"clean"`
-	Rel      []relOpts  `toml:"rel"`
-	ToSlash  []baseOpts `toml:"toslash"`
+	BaseName  []baseOpts     `toml:"basename"`
+	DirName   []baseOpts     `toml:"dirname"`
+	Ancestor  []ancestorOpts `toml:"ancestor"`
+	Stem      []baseOpts     `toml:"stem"`
+	Clean     []baseOpts     `toml:"clean"`
+	Rel       []relOpts      `toml:"rel"`
+	ToSlash   []baseOpts     `toml:"toslash"`
+	Canonical []baseOpts     `toml:"canonical"`
+	GlobCount []globOpts     `toml:"glob_count"`
+	SkipEmpty bool           `toml:"skip_empty"`
+	Annotate  string         `toml:"annotate"`
+
+	// AllowFilesystemAccess permits functions accessing the filesystem
+	AllowFilesystemAccess bool `toml:"allow_filesystem_access"`
 
 	Log telegraf.Logger `toml:"-"`
 }
@@ -28,9 +38,41 @@ type processorFunc func(s string) string
 
 // baseOpts contains options applicable to every function
 type baseOpts struct {
+	Field     string
+	Tag       string
+	Dest      string
+	Delimiter string
+	Condition condition
+}
+
+// condition restricts a function to metrics with the given tag or field value
+type condition struct {
 	Field string
 	Tag   string
-	Dest  string
+	Value string
+}
+
+// matches returns true if the metric satisfies the condition, an empty
+// condition matches all metrics
+func (c *condition) matches(metric telegraf.Metric) bool {
+	if c.Tag != "" {
+		if v, ok := metric.GetTag(c.Tag); !ok || v != c.Value {
+			return false
+		}
+	}
+
+	if c.Field != "" {
+		// Only string fields are considered
+		v, ok := metric.GetField(c.Field)
+		if !ok {
+			return false
+		}
+		if v, ok := v.(string); !ok || v != c.Value {
+			return false
+		}
+	}
+
+	return true
 }
 
 type relOpts struct {
@@ -38,10 +80,45 @@ type relOpts struct {
 	BasePath string
 }
 
+type ancestorOpts struct {
+	baseOpts
+	Levels int
+}
+
+// globOpts contains the options of the glob_count function, the pattern is
+// joined to the path if given
+type globOpts struct {
+	baseOpts
+	Pattern string
+}
+
 func (*Filepath) SampleConfig() string {
 	return sampleConfig
 }
 
+func (o *Filepath) Init() error {
+	for _, v := range o.Ancestor {
+		if v.Levels < 1 {
+			return fmt.Errorf("invalid ancestor levels %d, must be at least 1", v.Levels)
+		}
+	}
+	for _, v := range o.GlobCount {
+		if !o.AllowFilesystemAccess {
+			return errors.New("glob_count requires allow_filesystem_access to be enabled")
+		}
+		if v.Dest == "" {
+			return errors.New("glob_count requires a dest field")
+		}
+		if v.Delimiter != "" {
+			return errors.New("glob_count does not support a delimiter")
+		}
+		if _, err := filepath.Match(v.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid glob_count pattern %q: %w", v.Pattern, err)
+		}
+	}
+	return nil
+}
+
 func (o *Filepath) Apply(in ...telegraf.Metric) []telegraf.Metric {
 	for _, m := range in {
 		o.processMetric(m)
@@ -50,16 +127,30 @@ func (o *Filepath) Apply(in ...telegraf.Metric) []telegraf.Metric {
 	return in
 }
 
-// applyFunc applies the specified function to the metric
-func applyFunc(bo baseOpts, fn processorFunc, metric telegraf.Metric) {
+// applyFunc applies the specified function to the metric and reports whether
+// any value was changed
+func (o *Filepath) applyFunc(bo baseOpts, fn processorFunc, metric telegraf.Metric) bool {
+	if !bo.Condition.matches(metric) {
+		return false
+	}
+
+	var changed bool
+	if bo.Delimiter != "" {
+		fn = listFunc(fn, bo.Delimiter)
+	}
+
 	if bo.Tag != "" {
-		if v, ok := metric.GetTag(bo.Tag); ok {
+		if v, ok := metric.GetTag(bo.Tag); ok && (v != "" || !o.SkipEmpty) {
 			targetTag := bo.Tag
 
 			if bo.Dest != "" {
 				targetTag = bo.Dest
 			}
-			metric.AddTag(targetTag, fn(v))
+			result := fn(v)
+			if prev, ok := metric.GetTag(targetTag); !ok || prev != result {
+				changed = true
+			}
+			metric.AddTag(targetTag, result)
 		}
 	}
 
@@ -72,54 +163,154 @@ func applyFunc(bo baseOpts, fn processorFunc, metric telegraf.Metric) {
 			}
 
 			// Only string fields are considered
+			if v, ok := v.(string); ok && (v != "" || !o.SkipEmpty) {
+				result := fn(v)
+				if prev, ok := metric.GetField(targetField); !ok || prev != result {
+					changed = true
+				}
+				metric.AddField(targetField, result)
+			}
+		}
+	}
+
+	return changed
+}
+
+// applyGlobCount stores the number of files matching the path used as glob
+// pattern in the destination field and reports whether the field was changed
+func (o *Filepath) applyGlobCount(g globOpts, metric telegraf.Metric) bool {
+	if !g.Condition.matches(metric) {
+		return false
+	}
+
+	var pattern string
+	var found bool
+	if g.Tag != "" {
+		pattern, found = metric.GetTag(g.Tag)
+	}
+	if g.Field != "" {
+		// Only string fields are considered
+		if v, ok := metric.GetField(g.Field); ok {
 			if v, ok := v.(string); ok {
-				metric.AddField(targetField, fn(v))
+				pattern, found = v, true
 			}
 		}
 	}
+	if !found || (pattern == "" && o.SkipEmpty) {
+		return false
+	}
+	if g.Pattern != "" {
+		pattern = filepath.Join(pattern, g.Pattern)
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		o.Log.Errorf("filepath processor failed to glob %s: %v", pattern, err)
+		return false
+	}
+	count := int64(len(matches))
+	if prev, ok := metric.GetField(g.Dest); ok && prev == count {
+		return false
+	}
+	metric.AddField(g.Dest, count)
+	return true
+}
+
+// listFunc wraps the given function to apply it to each element of a list
+// separated by the delimiter, keeping empty elements as they are
+func listFunc(fn processorFunc, delimiter string) processorFunc {
+	return func(s string) string {
+		elements := strings.Split(s, delimiter)
+		for i, element := range elements {
+			if element != "" {
+				elements[i] = fn(element)
+			}
+		}
+		return strings.Join(elements, delimiter)
+	}
+}
+
+// canonicalFilePath returns the cleaned, slash-separated and lowercased path
+func canonicalFilePath(path string) string {
+	return strings.ToLower(filepath.ToSlash(filepath.Clean(path)))
 }
 
 func stemFilePath(path string) string {
 	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
 }
 
+// ancestorFilePath returns the ancestor directory the given number of levels
+// above the path, stopping at the root
+func ancestorFilePath(path string, levels int) string {
+	path = filepath.Dir(path)
+	for range levels - 1 {
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return path
+}
+
 // processMetric processes fields and tag values for a given metric applying the selected transformations
 func (o *Filepath) processMetric(metric telegraf.Metric) {
+	var changed bool
+
 	// Stem
 	for _, v := range o.Stem {
-		applyFunc(v, stemFilePath, metric)
+		changed = o.applyFunc(v, stemFilePath, metric) || changed
 	}
 	// Basename
 	for _, v := range o.BaseName {
-		applyFunc(v, filepath.Base, metric)
+		changed = o.applyFunc(v, filepath.Base, metric) || changed
 	}
 	// Rel
 	for _, v := range o.Rel {
-		applyFunc(v.baseOpts, func(s string) string {
+		changed = o.applyFunc(v.baseOpts, func(s string) string {
 			relPath, err := filepath.Rel(v.BasePath, s)
 			if err != nil {
 				o.Log.Errorf("filepath processor failed to process relative filepath %s: %v", s, err)
 				return v.BasePath
 			}
 			return relPath
-		}, metric)
+		}, metric) || changed
 	}
 	// Dirname
 	for _, v := range o.DirName {
-		applyFunc(v, filepath.Dir, metric)
+		changed = o.applyFunc(v, filepath.Dir, metric) || changed
+	}
+	// Ancestor
+	for _, v := range o.Ancestor {
+		changed = o.applyFunc(v.baseOpts, func(s string) string {
+			return ancestorFilePath(s, v.Levels)
+		}, metric) || changed
 	}
 	// Clean
 	for _, v := range o.Clean {
-		applyFunc(v, filepath.Clean, metric)
+		changed = o.applyFunc(v, filepath.Clean, metric) || changed
 	}
 	// ToSlash
 	for _, v := range o.ToSlash {
-		applyFunc(v, filepath.ToSlash, metric)
+		changed = o.applyFunc(v, filepath.ToSlash, metric) || changed
+	}
+	// Canonical
+	for _, v := range o.Canonical {
+		changed = o.applyFunc(v, canonicalFilePath, metric) || changed
+	}
+	// GlobCount
+	for _, v := range o.GlobCount {
+		changed = o.applyGlobCount(v, metric) || changed
+	}
+
+	// Mark the metric as transformed
+	if changed && o.Annotate != "" {
+		metric.AddTag(o.Annotate, "true")
 	}
 }
 
 func init() {
 	processors.Add("filepath", func() telegraf.Processor {
-		return &Filepath{}
+		return &Filepath{SkipEmpty: true}
 	})
 }
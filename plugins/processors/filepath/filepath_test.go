@@ -3,6 +3,9 @@
 package filepath
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -132,3 +135,320 @@ func TestTracking(t *testing.T) {
 		return len(input) == len(delivered)
 	}, time.Second, 100*time.Millisecond, "%d delivered but %d expected", len(delivered), len(expected))
 }
+
+func TestAncestor(t *testing.T) {
+	tests := []testCase{
+		{
+			name: "one level",
+			o: &Filepath{
+				Ancestor: []ancestorOpts{{baseOpts: baseOpts{Tag: "path"}, Levels: 1}},
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{"path": "/var/log/batch/ajob.log"}, map[string]interface{}{"value": 1}, time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{"path": "/var/log/batch"}, map[string]interface{}{"value": 1}, time.Now()),
+			},
+		},
+		{
+			name: "two levels",
+			o: &Filepath{
+				Ancestor: []ancestorOpts{{baseOpts: baseOpts{Field: "path", Dest: "folder"}, Levels: 2}},
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{}, map[string]interface{}{"path": "/var/log/batch/ajob.log"}, time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{}, map[string]interface{}{
+					"path":   "/var/log/batch/ajob.log",
+					"folder": "/var/log",
+				}, time.Now()),
+			},
+		},
+		{
+			name: "levels exceeding depth",
+			o: &Filepath{
+				Ancestor: []ancestorOpts{
+					{baseOpts: baseOpts{Tag: "path"}, Levels: 10},
+					{baseOpts: baseOpts{Tag: "relative"}, Levels: 10},
+				},
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{
+					"path":     "/var/log/batch/ajob.log",
+					"relative": "log/batch/ajob.log",
+				}, map[string]interface{}{"value": 1}, time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{
+					"path":     "/",
+					"relative": ".",
+				}, map[string]interface{}{"value": 1}, time.Now()),
+			},
+		},
+	}
+	runTestOptionsApply(t, tests)
+}
+
+func TestAncestorInit(t *testing.T) {
+	for _, levels := range []int{0, -1} {
+		plugin := &Filepath{Ancestor: []ancestorOpts{{baseOpts: baseOpts{Tag: "path"}, Levels: levels}}}
+		require.ErrorContains(t, plugin.Init(), fmt.Sprintf("invalid ancestor levels %d", levels))
+	}
+	require.NoError(t, (&Filepath{Ancestor: []ancestorOpts{{baseOpts: baseOpts{Tag: "path"}, Levels: 1}}}).Init())
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []testCase{
+		{
+			name: "mixed case",
+			o: &Filepath{
+				Canonical: []baseOpts{{Tag: "path", Field: "path", Dest: "key"}},
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test",
+					map[string]string{"path": "/Var/Log/dummy/../Batch//AJob.log"},
+					map[string]interface{}{"path": "/var/LOG/batch/./ajob.LOG"},
+					time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test",
+					map[string]string{"path": "/Var/Log/dummy/../Batch//AJob.log", "key": "/var/log/batch/ajob.log"},
+					map[string]interface{}{"path": "/var/LOG/batch/./ajob.LOG", "key": "/var/log/batch/ajob.log"},
+					time.Now()),
+			},
+		},
+	}
+	runTestOptionsApply(t, tests)
+}
+
+func TestSkipEmpty(t *testing.T) {
+	tests := []testCase{
+		{
+			name: "skip empty",
+			o: &Filepath{
+				DirName:   []baseOpts{{Tag: "path", Field: "path"}},
+				BaseName:  []baseOpts{{Tag: "file", Dest: "base"}},
+				SkipEmpty: true,
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test",
+					map[string]string{"path": "", "file": ""},
+					map[string]interface{}{"path": ""},
+					time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test",
+					map[string]string{"path": "", "file": ""},
+					map[string]interface{}{"path": ""},
+					time.Now()),
+			},
+		},
+		{
+			name: "process empty",
+			o: &Filepath{
+				DirName:  []baseOpts{{Tag: "path", Field: "path"}},
+				BaseName: []baseOpts{{Tag: "file", Dest: "base"}},
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test",
+					map[string]string{"path": "", "file": ""},
+					map[string]interface{}{"path": ""},
+					time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test",
+					map[string]string{"path": ".", "file": "", "base": "."},
+					map[string]interface{}{"path": "."},
+					time.Now()),
+			},
+		},
+	}
+	runTestOptionsApply(t, tests)
+}
+
+func TestAnnotate(t *testing.T) {
+	plugin := &Filepath{
+		Clean:    []baseOpts{{Tag: "path"}},
+		Annotate: "filepath_processed",
+	}
+
+	input := []telegraf.Metric{
+		testutil.MustMetric("changed", map[string]string{"path": "/var/log/../log/ajob.log"}, map[string]interface{}{"value": 1}, time.Unix(0, 0)),
+		testutil.MustMetric("clean", map[string]string{"path": "/var/log/ajob.log"}, map[string]interface{}{"value": 1}, time.Unix(0, 0)),
+		testutil.MustMetric("missing", map[string]string{}, map[string]interface{}{"value": 1}, time.Unix(0, 0)),
+	}
+	expected := []telegraf.Metric{
+		testutil.MustMetric("changed",
+			map[string]string{"path": "/var/log/ajob.log", "filepath_processed": "true"},
+			map[string]interface{}{"value": 1},
+			time.Unix(0, 0)),
+		testutil.MustMetric("clean", map[string]string{"path": "/var/log/ajob.log"}, map[string]interface{}{"value": 1}, time.Unix(0, 0)),
+		testutil.MustMetric("missing", map[string]string{}, map[string]interface{}{"value": 1}, time.Unix(0, 0)),
+	}
+
+	actual := plugin.Apply(input...)
+	testutil.RequireMetricsEqual(t, expected, actual)
+}
+
+func TestDelimiter(t *testing.T) {
+	tests := []testCase{
+		{
+			name: "basename of list field",
+			o: &Filepath{
+				BaseName: []baseOpts{{Field: "paths", Delimiter: ","}},
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{},
+					map[string]interface{}{"paths": "/var/log/a.log,/var/log/batch/b.log,c.log"},
+					time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{},
+					map[string]interface{}{"paths": "a.log,b.log,c.log"},
+					time.Now()),
+			},
+		},
+		{
+			name: "empty elements and trailing delimiter",
+			o: &Filepath{
+				DirName: []baseOpts{{Field: "paths", Dest: "dirs", Delimiter: ";"}},
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{},
+					map[string]interface{}{"paths": "/var/log/a.log;;/tmp/b.log;"},
+					time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{},
+					map[string]interface{}{"paths": "/var/log/a.log;;/tmp/b.log;", "dirs": "/var/log;;/tmp;"},
+					time.Now()),
+			},
+		},
+	}
+	runTestOptionsApply(t, tests)
+}
+
+func TestCondition(t *testing.T) {
+	plugin := &Filepath{
+		Rel: []relOpts{
+			{
+				baseOpts: baseOpts{
+					Tag:       "path",
+					Condition: condition{Tag: "source", Value: "nginx"},
+				},
+				BasePath: "/var/log/nginx",
+			},
+		},
+		BaseName: []baseOpts{
+			{
+				Field:     "file",
+				Condition: condition{Field: "kind", Value: "log"},
+			},
+		},
+	}
+
+	input := []telegraf.Metric{
+		testutil.MustMetric("test",
+			map[string]string{"path": "/var/log/nginx/access.log", "source": "nginx"},
+			map[string]interface{}{"file": "/var/log/a.log", "kind": "log"},
+			time.Unix(0, 0)),
+		testutil.MustMetric("test",
+			map[string]string{"path": "/var/log/nginx/access.log", "source": "batch"},
+			map[string]interface{}{"file": "/var/log/a.log", "kind": "data"},
+			time.Unix(0, 0)),
+		testutil.MustMetric("test",
+			map[string]string{"path": "/var/log/nginx/access.log"},
+			map[string]interface{}{"file": "/var/log/a.log"},
+			time.Unix(0, 0)),
+	}
+	expected := []telegraf.Metric{
+		testutil.MustMetric("test",
+			map[string]string{"path": "access.log", "source": "nginx"},
+			map[string]interface{}{"file": "a.log", "kind": "log"},
+			time.Unix(0, 0)),
+		testutil.MustMetric("test",
+			map[string]string{"path": "/var/log/nginx/access.log", "source": "batch"},
+			map[string]interface{}{"file": "/var/log/a.log", "kind": "data"},
+			time.Unix(0, 0)),
+		testutil.MustMetric("test",
+			map[string]string{"path": "/var/log/nginx/access.log"},
+			map[string]interface{}{"file": "/var/log/a.log"},
+			time.Unix(0, 0)),
+	}
+
+	actual := plugin.Apply(input...)
+	testutil.RequireMetricsEqual(t, expected, actual)
+}
+
+func TestGlobCount(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log", "c.log", "d.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0600))
+	}
+
+	tests := []testCase{
+		{
+			name: "joined pattern",
+			o: &Filepath{
+				GlobCount: []globOpts{{baseOpts: baseOpts{Tag: "dir", Dest: "count"}, Pattern: "*.log"}},
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{"dir": dir}, map[string]interface{}{"value": 1}, time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{"dir": dir}, map[string]interface{}{"value": 1, "count": int64(3)}, time.Now()),
+			},
+		},
+		{
+			name: "value as pattern",
+			o: &Filepath{
+				GlobCount: []globOpts{{baseOpts: baseOpts{Field: "glob", Dest: "count"}}},
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", nil, map[string]interface{}{"glob": filepath.Join(dir, "*")}, time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", nil, map[string]interface{}{"glob": filepath.Join(dir, "*"), "count": int64(4)}, time.Now()),
+			},
+		},
+		{
+			name: "no match",
+			o: &Filepath{
+				GlobCount: []globOpts{{baseOpts: baseOpts{Tag: "dir", Dest: "count"}, Pattern: "*.csv"}},
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{"dir": dir}, map[string]interface{}{"value": 1}, time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{"dir": dir}, map[string]interface{}{"value": 1, "count": int64(0)}, time.Now()),
+			},
+		},
+		{
+			name: "invalid pattern",
+			o: &Filepath{
+				GlobCount: []globOpts{{baseOpts: baseOpts{Tag: "dir", Dest: "count"}}},
+				Log:       testutil.Logger{},
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{"dir": "[a-"}, map[string]interface{}{"value": 1}, time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test", map[string]string{"dir": "[a-"}, map[string]interface{}{"value": 1}, time.Now()),
+			},
+		},
+	}
+	runTestOptionsApply(t, tests)
+}
+
+func TestGlobCountInit(t *testing.T) {
+	opts := []globOpts{{baseOpts: baseOpts{Tag: "dir", Dest: "count"}, Pattern: "*.log"}}
+	require.ErrorContains(t, (&Filepath{GlobCount: opts}).Init(), "allow_filesystem_access")
+	require.NoError(t, (&Filepath{GlobCount: opts, AllowFilesystemAccess: true}).Init())
+
+	opts = []globOpts{{baseOpts: baseOpts{Tag: "dir"}}}
+	require.ErrorContains(t, (&Filepath{GlobCount: opts, AllowFilesystemAccess: true}).Init(), "dest")
+
+	opts = []globOpts{{baseOpts: baseOpts{Tag: "dir", Dest: "count"}, Pattern: "[a-"}}
+	require.ErrorContains(t, (&Filepath{GlobCount: opts, AllowFilesystemAccess: true}).Init(), "invalid glob_count pattern")
+}
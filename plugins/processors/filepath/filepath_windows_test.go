@@ -41,3 +41,33 @@ func TestOptions_Apply(t *testing.T) {
 	}
 	runTestOptionsApply(t, tests)
 }
+
+func TestCanonical(t *testing.T) {
+	tests := []testCase{
+		{
+			name: "mixed case windows path",
+			o: &Filepath{
+				Canonical: []baseOpts{{Tag: "path"}, {Tag: "other"}},
+			},
+			inputMetrics: []telegraf.Metric{
+				testutil.MustMetric("test",
+					map[string]string{
+						"path":  "C:\\Logs\\dummy\\..\\Batch\\\\AJob.log",
+						"other": "c:/LOGS/batch/ajob.LOG",
+					},
+					map[string]interface{}{"value": 1},
+					time.Now()),
+			},
+			expectedMetrics: []telegraf.Metric{
+				testutil.MustMetric("test",
+					map[string]string{
+						"path":  "c:/logs/batch/ajob.log",
+						"other": "c:/logs/batch/ajob.log",
+					},
+					map[string]interface{}{"value": 1},
+					time.Now()),
+			},
+		},
+	}
+	runTestOptionsApply(t, tests)
+}